@@ -0,0 +1,122 @@
+// Command apidiff compares the exported API of two checkouts of a repository.
+// It prints the changes, and optionally writes them as an HTML page and a JSON
+// summary, e.g. to the termination message of its container:
+//
+//	apidiff -base base -head head -import-path github.com/org/repo \
+//		-html /out/apidiff.html -summary /dev/termination-log
+//
+// Given the files changed between the checkouts with -touched, the doc
+// comments of the exported API added or changed in them are linted as well.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/droot/godocbot/pkg/apidiff"
+	"github.com/droot/godocbot/pkg/gomod"
+)
+
+var (
+	baseDir     = flag.String("base", "", "directory of the checkout of the base commit")
+	headDir     = flag.String("head", "", "directory of the checkout of the head commit")
+	importPath  = flag.String("import-path", "", "import path of the root of the checkouts, e.g. github.com/org/repo. The module path of each checkout with a go.mod file is used instead")
+	htmlFile    = flag.String("html", "", "file to write the changes to as an HTML page, if set")
+	title       = flag.String("title", "", "title of the HTML page, defaults to the import path")
+	summaryFile = flag.String("summary", "", "file to write a JSON summary of the changes to, if set")
+	maxChanges  = flag.Int("summary-max-changes", 20, "maximum number of changes and of doc comment problems described in the summary")
+	maxBytes    = flag.Int("summary-max-bytes", 4096, "maximum size of the summary, the size of container termination messages is limited to 4096 bytes")
+	touchedFile = flag.String("touched", "", "file listing the files changed in the head, relative to its root, one per line. If set, their doc comments are linted")
+)
+
+func main() {
+	flag.Parse()
+	if *baseDir == "" || *headDir == "" || *importPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// the module path may change between the checkouts, in which case all
+	// the packages show as removed and added.
+	headImportPath := treeImportPath(*headDir)
+	base, err := apidiff.Load(*baseDir, treeImportPath(*baseDir))
+	if err != nil {
+		log.Fatalf("failed to load the API of the base: %v", err)
+	}
+	head, err := apidiff.Load(*headDir, headImportPath)
+	if err != nil {
+		log.Fatalf("failed to load the API of the head: %v", err)
+	}
+	report := apidiff.Diff(base, head)
+	for _, c := range report.Changes {
+		fmt.Println(c)
+	}
+	if *touchedFile != "" {
+		touched, err := ioutil.ReadFile(*touchedFile)
+		if err != nil {
+			log.Fatalf("failed to read the touched files: %v", err)
+		}
+		for _, p := range report.Lint(head, strings.Fields(string(touched))) {
+			fmt.Println(p)
+		}
+	}
+
+	if *htmlFile != "" {
+		if *title == "" {
+			*title = "API changes of " + headImportPath
+		}
+		f, err := os.Create(*htmlFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = report.WriteHTML(f, *title); err != nil {
+			log.Fatalf("failed to write the HTML report: %v", err)
+		}
+		if err = f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *summaryFile != "" {
+		summary, err := marshalSummary(report.Summary(*maxChanges), *maxBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = ioutil.WriteFile(*summaryFile, summary, 0644); err != nil {
+			log.Fatalf("failed to write the summary: %v", err)
+		}
+	}
+}
+
+// treeImportPath returns the import path of the root of the checkout in dir,
+// the path of its module if it has a go.mod file.
+func treeImportPath(dir string) string {
+	if path := gomod.DirModulePath(dir); path != "" {
+		return path
+	}
+	return *importPath
+}
+
+// marshalSummary returns the JSON of the summary, from which changes and
+// problems are dropped until it fits in maxBytes.
+func marshalSummary(s *apidiff.Summary, maxBytes int) ([]byte, error) {
+	for {
+		b, err := json.Marshal(s)
+		if err != nil || len(b) <= maxBytes {
+			return b, err
+		}
+		switch {
+		case len(s.Changes) == 0 && len(s.Lint) == 0:
+			return nil, fmt.Errorf("summary does not fit in %d bytes", maxBytes)
+		case len(s.Changes) >= len(s.Lint):
+			s.Changes = s.Changes[:len(s.Changes)-1]
+		default:
+			s.Lint = s.Lint[:len(s.Lint)-1]
+		}
+	}
+}
@@ -1,31 +1,137 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"strings"
+	"time"
 
 	// Import auth/gcp to connect to GKE clusters remotely
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
 	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
 	"github.com/droot/godocbot/pkg/controller/pullrequest"
+	"github.com/droot/godocbot/pkg/githubauth"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/client/config"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
 	logf "github.com/kubernetes-sigs/controller-runtime/pkg/runtime/log"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/runtime/signals"
 
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
 )
 
-var enablePRSync = flag.Bool("enable-pr-sync", false, "if set to true, periodically syncs pullrequest with Github")
+var (
+	enablePRSync       = flag.Bool("enable-pr-sync", false, "if set to true, periodically syncs pullrequest with Github")
+	syncInterval       = flag.Duration("sync-interval", 30*time.Second, "time between two periodic syncs of the PRs, and listings of the open PRs of the repositories, with Github")
+	syncJitter         = flag.Float64("sync-jitter", 0.1, "maximum fraction of the sync interval randomly added to it")
+	syncConcurrency    = flag.Int("sync-concurrency", 1, "number of repos synced with Github concurrently")
+	enableRepositories = flag.Bool("enable-repositories", false, "if set to true, creates PullRequest objects for the open PRs of the repositories registered with Repository objects")
+	enablePRComments   = flag.Bool("enable-pr-comments", false, "if set to true, posts the godoc link as a comment on the Github PR")
+	enableCommitStatus = flag.Bool("enable-commit-status", false, "if set to true, reports the godoc deployment as a commit status on the Github PR")
+	webhookAddr        = flag.String("webhook-addr", "", "address to serve the Github webhook receiver on, e.g. :8080. Disabled if empty")
+	webhookSecretFile  = flag.String("webhook-secret-file", "", "path to the file containing the Github webhook secret")
+	webhookNamespace   = flag.String("webhook-namespace", "default", "namespace to create PullRequest objects in for webhook events")
+
+	metricsAddr = flag.String("metrics-addr", "", "address to serve expvar metrics, including the Github rate limit headroom, on at /debug/vars, e.g. :8081. Disabled if empty")
+
+	admissionAddr     = flag.String("admission-webhook-addr", "", "address to serve the validating and mutating admission webhooks of PullRequest objects on over TLS, at /validate and /mutate, e.g. :9443. Disabled if empty")
+	admissionCertFile = flag.String("admission-webhook-cert-file", "", "path to the PEM encoded serving certificate of the admission webhooks")
+	admissionKeyFile  = flag.String("admission-webhook-key-file", "", "path to the PEM encoded private key of the serving certificate of the admission webhooks")
+
+	exposer            = flag.String("exposer", pullrequest.ExposeSSHTunnel, "how godoc servers are exposed: 'ssh-tunnel', 'ingress' or 'nodeport'")
+	tunnelHost         = flag.String("tunnel-host", "serveo.net", "SSH tunnel service used by the ssh-tunnel exposer")
+	ingressHostPattern = flag.String("ingress-host-pattern", "", "host serving godoc with the ingress exposer, {name}, {org}, {repo} and {pr} are replaced, e.g. {name}.docs.example.com")
+	ingressPathPattern = flag.String("ingress-path-pattern", "", "path serving godoc with the ingress exposer, with the same placeholders as the host pattern, e.g. /pr/{org}/{repo}/{pr}. The path is stripped by a godoc-proxy sidecar and must not be rewritten by the ingress")
+	ingressProxyImage  = flag.String("ingress-proxy-image", "gcr.io/sunilarora-sandbox/godoc-proxy:0.0.1", "image of cmd/godoc-proxy serving godoc under the paths of the ingress path pattern")
+	ingressAnnotations = stringMap{}
+	ingressClass       = flag.String("ingress-class", "", "ingress class of the Ingresses created by the ingress exposer")
+	ingressTLS         = flag.Bool("ingress-tls", false, "if set to true, the godoc links of the ingress exposer use https")
+	nodeHost           = flag.String("node-host", "", "address of the nodes serving godoc with the nodeport exposer")
+
+	closedPRAction      = flag.String("closed-pr-action", pullrequest.ClosedPRDelete, "what to do with PullRequest objects of PRs closed in Github: 'delete' or 'keep' with godoc scaled down to zero")
+	closedPRGracePeriod = flag.Duration("closed-pr-grace-period", 0, "how long godoc keeps being served for a closed PR before its PullRequest object is deleted")
+
+	githubTokenFile      = flag.String("github-token-file", "", "path to the file containing the Github personal access token")
+	githubTokenSecret    = flag.String("github-token-secret", "", "namespace/name of the Secret containing the Github personal access token under the 'token' key")
+	githubAppID          = flag.Int64("github-app-id", 0, "ID of the Github App to authenticate as")
+	githubInstallationID = flag.Int64("github-app-installation-id", 0, "ID of the Github App installation to authenticate as")
+	githubAppKeyFile     = flag.String("github-app-private-key-file", "", "path to the PEM encoded private key of the Github App, e.g. a key of a Secret mounted as a volume")
+	githubAppKeySecret   = flag.String("github-app-private-key-secret", "", "namespace/name of the Secret containing the PEM encoded private key of the Github App under the 'private-key.pem' key")
+
+	godocCPURequest    = flag.String("godoc-cpu-request", "100m", "CPU request of the godoc containers, not set if empty")
+	godocMemoryRequest = flag.String("godoc-memory-request", "256Mi", "memory request of the godoc containers, not set if empty")
+	godocCPULimit      = flag.String("godoc-cpu-limit", "1", "CPU limit of the godoc containers, not set if empty")
+	godocMemoryLimit   = flag.String("godoc-memory-limit", "1Gi", "memory limit of the godoc containers, not set if empty")
+	godocLivenessDelay = flag.Duration("godoc-liveness-initial-delay", 10*time.Minute, "time given to godoc containers to index the repo before their liveness is probed")
+	godocFetchImage    = flag.String("godoc-fetch-image", "gcr.io/sunilarora-sandbox/godoc-fetch:0.0.1", "image of cmd/godoc-fetch checking out the commit of the PRs in the godoc pods and render jobs, along with cmd/godoc-sync in the shared godoc pods")
+	godocGoProxy       = flag.String("godoc-goproxy", "https://proxy.golang.org", "GOPROXY the dependencies of the PRs using Go modules are downloaded from, the go command default if empty")
+
+	godocMode                 = flag.String("godoc-mode", "server", "how godoc is served: 'server' runs a godoc server per PR, 'shared' runs a godoc server per repo serving its PRs side by side, 'static' renders static HTML with a job per commit")
+	staticBaseURL             = flag.String("static-base-url", "", "URL the static server serves the rendered godoc at, e.g. https://docs.example.com")
+	staticRenderImage         = flag.String("static-render-image", "gcr.io/sunilarora-sandbox/godoc:0.0.1", "godoc image rendering static HTML with render_static.sh")
+	staticPVC                 = flag.String("static-pvc", "", "PersistentVolumeClaim the static HTML is copied to, in the namespace of the PullRequests")
+	staticS3Endpoint          = flag.String("static-s3-endpoint", "", "endpoint of the S3 compatible store the static HTML is uploaded to if no PVC is given, e.g. http://minio.minio:9000")
+	staticS3Bucket            = flag.String("static-s3-bucket", "", "bucket the static HTML is uploaded to")
+	staticS3CredentialsSecret = flag.String("static-s3-credentials-secret", "", "Secret holding the 'access-key' and 'secret-key' of the bucket, in the namespace of the PullRequests")
+	apiDiffImage              = flag.String("apidiff-image", "", "image of cmd/apidiff publishing the exported API changes of the PRs next to their godoc, in every godoc mode. Disabled if empty")
+	staticAPIDiffImage        = flag.String("static-apidiff-image", "", "deprecated, use -apidiff-image")
+	breakingChangeLabel       = flag.String("breaking-change-label", "breaking-change", "label of the PRs whose incompatible API changes are intended, the API compatibility commit status fails without it")
+
+	githubEnterpriseURLs       = stringMap{}
+	githubEnterpriseTokenFiles = stringMap{}
+
+	providerTypes      = stringMap{}
+	providerURLs       = stringMap{}
+	providerTokenFiles = stringMap{}
+)
+
+func init() {
+	flag.Var(ingressAnnotations, "ingress-annotation", "key=value annotation set on the Ingresses created by the ingress exposer, can be repeated")
+	flag.Var(githubEnterpriseURLs, "github-enterprise-url", "host=url of the API of a Github Enterprise host, e.g. github.mycorp.com=https://github.mycorp.com/api/v3/, can be repeated")
+	flag.Var(githubEnterpriseTokenFiles, "github-enterprise-token-file", "host=path of the file containing the personal access token of a Github Enterprise host, can be repeated")
+	flag.Var(providerTypes, "provider", "host=type of a code hosting provider serving PRs, type is 'gitlab', 'gitea' or 'bitbucket-server', can be repeated")
+	flag.Var(providerURLs, "provider-url", "host=url of the API of a provider, defaults to https://host, can be repeated")
+	flag.Var(providerTokenFiles, "provider-token-file", "host=path of the file containing the API token of a provider, can be repeated")
+}
+
+// stringMap is a flag collecting repeated key=value pairs.
+type stringMap map[string]string
+
+func (m stringMap) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m stringMap) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("%q is not of the form key=value", value)
+	}
+	m[parts[0]] = parts[1]
+	return nil
+}
 
 // Controller-manager main.
 func main() {
 	flag.Parse()
 	logf.SetLogger(logf.ZapLogger(false))
 
+	cfg := config.GetConfigOrDie()
+
 	// Setup a ControllerManager
-	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{})
+	mgr, err := manager.New(cfg, manager.Options{})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -35,21 +141,285 @@ func main() {
 
 	stop := signals.SetupSignalHandler()
 
-	_, err = pullrequest.NewGodocDeployer(mgr)
+	creds, err := githubCredentials(cfg)
+	if err != nil {
+		log.Fatalf("failed to read the github credentials: %v", err)
+	}
+	httpClient, err := githubauth.NewHTTPClient(creds, "")
+	if err != nil {
+		log.Fatalf("failed to create the github client: %v", err)
+	}
+	ghClients := pullrequest.GithubClients{
+		"github.com": pullrequest.NewGithubClient(github.NewClient(httpClient)),
+	}
+	for host, baseURL := range githubEnterpriseURLs {
+		ghClients[host], err = githubEnterpriseClient(host, baseURL)
+		if err != nil {
+			log.Fatalf("failed to create the github client of %s: %v", host, err)
+		}
+	}
+	// the PR URLs of the provider hosts are parsed with their layout from
+	// now on.
+	providers, err := codeProviders()
+	if err != nil {
+		log.Fatalf("failed to create the code hosting providers: %v", err)
+	}
+
+	var statusClients pullrequest.GithubClients
+	if *enableCommitStatus {
+		statusClients = ghClients
+	}
+	godocExposer, err := pullrequest.NewExposer(pullrequest.ExposerConfig{
+		Type:               *exposer,
+		TunnelHost:         *tunnelHost,
+		IngressHostPattern: *ingressHostPattern,
+		IngressPathPattern: *ingressPathPattern,
+		IngressProxyImage:  *ingressProxyImage,
+		IngressAnnotations: ingressAnnotations,
+		IngressClass:       *ingressClass,
+		IngressTLS:         *ingressTLS,
+		NodeHost:           *nodeHost,
+	})
+	if err != nil {
+		log.Fatalf("failed to create the godoc exposer: %v", err)
+	}
+	resources, err := godocResources()
+	if err != nil {
+		log.Fatalf("invalid godoc resources: %v", err)
+	}
+	var static *pullrequest.StaticConfig
+	switch *godocMode {
+	case "server", "shared":
+	case "static":
+		static = &pullrequest.StaticConfig{
+			BaseURL:             *staticBaseURL,
+			RenderImage:         *staticRenderImage,
+			PVC:                 *staticPVC,
+			S3Endpoint:          *staticS3Endpoint,
+			S3Bucket:            *staticS3Bucket,
+			S3CredentialsSecret: *staticS3CredentialsSecret,
+		}
+	default:
+		log.Fatalf("unknown godoc mode %q, must be 'server', 'shared' or 'static'", *godocMode)
+	}
+	if *apiDiffImage == "" {
+		*apiDiffImage = *staticAPIDiffImage
+	}
+	closedPRPolicy := pullrequest.ClosedPRPolicy{
+		Action:      *closedPRAction,
+		GracePeriod: *closedPRGracePeriod,
+	}
+	_, err = pullrequest.NewGodocDeployer(mgr, pullrequest.GodocDeployerOptions{
+		GithubClients:         statusClients,
+		Exposer:               godocExposer,
+		Resources:             resources,
+		LivenessInitialDelay:  *godocLivenessDelay,
+		Static:                static,
+		Shared:                *godocMode == "shared",
+		APIDiffImage:          *apiDiffImage,
+		BreakingChangeLabel:   *breakingChangeLabel,
+		LabelsRefreshInterval: *syncInterval,
+		FetchImage:            *godocFetchImage,
+		GoProxy:               *godocGoProxy,
+		ClosedPRPolicy:        closedPRPolicy,
+	})
 	if err != nil {
 		log.Fatalf("failed to create godoc deployer: %v", err)
 	}
 
-	_, err = pullrequest.NewGithubSyncer(mgr, *enablePRSync, stop)
+	syncer, err := pullrequest.NewGithubSyncer(mgr, ghClients, pullrequest.GithubSyncerOptions{
+		EnablePRSync:   *enablePRSync,
+		ClosedPRPolicy: closedPRPolicy,
+		Providers:      providers,
+		SyncInterval:   *syncInterval,
+		SyncJitter:     *syncJitter,
+		Concurrency:    *syncConcurrency,
+	}, stop)
 	if err != nil {
 		log.Fatalf("failed to create the github pull request syncer %v", err)
 	}
 
+	if *enableRepositories {
+		_, err = pullrequest.NewRepositoryController(mgr, syncer)
+		if err != nil {
+			log.Fatalf("failed to create the repository controller %v", err)
+		}
+	}
+
+	if *enablePRComments {
+		_, err = pullrequest.NewGithubCommenter(mgr, ghClients)
+		if err != nil {
+			log.Fatalf("failed to create the github pull request commenter %v", err)
+		}
+	}
+
+	if *webhookAddr != "" {
+		secret, err := ioutil.ReadFile(*webhookSecretFile)
+		if err != nil {
+			log.Fatalf("failed to read the webhook secret: %v", err)
+		}
+		_, err = pullrequest.NewGithubWebhook(mgr, *webhookAddr, []byte(strings.TrimSpace(string(secret))), *webhookNamespace, closedPRPolicy)
+		if err != nil {
+			log.Fatalf("failed to create the github webhook receiver: %v", err)
+		}
+	}
+
+	if *admissionAddr != "" {
+		// PRs are served from github.com and the configured hosts.
+		hosts := []string{"github.com"}
+		for host := range githubEnterpriseURLs {
+			hosts = append(hosts, host)
+		}
+		for host := range providerTypes {
+			hosts = append(hosts, host)
+		}
+		_, err = pullrequest.NewAdmissionWebhook(mgr, pullrequest.AdmissionWebhookOptions{
+			Addr:     *admissionAddr,
+			CertFile: *admissionCertFile,
+			KeyFile:  *admissionKeyFile,
+			Hosts:    hosts,
+		})
+		if err != nil {
+			log.Fatalf("failed to create the admission webhooks: %v", err)
+		}
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			// expvar registers its handler on the default mux.
+			log.Fatal(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
 	// start the manager
 	log.Fatal(mgr.Start(stop))
 }
 
+// githubEnterpriseClient returns the client of the Github Enterprise API of
+// host at baseURL, authenticated with the token of the host if there is one.
+func githubEnterpriseClient(host, baseURL string) (pullrequest.GithubClient, error) {
+	var creds githubauth.Credentials
+	if path, found := githubEnterpriseTokenFiles[host]; found {
+		token, err := githubauth.ReadTokenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		creds.Token = token
+	}
+	httpClient, err := githubauth.NewHTTPClient(creds, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	c, err := github.NewEnterpriseClient(baseURL, baseURL, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return pullrequest.NewGithubClient(c), nil
+}
+
+// codeProviders returns the code hosting providers specified by the flags.
+func codeProviders() (pullrequest.Providers, error) {
+	providers := pullrequest.Providers{}
+	for host, typ := range providerTypes {
+		if err := pullrequest.RegisterProviderHost(host, typ); err != nil {
+			return nil, fmt.Errorf("provider of %s: %v", host, err)
+		}
+		cfg := pullrequest.ProviderConfig{
+			Type:    typ,
+			BaseURL: "https://" + host,
+		}
+		if u, found := providerURLs[host]; found {
+			cfg.BaseURL = u
+		}
+		if path, found := providerTokenFiles[host]; found {
+			token, err := githubauth.ReadTokenFile(path)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Token = token
+		}
+		p, err := pullrequest.NewProvider(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("provider of %s: %v", host, err)
+		}
+		providers[host] = p
+	}
+	return providers, nil
+}
+
+// godocResources returns the compute resources of the godoc containers
+// specified by the flags.
+func godocResources() (v1.ResourceRequirements, error) {
+	resources := v1.ResourceRequirements{
+		Requests: v1.ResourceList{},
+		Limits:   v1.ResourceList{},
+	}
+	for _, r := range []struct {
+		list  v1.ResourceList
+		name  v1.ResourceName
+		value string
+	}{
+		{resources.Requests, v1.ResourceCPU, *godocCPURequest},
+		{resources.Requests, v1.ResourceMemory, *godocMemoryRequest},
+		{resources.Limits, v1.ResourceCPU, *godocCPULimit},
+		{resources.Limits, v1.ResourceMemory, *godocMemoryLimit},
+	} {
+		if r.value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return resources, fmt.Errorf("invalid %s quantity %q: %v", r.name, r.value, err)
+		}
+		r.list[r.name] = q
+	}
+	return resources, nil
+}
+
+// githubCredentials reads the Github credentials from the files or Secret
+// specified by the flags. The manager's client can not be used to read the
+// Secret as its cache is not started yet.
+func githubCredentials(cfg *rest.Config) (githubauth.Credentials, error) {
+	creds := githubauth.Credentials{
+		AppID:          *githubAppID,
+		InstallationID: *githubInstallationID,
+	}
+	var err error
+	switch {
+	case creds.IsApp() && *githubAppKeySecret != "":
+		creds.PrivateKey, err = readSecretKey(cfg, *githubAppKeySecret, "private-key.pem")
+	case creds.IsApp() && *githubAppKeyFile != "":
+		creds.PrivateKey, err = ioutil.ReadFile(*githubAppKeyFile)
+	case creds.IsApp():
+		err = fmt.Errorf("the private key of the Github App is specified by neither -github-app-private-key-file nor -github-app-private-key-secret")
+	case *githubTokenFile != "":
+		creds.Token, err = githubauth.ReadTokenFile(*githubTokenFile)
+	case *githubTokenSecret != "":
+		var token []byte
+		token, err = readSecretKey(cfg, *githubTokenSecret, "token")
+		creds.Token = strings.TrimSpace(string(token))
+	default:
+		log.Printf("no github credentials specified, making anonymous github API calls")
+	}
+	if err != nil {
+		return creds, err
+	}
+	return creds, creds.Validate()
+}
+
+// readSecretKey reads the value of key in the Secret referred to by
+// "namespace/name" with a client of its own.
+func readSecretKey(cfg *rest.Config, secretRef, key string) ([]byte, error) {
+	c, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return githubauth.ReadSecretKey(context.Background(), c, secretRef, key)
+}
+
 func registerTypes(mgr manager.Manager) {
-	mgr.GetScheme().AddKnownTypes(v1alpha1.SchemeGroupVersion, &v1alpha1.PullRequest{}, &v1alpha1.PullRequestList{})
+	mgr.GetScheme().AddKnownTypes(v1alpha1.SchemeGroupVersion,
+		&v1alpha1.PullRequest{}, &v1alpha1.PullRequestList{},
+		&v1alpha1.Repository{}, &v1alpha1.RepositoryList{})
 	metav1.AddToGroupVersion(mgr.GetScheme(), v1alpha1.SchemeGroupVersion)
 }
@@ -0,0 +1,431 @@
+// Command godoc-fetch checks out a single commit of a repository, it runs as
+// the init container of the godoc pods to place the commit of a PR in the
+// GOPATH shared with the godoc container, and is run by godoc-sync in the
+// shared godoc pods:
+//
+//	godoc-fetch -clone-url https://github.com/org/repo -refspec pull/1/head \
+//		-commit 0123abc -src /go/src -import-path github.com/org/repo
+//
+// The commit is fetched by its ID, falling back to fetching the refspec for
+// the hosts refusing to serve commits by ID. It is placed in src at the path
+// of its module if it has a go.mod file, at the given import path otherwise.
+// The other modules of the repo are placed at their own paths, and the
+// dependencies of modules are vendored from GOPROXY so that godoc links to
+// them.
+//
+// The import paths of the modules to document, or of the subdirectories given
+// with -modules, are written one per line to the .godoc-module file of src and
+// to the termination message of the container.
+//
+// Private repos and dependencies are fetched with the git credentials of the
+// Secret mounted at the -credentials directory: an HTTPS token of the host of
+// the repo under the token key, along with an optional username, or an SSH
+// private key under the ssh-privatekey key along with the known_hosts of the
+// host. The private modules are given with -goprivate.
+//
+// Failures are written to the termination message as well, so that they show
+// in the status of the PullRequest.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/droot/godocbot/pkg/gomod"
+)
+
+var (
+	cloneURL       = flag.String("clone-url", "", "URL the repository is cloned from")
+	refspec        = flag.String("refspec", "", "refspec fetching the head of the PR, fetched if the commit cannot be fetched by its ID")
+	commitID       = flag.String("commit", "", "ID of the commit to check out")
+	srcDir         = flag.String("src", "", "src directory of the GOPATH the commit is checked out in, its content is replaced")
+	importPath     = flag.String("import-path", "", "import path the commit is checked out at if it has no go.mod file")
+	modules        = flag.String("modules", "", "comma separated module paths, or subdirectories of the repository, to document. All the modules are documented if empty")
+	goproxy        = flag.String("goproxy", "", "GOPROXY the dependencies of modules are downloaded from, the go command default if empty")
+	goprivate      = flag.String("goprivate", "", "GOPRIVATE of the dependency download, the patterns of the private modules")
+	gonosumdb      = flag.String("gonosumdb", "", "GONOSUMDB of the dependency download, GOPRIVATE if empty")
+	credentialsDir = flag.String("credentials", "", "directory of the git credentials, the repo and dependencies are fetched anonymously if empty")
+	terminationLog = flag.String("termination-log", "/dev/termination-log", "file the documented import paths, or the failure, are written to")
+)
+
+const (
+	// maxMessage is the size limit of container termination messages.
+	maxMessage = 4096
+	// keys of the credentials Secret.
+	tokenKey      = "token"
+	usernameKey   = "username"
+	sshKeyKey     = "ssh-privatekey"
+	knownHostsKey = "known_hosts"
+)
+
+func main() {
+	flag.Parse()
+	if *cloneURL == "" || *commitID == "" || *srcDir == "" || *importPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	paths, err := fetch()
+	if err != nil {
+		msg := err.Error()
+		if len(msg) > maxMessage {
+			msg = msg[:maxMessage]
+		}
+		writeTerminationLog(msg)
+		log.Fatal(msg)
+	}
+	writeTerminationLog(strings.Join(paths, "\n"))
+	log.Printf("checked out commit %s of %s documenting %s", *commitID, *cloneURL, strings.Join(paths, ", "))
+}
+
+// writeTerminationLog writes msg to the termination message of the container.
+func writeTerminationLog(msg string) {
+	if err := ioutil.WriteFile(*terminationLog, []byte(msg), 0644); err != nil {
+		log.Printf("failed to write the termination message: %v", err)
+	}
+}
+
+// fetch checks out the commit in src and returns the import paths to
+// document.
+func fetch() ([]string, error) {
+	env, err := credentials()
+	if err != nil {
+		return nil, err
+	}
+	if err := clean(*srcDir); err != nil {
+		return nil, err
+	}
+	checkout := filepath.Join(*srcDir, ".checkout")
+	if err := os.MkdirAll(checkout, 0755); err != nil {
+		return nil, err
+	}
+	if err := checkoutCommit(checkout, env); err != nil {
+		return nil, err
+	}
+
+	mods, err := findModules(checkout)
+	if err != nil {
+		return nil, err
+	}
+	root := *importPath
+	if path, found := mods["."]; found {
+		root = path
+	}
+	if err := move(checkout, root); err != nil {
+		return nil, err
+	}
+	// nested modules whose path does not match their directory are moved to
+	// their path, the deepest ones first.
+	var dirs []string
+	for dir := range mods {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		if dir != "." && path.Join(root, dir) != mods[dir] {
+			if err := move(filepath.Join(*srcDir, filepath.FromSlash(path.Join(root, dir))), mods[dir]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	paths, err := documented(mods, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(*srcDir, gomod.ModuleFile), []byte(strings.Join(paths, "\n")), 0644); err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		modDir := filepath.Join(*srcDir, filepath.FromSlash(mods[dir]))
+		if _, err := os.Stat(filepath.Join(modDir, "vendor")); os.IsNotExist(err) {
+			// godoc is still served without the dependencies, only the
+			// links to them are missing.
+			if err := vendor(modDir, env); err != nil {
+				log.Printf("failed to vendor the dependencies of %s: %v", mods[dir], err)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// findModules returns the paths of the modules of the checkout keyed by their
+// directory relative to it, "." for the root module.
+func findModules(checkout string) (map[string]string, error) {
+	mods := map[string]string{}
+	err := filepath.Walk(checkout, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if file != checkout && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name != "go.mod" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(checkout, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		modPath := gomod.ModulePath(data)
+		if modPath == "" {
+			return fmt.Errorf("%s of commit %s has no module directive", path.Join(filepath.ToSlash(rel), "go.mod"), *commitID)
+		}
+		mods[filepath.ToSlash(rel)] = modPath
+		return nil
+	})
+	return mods, err
+}
+
+// move moves dir to the given import path of src.
+func move(dir, importPath string) error {
+	dest := filepath.Join(*srcDir, filepath.FromSlash(importPath))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return os.Rename(dir, dest)
+}
+
+// documented returns the import paths to document given the modules of the
+// checkout and the import path of its root: the ones given with -modules, or
+// all the modules, the root one first. Subdirectories given with -modules are
+// documented at their import path in the module they belong to.
+func documented(mods map[string]string, root string) ([]string, error) {
+	if *modules == "" {
+		paths := []string{root}
+		for dir, modPath := range mods {
+			if dir != "." {
+				paths = append(paths, modPath)
+			}
+		}
+		sort.Strings(paths[1:])
+		return paths, nil
+	}
+
+	var paths []string
+	for _, entry := range strings.Split(*modules, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		importPath := resolve(mods, root, entry)
+		if _, err := os.Stat(filepath.Join(*srcDir, filepath.FromSlash(importPath))); err != nil {
+			return nil, fmt.Errorf("module or subdirectory %s is not found in commit %s", entry, *commitID)
+		}
+		paths = append(paths, importPath)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no module to document in %q", *modules)
+	}
+	return paths, nil
+}
+
+// resolve returns the import path of a module path, an import path in a
+// module, or a subdirectory of the checkout.
+func resolve(mods map[string]string, root, entry string) string {
+	for _, modPath := range append([]string{root}, modulePaths(mods)...) {
+		if entry == modPath || strings.HasPrefix(entry, modPath+"/") {
+			return entry
+		}
+	}
+	// the subdirectory belongs to the deepest module containing it.
+	rel := path.Clean(strings.Trim(entry, "/"))
+	modDir, modPath := ".", root
+	for dir, p := range mods {
+		if (dir == rel || strings.HasPrefix(rel, dir+"/")) && len(dir) > len(modDir) {
+			modDir, modPath = dir, p
+		}
+	}
+	if modDir == "." {
+		return path.Join(modPath, rel)
+	}
+	return path.Join(modPath, strings.TrimPrefix(rel, modDir))
+}
+
+// modulePaths returns the paths of the modules, the longest ones first.
+func modulePaths(mods map[string]string) []string {
+	var paths []string
+	for _, p := range mods {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return len(paths[i]) > len(paths[j]) })
+	return paths
+}
+
+// clean empties dir, creating it if needed. It is the mount point of a volume
+// which is kept when the init container is restarted, so it is not removed
+// itself.
+func clean(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// credentials sets up the git credentials of the -credentials directory in a
+// home directory of their own, and returns the environment of the git and go
+// commands using them.
+func credentials() ([]string, error) {
+	// git fails instead of prompting for missing credentials.
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if *credentialsDir == "" {
+		return env, nil
+	}
+	u, err := url.Parse(*cloneURL)
+	if err != nil {
+		return nil, err
+	}
+	home, err := ioutil.TempDir("", "godoc-fetch")
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, "HOME="+home)
+
+	token, err := readCredential(tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := readCredential(sshKeyKey)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case token != "":
+		username, err := readCredential(usernameKey)
+		if err != nil {
+			return nil, err
+		}
+		if username == "" {
+			username = "x-access-token"
+		}
+		// git and the go command read the HTTPS credentials of the host,
+		// for its proxy as well, from .netrc.
+		netrc := fmt.Sprintf("machine %s login %s password %s\n", u.Hostname(), strings.TrimSpace(username), strings.TrimSpace(token))
+		if err := ioutil.WriteFile(filepath.Join(home, ".netrc"), []byte(netrc), 0600); err != nil {
+			return nil, err
+		}
+	case key != "":
+		knownHosts, err := readCredential(knownHostsKey)
+		if err != nil {
+			return nil, err
+		}
+		if knownHosts == "" {
+			return nil, fmt.Errorf("credentials have an %s but no %s", sshKeyKey, knownHostsKey)
+		}
+		sshDir := filepath.Join(home, ".ssh")
+		if err := os.Mkdir(sshDir, 0700); err != nil {
+			return nil, err
+		}
+		// ssh refuses keys readable by others, which the files of Secret
+		// volumes may be.
+		keyFile, knownHostsFile := filepath.Join(sshDir, "id"), filepath.Join(sshDir, "known_hosts")
+		if err := ioutil.WriteFile(keyFile, []byte(strings.TrimSpace(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(knownHostsFile, []byte(knownHosts), 0600); err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", keyFile, knownHostsFile))
+		// the repo and the private modules of its host are fetched over
+		// SSH instead of HTTPS.
+		gitconfig := fmt.Sprintf("[url \"ssh://git@%s/\"]\n\tinsteadOf = https://%s/\n", u.Host, u.Host)
+		if err := ioutil.WriteFile(filepath.Join(home, ".gitconfig"), []byte(gitconfig), 0600); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("credentials have neither a %s nor an %s", tokenKey, sshKeyKey)
+	}
+	return env, nil
+}
+
+// readCredential returns the credential of the given key, empty if there is
+// none.
+func readCredential(key string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(*credentialsDir, key))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+// checkoutCommit checks out the commit in dir, running git in env.
+func checkoutCommit(dir string, env []string) error {
+	if err := run(dir, env, "git", "init", "-q"); err != nil {
+		return err
+	}
+	if err := run(dir, env, "git", "remote", "add", "origin", *cloneURL); err != nil {
+		return err
+	}
+
+	if err := run(dir, env, "git", "fetch", "-q", "--depth=1", "origin", *commitID); err != nil {
+		if *refspec == "" {
+			return err
+		}
+		log.Printf("fetching commit %s failed, fetching %s instead: %v", *commitID, *refspec, err)
+		// the head of the PR may have moved past the commit, whose history
+		// is needed then.
+		if err := run(dir, env, "git", "fetch", "-q", "origin", *refspec); err != nil {
+			return err
+		}
+		if err := run(dir, env, "git", "cat-file", "-e", *commitID+"^{commit}"); err != nil {
+			return fmt.Errorf("commit %s is not found in %s of %s", *commitID, *refspec, *cloneURL)
+		}
+	}
+	return run(dir, env, "git", "checkout", "-q", "--detach", *commitID)
+}
+
+// vendor downloads the dependencies of the module in dir to its vendor
+// directory, where godoc finds them in GOPATH mode. The go command runs in
+// env, fetching the private modules with the git credentials.
+func vendor(dir string, env []string) error {
+	// modules are disabled by default inside GOPATH.
+	env = append(append([]string{}, env...), "GO111MODULE=on")
+	for name, value := range map[string]string{"GOPROXY": *goproxy, "GOPRIVATE": *goprivate, "GONOSUMDB": *gonosumdb} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return run(dir, env, "go", "mod", "vendor")
+}
+
+// run runs the command in dir with the environment variables added to the
+// ones of the process, its output is returned in the error if it fails.
+func run(dir string, env []string, name string, args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Command godoc-proxy runs as a sidecar of the godoc pods exposed under a path
+// prefix of a shared Ingress host, e.g. https://docs.example.com/pr/org/repo/15/,
+// and serves the godoc server of the pod under the prefixes matching the
+// Ingress path pattern:
+//
+//	godoc-proxy -listen :6061 -target http://localhost:6060 \
+//		-path-pattern /pr/{org}/{repo}/{pr}
+//
+// The prefix is stripped from the requests, and added back to the absolute
+// links and redirects of the godoc pages.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/droot/godocbot/pkg/godocproxy"
+)
+
+var (
+	listen      = flag.String("listen", ":6061", "address the proxy listens on")
+	target      = flag.String("target", "http://localhost:6060", "URL of the godoc server")
+	pathPattern = flag.String("path-pattern", "", "path pattern of the Ingress, {name}, {org}, {repo} and {pr} match the PullRequest object name, the org, the repo and the PR number")
+)
+
+func main() {
+	flag.Parse()
+	if *pathPattern == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	u, err := url.Parse(*target)
+	if err != nil {
+		log.Fatalf("invalid target %q: %v", *target, err)
+	}
+	p, err := godocproxy.New(u, *pathPattern)
+	if err != nil {
+		log.Fatalf("invalid path pattern %q: %v", *pathPattern, err)
+	}
+	log.Printf("serving %s under %s on %s", u, *pathPattern, *listen)
+	log.Fatal(http.ListenAndServe(*listen, p))
+}
@@ -0,0 +1,70 @@
+// Command godoc-sync runs as a sidecar of the shared godoc pods, it checks out
+// the commits of the PRs listed in the -config directory with godoc-fetch and
+// removes the PRs which are no longer listed, see pkg/godocsync:
+//
+//	godoc-sync -config /etc/godoc-sync -src /go/src -addr :6061
+//
+// The status of the worktrees is served as JSON on -addr, where the
+// controller reads which commit of each PR is served.
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/droot/godocbot/pkg/godocsync"
+)
+
+var (
+	configDir     = flag.String("config", "/etc/godoc-sync", "directory listing the PRs, one file of godoc-fetch arguments per GOPATH prefix")
+	srcDir        = flag.String("src", "/go/src", "src directory of the GOPATH the PRs are checked out in at their prefix")
+	addr          = flag.String("addr", ":6061", "address the status of the worktrees is served on")
+	fetchCommand  = flag.String("fetch", "godoc-fetch", "godoc-fetch command checking out the commits")
+	interval      = flag.Duration("interval", 10*time.Second, "interval the PRs are synced at")
+	retryInterval = flag.Duration("retry-interval", time.Minute, "delay before the failed checkouts are retried")
+)
+
+func main() {
+	flag.Parse()
+	if err := os.MkdirAll(*srcDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	s := godocsync.NewSyncer(*configDir, *srcDir, fetch, *retryInterval)
+	go s.Run(*interval, make(chan struct{}))
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
+
+// fetch runs godoc-fetch with the arguments, checking out the commit in src.
+// The documented import paths, or the failure, are read from its termination
+// message.
+func fetch(args []string, src string) ([]string, error) {
+	f, err := ioutil.TempFile("", "godoc-fetch")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	cmd := exec.Command(*fetchCommand, append(args, "-src", src, "-termination-log", f.Name())...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	msg, readErr := ioutil.ReadFile(f.Name())
+	if readErr != nil {
+		return nil, readErr
+	}
+	if err != nil {
+		if len(msg) == 0 {
+			return nil, err
+		}
+		return nil, errors.New(strings.TrimSpace(string(msg)))
+	}
+	return strings.Fields(string(msg)), nil
+}
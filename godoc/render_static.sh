@@ -0,0 +1,38 @@
+#!/bin/bash
+
+# render_static.sh renders the godoc of the packages of a PR to static HTML in
+# OUT. The commit of the PR is checked out in the GOPATH by the godoc-fetch
+# init container beforehand, which wrote the import paths to document to
+# .godoc-module, one per line.
+
+OUT=${1:-/out}
+SRC=${GOPATH:-/go}/src
+
+set -e
+
+MODULES=$(cat $SRC/.godoc-module)
+FIRST=$(head -n 1 $SRC/.godoc-module)
+
+godoc -goroot /usr/local/go -http=localhost:6060 &
+GODOC=$!
+trap "kill $GODOC" EXIT
+
+# godoc answers with errors until the packages of the repo are indexed.
+until curl -sf http://localhost:6060/pkg/$FIRST/ > /dev/null; do
+  kill -0 $GODOC
+  sleep 5
+done
+
+DIRS=/lib
+URLS=
+for MODULE in $MODULES; do
+  DIRS=$DIRS,/pkg/$MODULE
+  URLS="$URLS http://localhost:6060/pkg/$MODULE/"
+done
+
+# links are rewritten relative to OUT, which is served under a prefix.
+cd $OUT
+wget --quiet --mirror --page-requisites --adjust-extension --convert-links \
+  --no-host-directories --no-parent \
+  --include-directories=$DIRS \
+  $URLS || [ $? -eq 8 ]
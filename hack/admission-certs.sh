@@ -0,0 +1,30 @@
+#!/bin/bash
+# Provisions the serving certificate of the admission webhooks: a CA and a
+# certificate for the godocbot-admission Service signed by it are stored in the
+# godocbot-admission Secret, and the CA is set as the caBundle of the webhook
+# configurations of hack/install.yaml, which must be applied first. Run it
+# again to rotate the certificate.
+set -e
+
+SERVICE=${SERVICE:-godocbot-admission}
+NAMESPACE=${NAMESPACE:-default}
+DIR=$(mktemp -d)
+trap 'rm -rf "$DIR"' EXIT
+
+openssl req -x509 -newkey rsa:2048 -nodes -days 3650 -subj "/CN=godocbot-admission-ca" \
+  -keyout "$DIR/ca.key" -out "$DIR/ca.crt"
+openssl req -newkey rsa:2048 -nodes -subj "/CN=$SERVICE.$NAMESPACE.svc" \
+  -keyout "$DIR/tls.key" -out "$DIR/tls.csr"
+printf 'subjectAltName=DNS:%s,DNS:%s.%s,DNS:%s.%s.svc\n' \
+  "$SERVICE" "$SERVICE" "$NAMESPACE" "$SERVICE" "$NAMESPACE" > "$DIR/ext.cnf"
+openssl x509 -req -days 365 -in "$DIR/tls.csr" -CA "$DIR/ca.crt" -CAkey "$DIR/ca.key" \
+  -CAcreateserial -extfile "$DIR/ext.cnf" -out "$DIR/tls.crt"
+
+kubectl -n "$NAMESPACE" create secret tls godocbot-admission \
+  --cert "$DIR/tls.crt" --key "$DIR/tls.key" --dry-run -o yaml | kubectl apply -f -
+
+CA_BUNDLE=$(base64 < "$DIR/ca.crt" | tr -d '\n')
+for kind in mutatingwebhookconfiguration validatingwebhookconfiguration; do
+  kubectl patch "$kind" godocbot-admission --type json \
+    -p "[{\"op\": \"replace\", \"path\": \"/webhooks/0/clientConfig/caBundle\", \"value\": \"$CA_BUNDLE\"}]"
+done
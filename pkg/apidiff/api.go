@@ -0,0 +1,321 @@
+// Package apidiff compares the exported API of the Go packages found in two
+// checkouts of a repository, typically the base and the head of a PR. The
+// declarations of both trees are read with go/doc and the changes to their
+// signatures and doc comments are reported as text or as an HTML page.
+package apidiff
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of declarations.
+const (
+	KindPackage = "package"
+	KindConst   = "const"
+	KindVar     = "var"
+	KindFunc    = "func"
+	KindType    = "type"
+	KindMethod  = "method"
+	KindField   = "field"
+)
+
+// Decl is an exported declaration of a package.
+type Decl struct {
+	// Kind of the declaration, e.g. KindFunc.
+	Kind string
+	// Name of the declaration, methods and fields are qualified by the name
+	// of their type, e.g. Client.Do.
+	Name string
+	// Signature is the declaration without its doc comment and body,
+	// formatted on a single line.
+	Signature string
+	// Doc is the doc comment of the declaration.
+	Doc string
+	// Pos is the position of the declaration in the checkout, with the file
+	// name relative to the root of the checkout.
+	Pos token.Position
+	// InInterface is set on the methods and embedded types of interfaces.
+	InInterface bool
+
+	// compat is the signature without the names of the parameters, the
+	// compatibility of changes is checked on it.
+	compat string
+	// sealed is set on the methods and embedded types of interfaces with
+	// unexported methods, which other packages cannot implement.
+	sealed bool
+}
+
+// Package is the exported API of a package.
+type Package struct {
+	ImportPath string
+	Name       string
+	// Dir is the directory of the package relative to the root of the
+	// checkout.
+	Dir string
+	// Doc is the package comment.
+	Doc string
+	// Decls are the exported declarations keyed by name.
+	Decls map[string]*Decl
+}
+
+// API is the exported API of the packages of a checkout keyed by import
+// path.
+type API map[string]*Package
+
+// Load reads the exported API of the non-main packages found under root,
+// whose import path is importPath. Test files, files excluded by build
+// constraints and the vendor and testdata directories are ignored.
+func Load(root, importPath string) (API, error) {
+	api := API{}
+	err := filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if dir != root && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		pkg, err := loadPackage(root, filepath.ToSlash(rel), path.Join(importPath, filepath.ToSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if pkg != nil {
+			api[pkg.ImportPath] = pkg
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return api, nil
+}
+
+// loadPackage reads the exported API of the package in the rel directory of
+// root. It returns nil if there is no such package.
+func loadPackage(root, rel, importPath string) (*Package, error) {
+	dir := filepath.Join(root, filepath.FromSlash(rel))
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		if strings.HasSuffix(info.Name(), "_test.go") {
+			return false
+		}
+		match, err := build.Default.MatchFile(dir, info.Name())
+		return err == nil && match
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, astPkg := range pkgs {
+		if name == "main" || strings.HasSuffix(name, "_test") {
+			continue
+		}
+		l := &loader{
+			fset: fset,
+			root: root,
+			pkg: &Package{
+				ImportPath: importPath,
+				Name:       name,
+				Dir:        rel,
+				Decls:      map[string]*Decl{},
+			},
+		}
+		// only the exported declarations are kept by go/doc.
+		l.load(doc.New(astPkg, importPath, 0))
+		return l.pkg, nil
+	}
+	return nil, nil
+}
+
+// loader collects the declarations of a package.
+type loader struct {
+	fset *token.FileSet
+	root string
+	pkg  *Package
+}
+
+func (l *loader) load(p *doc.Package) {
+	l.pkg.Doc = p.Doc
+	l.values(p.Consts)
+	l.values(p.Vars)
+	l.funcs("", p.Funcs)
+	for _, t := range p.Types {
+		l.typ(t)
+		l.values(t.Consts)
+		l.values(t.Vars)
+		l.funcs("", t.Funcs)
+		l.funcs(t.Name, t.Methods)
+	}
+}
+
+func (l *loader) add(kind, name, signature, doc string, pos token.Pos) *Decl {
+	position := l.fset.Position(pos)
+	if rel, err := filepath.Rel(l.root, position.Filename); err == nil {
+		position.Filename = filepath.ToSlash(rel)
+	}
+	d := &Decl{
+		Kind:      kind,
+		Name:      name,
+		Signature: signature,
+		Doc:       strings.TrimSpace(doc),
+		Pos:       position,
+		compat:    signature,
+	}
+	l.pkg.Decls[name] = d
+	return d
+}
+
+func (l *loader) values(values []*doc.Value) {
+	for _, v := range values {
+		kind := KindVar
+		if v.Decl.Tok == token.CONST {
+			kind = KindConst
+		}
+		for _, spec := range v.Decl.Specs {
+			vs := spec.(*ast.ValueSpec)
+			// the doc of a spec in a group takes precedence over the
+			// doc of the group.
+			doc := v.Doc
+			if vs.Doc != nil {
+				doc = vs.Doc.Text()
+			}
+			for i, name := range vs.Names {
+				if !name.IsExported() {
+					continue
+				}
+				sig := kind + " " + name.Name
+				if vs.Type != nil {
+					sig += " " + l.format(vs.Type)
+				}
+				// the value of a variable is only part of its API when
+				// its type is inferred from it.
+				if i < len(vs.Values) && (kind == KindConst || vs.Type == nil) {
+					sig += " = " + l.format(vs.Values[i])
+				}
+				l.add(kind, name.Name, sig, doc, name.Pos())
+			}
+		}
+	}
+}
+
+func (l *loader) funcs(recv string, funcs []*doc.Func) {
+	for _, f := range funcs {
+		decl := *f.Decl
+		decl.Doc = nil
+		decl.Body = nil
+		kind, name := KindFunc, f.Name
+		if recv != "" {
+			kind, name = KindMethod, recv+"."+f.Name
+		}
+		d := l.add(kind, name, l.format(&decl), f.Doc, f.Decl.Name.Pos())
+		decl.Type = withoutNames(decl.Type)
+		if decl.Recv != nil {
+			decl.Recv = withoutNames(&ast.FuncType{Params: decl.Recv}).Params
+		}
+		d.compat = l.format(&decl)
+	}
+}
+
+// typ adds the type along with its fields if it is a struct, and its methods
+// if it is an interface.
+func (l *loader) typ(t *doc.Type) {
+	spec := t.Decl.Specs[0].(*ast.TypeSpec)
+	doc := t.Doc
+	switch st := spec.Type.(type) {
+	case *ast.StructType:
+		l.add(KindType, t.Name, "type "+t.Name+" struct", doc, spec.Name.Pos())
+		for _, field := range st.Fields.List {
+			l.fields(t.Name, KindField, field)
+		}
+	case *ast.InterfaceType:
+		l.add(KindType, t.Name, "type "+t.Name+" interface", doc, spec.Name.Pos())
+		for _, method := range st.Methods.List {
+			for _, d := range l.fields(t.Name, KindMethod, method) {
+				d.InInterface = true
+				// go/doc marks the interfaces whose unexported methods
+				// it removed as incomplete.
+				d.sealed = st.Incomplete
+			}
+		}
+	default:
+		s := *spec
+		s.Doc, s.Comment = nil, nil
+		l.add(KindType, t.Name, "type "+l.format(&s), doc, spec.Name.Pos())
+	}
+}
+
+// fields adds the struct field or interface method of the type, and returns
+// the declarations added.
+func (l *loader) fields(typeName, kind string, field *ast.Field) []*Decl {
+	if len(field.Names) == 0 {
+		// embedded type, named after the type.
+		name := l.format(field.Type)
+		name = name[strings.LastIndex(name, ".")+1:]
+		name = strings.TrimPrefix(name, "*")
+		d := l.add(KindField, typeName+"."+name, typeName+" embeds "+l.format(field.Type), field.Doc.Text(), field.Pos())
+		return []*Decl{d}
+	}
+	var decls []*Decl
+	for _, name := range field.Names {
+		if !name.IsExported() {
+			continue
+		}
+		if kind == KindMethod {
+			ft := field.Type.(*ast.FuncType)
+			d := l.add(kind, typeName+"."+name.Name, "func ("+typeName+") "+name.Name+strings.TrimPrefix(l.format(ft), "func"), field.Doc.Text(), name.Pos())
+			d.compat = "func (" + typeName + ") " + name.Name + strings.TrimPrefix(l.format(withoutNames(ft)), "func")
+			decls = append(decls, d)
+			continue
+		}
+		decls = append(decls, l.add(kind, typeName+"."+name.Name, typeName+"."+name.Name+" "+l.format(field.Type), field.Doc.Text(), name.Pos()))
+	}
+	return decls
+}
+
+// withoutNames returns a copy of the function type without the names of its
+// parameters and results.
+func withoutNames(ft *ast.FuncType) *ast.FuncType {
+	strip := func(fields *ast.FieldList) *ast.FieldList {
+		if fields == nil {
+			return nil
+		}
+		stripped := &ast.FieldList{}
+		for _, f := range fields.List {
+			// a, b int stands for two parameters.
+			for n := 0; n < len(f.Names) || n == 0; n++ {
+				stripped.List = append(stripped.List, &ast.Field{Type: f.Type})
+			}
+		}
+		return stripped
+	}
+	c := *ft
+	c.Params = strip(ft.Params)
+	c.Results = strip(ft.Results)
+	return &c
+}
+
+// format returns the source of the node on a single line.
+func (l *loader) format(node interface{}) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, l.fset, node); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
@@ -0,0 +1,107 @@
+package apidiff
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fixtureImportPath is the import path of the fixture trees of testdata.
+const fixtureImportPath = "example.com/api"
+
+// loadFixture loads the API of the named fixture tree of testdata.
+func loadFixture(t *testing.T, name string) API {
+	api, err := Load("testdata/"+name, fixtureImportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		tree string
+		// packages are the import paths of the packages found, main,
+		// vendored and test packages excluded.
+		packages []string
+		// decls are the signatures of the declarations of the root
+		// package by name, unexported ones excluded.
+		decls map[string]string
+	}{
+		{
+			tree:     "base",
+			packages: []string{"example.com/api", "example.com/api/oldpkg"},
+			decls: map[string]string{
+				"Version":     `const Version = "1"`,
+				"Client":      "type Client struct",
+				"Client.Host": "Client.Host string",
+				"Client.Get":  "func (c *Client) Get(path string) error",
+				"Do":          "func Do(req string) error",
+				"Doer":        "type Doer interface",
+				"Doer.Do":     "func (Doer) Do(req string) error",
+				"Removed":     "func Removed()",
+			},
+		},
+		{
+			tree:     "head",
+			packages: []string{"example.com/api", "example.com/api/newpkg"},
+			decls: map[string]string{
+				"Version":     `const Version = "2"`,
+				"Client":      "type Client struct",
+				"Client.Host": "Client.Host string",
+				"Client.Port": "Client.Port int",
+				"Client.Get":  "func (c *Client) Get(path string) error",
+				"Do":          "func Do(request string) error",
+				"Doer":        "type Doer interface",
+				"Doer.Do":     "func (Doer) Do(req string) error",
+				"Doer.Close":  "func (Doer) Close() error",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.tree, func(t *testing.T) {
+			api := loadFixture(t, test.tree)
+			var packages []string
+			for importPath := range api {
+				packages = append(packages, importPath)
+			}
+			sort.Strings(packages)
+			if !reflect.DeepEqual(packages, test.packages) {
+				t.Errorf("got packages %v, want %v", packages, test.packages)
+			}
+
+			pkg := api[fixtureImportPath]
+			if pkg == nil {
+				t.Fatalf("package %s not found", fixtureImportPath)
+			}
+			if pkg.Name != "api" || pkg.Dir != "." {
+				t.Errorf("got package %s in %s, want api in .", pkg.Name, pkg.Dir)
+			}
+			decls := map[string]string{}
+			for name, d := range pkg.Decls {
+				decls[name] = d.Signature
+			}
+			if !reflect.DeepEqual(decls, test.decls) {
+				t.Errorf("got declarations %v, want %v", decls, test.decls)
+			}
+		})
+	}
+}
+
+func TestLoadPositionsAndDocs(t *testing.T) {
+	d := loadFixture(t, "head")[fixtureImportPath].Decls["Client.Get"]
+	if d.Pos.Filename != "api.go" || d.Pos.Line != 18 {
+		t.Errorf("got position %v, want api.go:18", d.Pos)
+	}
+	if d.Doc != "Get gets the path, relative to the host." {
+		t.Errorf("got doc %q", d.Doc)
+	}
+	if d.Kind != KindMethod || d.InInterface {
+		t.Errorf("got kind %s, in interface %v, want a method outside interfaces", d.Kind, d.InInterface)
+	}
+
+	old := loadFixture(t, "base")[fixtureImportPath+"/oldpkg"]
+	if old.Dir != "oldpkg" || old.Doc != "Package oldpkg is removed in the head.\n" {
+		t.Errorf("got package in %s with doc %q", old.Dir, old.Doc)
+	}
+}
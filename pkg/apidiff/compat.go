@@ -0,0 +1,44 @@
+package apidiff
+
+import "strings"
+
+// compatible returns true if the change cannot break the code using the API,
+// following the Go 1 compatibility rules as apidiff understands them:
+//   - removing a package or a declaration is incompatible,
+//   - adding a declaration is compatible, except for adding a method to an
+//     existing interface other packages can implement, since their
+//     implementations no longer satisfy it,
+//   - changing the type of a declaration, the signature of a function or
+//     method, the definition of a type or the value of a constant is
+//     incompatible, while renaming the parameters of a function is not,
+//   - changing doc comments is compatible.
+//
+// oldPkg is the package of the change in the base, nil if it is added.
+func compatible(c *Change, oldPkg *Package) bool {
+	switch c.Kind {
+	case Removed:
+		return false
+	case Added:
+		if !c.New.InInterface || c.New.sealed || oldPkg == nil {
+			return true
+		}
+		iface := c.New.Name[:strings.Index(c.New.Name, ".")]
+		_, found := oldPkg.Decls[iface]
+		return !found
+	case Changed:
+		return c.Old.Kind == c.New.Kind && c.Old.compat == c.New.compat
+	default:
+		return true
+	}
+}
+
+// Incompatible returns the changes which are not compatible.
+func (r *Report) Incompatible() []*Change {
+	var changes []*Change
+	for _, c := range r.Changes {
+		if !c.Compatible {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,114 @@
+package apidiff
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestCompatible(t *testing.T) {
+	doer := &Package{Decls: map[string]*Decl{
+		"Doer": {Kind: KindType, Name: "Doer"},
+	}}
+	tests := []struct {
+		name   string
+		change *Change
+		oldPkg *Package
+		want   bool
+	}{
+		{
+			name:   "removed",
+			change: &Change{Kind: Removed, Old: &Decl{Kind: KindFunc, Name: "Do"}},
+			oldPkg: doer,
+		},
+		{
+			name:   "added func",
+			change: &Change{Kind: Added, New: &Decl{Kind: KindFunc, Name: "Do"}},
+			oldPkg: doer,
+			want:   true,
+		},
+		{
+			name:   "added method of an existing interface",
+			change: &Change{Kind: Added, New: &Decl{Kind: KindMethod, Name: "Doer.Close", InInterface: true}},
+			oldPkg: doer,
+		},
+		{
+			name:   "added method of a sealed interface",
+			change: &Change{Kind: Added, New: &Decl{Kind: KindMethod, Name: "Doer.Close", InInterface: true, sealed: true}},
+			oldPkg: doer,
+			want:   true,
+		},
+		{
+			name:   "added method of a new interface",
+			change: &Change{Kind: Added, New: &Decl{Kind: KindMethod, Name: "Closer.Close", InInterface: true}},
+			oldPkg: doer,
+			want:   true,
+		},
+		{
+			name:   "added method of an interface of a new package",
+			change: &Change{Kind: Added, New: &Decl{Kind: KindMethod, Name: "Doer.Close", InInterface: true}},
+			want:   true,
+		},
+		{
+			name: "renamed parameters",
+			change: &Change{
+				Kind: Changed,
+				Old:  &Decl{Kind: KindFunc, Name: "Do", compat: "func Do(int) error"},
+				New:  &Decl{Kind: KindFunc, Name: "Do", compat: "func Do(int) error"},
+			},
+			oldPkg: doer,
+			want:   true,
+		},
+		{
+			name: "changed signature",
+			change: &Change{
+				Kind: Changed,
+				Old:  &Decl{Kind: KindFunc, Name: "Do", compat: "func Do(int) error"},
+				New:  &Decl{Kind: KindFunc, Name: "Do", compat: "func Do(int64) error"},
+			},
+			oldPkg: doer,
+		},
+		{
+			name: "changed kind",
+			change: &Change{
+				Kind: Changed,
+				Old:  &Decl{Kind: KindVar, Name: "Version", compat: "Version = 1"},
+				New:  &Decl{Kind: KindConst, Name: "Version", compat: "Version = 1"},
+			},
+			oldPkg: doer,
+		},
+		{
+			name:   "doc changed",
+			change: &Change{Kind: DocChanged, Old: &Decl{Kind: KindFunc, Name: "Do"}, New: &Decl{Kind: KindFunc, Name: "Do"}},
+			oldPkg: doer,
+			want:   true,
+		},
+	}
+	for _, test := range tests {
+		if got := compatible(test.change, test.oldPkg); got != test.want {
+			t.Errorf("%s: got compatible %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWithoutNames(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"func(a, b int) (n int, err error)", "func(int, int) (int, error)"},
+		{"func(int, string) error", "func(int, string) error"},
+		{"func(format string, args ...interface{})", "func(string, ...interface{})"},
+		{"func()", "func()"},
+	}
+	l := &loader{fset: token.NewFileSet()}
+	for _, test := range tests {
+		expr, err := parser.ParseExpr(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.format(withoutNames(expr.(*ast.FuncType))); got != test.want {
+			t.Errorf("withoutNames(%s): got %s, want %s", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package apidiff
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// Kinds of changes.
+const (
+	Added      = "added"
+	Removed    = "removed"
+	Changed    = "changed"
+	DocChanged = "doc-changed"
+)
+
+// Change is a change to an exported declaration, or to a package as a whole
+// when the declaration is of KindPackage.
+type Change struct {
+	// Kind of the change, e.g. Added.
+	Kind string
+	// ImportPath of the package of the declaration.
+	ImportPath string
+	// Old is the declaration in the base, nil if it is added.
+	Old *Decl
+	// New is the declaration in the head, nil if it is removed.
+	New *Decl
+	// Compatible is false if the change may break the code using the API.
+	Compatible bool
+}
+
+// Decl returns the declaration the change is about, the new one unless it is
+// removed.
+func (c *Change) Decl() *Decl {
+	if c.New != nil {
+		return c.New
+	}
+	return c.Old
+}
+
+func (c *Change) String() string {
+	d := c.Decl()
+	if d.Kind == KindPackage {
+		return fmt.Sprintf("%s package %s", c.Kind, c.ImportPath)
+	}
+	return fmt.Sprintf("%s %s %s.%s", c.Kind, d.Kind, path.Base(c.ImportPath), d.Name)
+}
+
+// Report lists the changes between the API of two checkouts.
+type Report struct {
+	// Changes sorted by import path and declaration name.
+	Changes []*Change
+	// Problems are the doc comment problems found by Lint, sorted by
+	// position.
+	Problems []Problem
+}
+
+// Diff returns the changes from the base API to the head API.
+func Diff(base, head API) *Report {
+	r := &Report{}
+	for importPath, newPkg := range head {
+		oldPkg, found := base[importPath]
+		if !found {
+			// the declarations of new packages are listed too, for their
+			// doc comments to be linted and reviewed.
+			r.Changes = append(r.Changes, &Change{Kind: Added, ImportPath: importPath, New: packageDecl(newPkg)})
+			for _, newDecl := range newPkg.Decls {
+				r.Changes = append(r.Changes, &Change{Kind: Added, ImportPath: importPath, New: newDecl})
+			}
+			continue
+		}
+		if oldPkg.Doc != newPkg.Doc {
+			r.Changes = append(r.Changes, &Change{Kind: DocChanged, ImportPath: importPath, Old: packageDecl(oldPkg), New: packageDecl(newPkg)})
+		}
+		for name, newDecl := range newPkg.Decls {
+			oldDecl, found := oldPkg.Decls[name]
+			switch {
+			case !found:
+				r.Changes = append(r.Changes, &Change{Kind: Added, ImportPath: importPath, New: newDecl})
+			case oldDecl.Kind != newDecl.Kind || oldDecl.Signature != newDecl.Signature:
+				r.Changes = append(r.Changes, &Change{Kind: Changed, ImportPath: importPath, Old: oldDecl, New: newDecl})
+			case oldDecl.Doc != newDecl.Doc:
+				r.Changes = append(r.Changes, &Change{Kind: DocChanged, ImportPath: importPath, Old: oldDecl, New: newDecl})
+			}
+		}
+		for name, oldDecl := range oldPkg.Decls {
+			if _, found := newPkg.Decls[name]; !found {
+				r.Changes = append(r.Changes, &Change{Kind: Removed, ImportPath: importPath, Old: oldDecl})
+			}
+		}
+	}
+	for importPath, oldPkg := range base {
+		if _, found := head[importPath]; !found {
+			r.Changes = append(r.Changes, &Change{Kind: Removed, ImportPath: importPath, Old: packageDecl(oldPkg)})
+		}
+	}
+
+	for _, c := range r.Changes {
+		c.Compatible = compatible(c, base[c.ImportPath])
+	}
+	sort.Slice(r.Changes, func(i, j int) bool {
+		a, b := r.Changes[i], r.Changes[j]
+		if a.ImportPath != b.ImportPath {
+			return a.ImportPath < b.ImportPath
+		}
+		// package level changes come first.
+		if ak, bk := a.Decl().Kind == KindPackage, b.Decl().Kind == KindPackage; ak != bk {
+			return ak
+		}
+		return a.Decl().Name < b.Decl().Name
+	})
+	return r
+}
+
+// packageDecl returns the declaration standing for the package as a whole.
+func packageDecl(pkg *Package) *Decl {
+	return &Decl{
+		Kind:      KindPackage,
+		Name:      pkg.Name,
+		Signature: "package " + pkg.Name,
+		Doc:       pkg.Doc,
+	}
+}
+
+// Count returns the number of changes of the given kind.
+func (r *Report) Count(kind string) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary is a short account of a Report, small enough to be passed around
+// in a container termination message.
+type Summary struct {
+	Added      int `json:"added"`
+	Removed    int `json:"removed"`
+	Changed    int `json:"changed"`
+	DocChanged int `json:"doc_changed"`
+	// Incompatible is the number of changes which are not compatible.
+	Incompatible int `json:"incompatible"`
+	// Changes describes the first changes of the report, the incompatible
+	// ones first, then removals and signature changes.
+	Changes []string `json:"changes,omitempty"`
+	// Problems is the number of doc comment problems of the report.
+	Problems int `json:"problems,omitempty"`
+	// Lint lists the first doc comment problems of the report.
+	Lint []Problem `json:"lint,omitempty"`
+}
+
+// Summary returns the summary of the report describing up to maxChanges
+// changes and doc comment problems each.
+func (r *Report) Summary(maxChanges int) *Summary {
+	s := &Summary{
+		Added:      r.Count(Added),
+		Removed:    r.Count(Removed),
+		Changed:    r.Count(Changed),
+		DocChanged: r.Count(DocChanged),
+		Problems:   len(r.Problems),
+	}
+	s.Lint = r.Problems
+	if len(s.Lint) > maxChanges {
+		s.Lint = s.Lint[:maxChanges]
+	}
+	incompatible := r.Incompatible()
+	s.Incompatible = len(incompatible)
+	for _, c := range incompatible {
+		if len(s.Changes) == maxChanges {
+			return s
+		}
+		s.Changes = append(s.Changes, c.String())
+	}
+	for _, kind := range []string{Removed, Changed, Added, DocChanged} {
+		for _, c := range r.Changes {
+			if len(s.Changes) == maxChanges {
+				return s
+			}
+			if c.Kind == kind && c.Compatible {
+				s.Changes = append(s.Changes, c.String())
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package apidiff
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fixtureChanges are the changes from the base to the head fixture tree, as
+// printed by Change.String along with their compatibility.
+var fixtureChanges = []struct {
+	change     string
+	compatible bool
+}{
+	{"doc-changed package example.com/api", true},
+	{"doc-changed method api.Client.Get", true},
+	{"added field api.Client.Port", true},
+	// renaming parameters is compatible.
+	{"changed func api.Do", true},
+	// other packages may implement Doer.
+	{"added method api.Doer.Close", false},
+	{"removed func api.Removed", false},
+	{"changed const api.Version", false},
+	{"added package example.com/api/newpkg", true},
+	{"added func newpkg.New", true},
+	{"removed package example.com/api/oldpkg", false},
+}
+
+func TestDiff(t *testing.T) {
+	r := Diff(loadFixture(t, "base"), loadFixture(t, "head"))
+	if len(r.Changes) != len(fixtureChanges) {
+		for _, c := range r.Changes {
+			t.Log(c)
+		}
+		t.Fatalf("got %d changes, want %d", len(r.Changes), len(fixtureChanges))
+	}
+	for i, want := range fixtureChanges {
+		c := r.Changes[i]
+		if c.String() != want.change || c.Compatible != want.compatible {
+			t.Errorf("change %d: got %s compatible %v, want %s compatible %v", i, c, c.Compatible, want.change, want.compatible)
+		}
+	}
+
+	tests := []struct {
+		name string
+		kind string
+		want int
+	}{
+		{"added", Added, 4},
+		{"removed", Removed, 2},
+		{"changed", Changed, 2},
+		{"doc changed", DocChanged, 2},
+	}
+	for _, test := range tests {
+		if got := r.Count(test.kind); got != test.want {
+			t.Errorf("%s: got %d changes, want %d", test.name, got, test.want)
+		}
+	}
+	if got := len(r.Incompatible()); got != 4 {
+		t.Errorf("got %d incompatible changes, want 4", got)
+	}
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	head := loadFixture(t, "head")
+	if r := Diff(head, head); len(r.Changes) != 0 {
+		t.Errorf("got changes %v between the same trees", r.Changes)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	r := Diff(loadFixture(t, "base"), loadFixture(t, "head"))
+	r.Problems = []Problem{{Message: "first"}, {Message: "second"}}
+	tests := []struct {
+		maxChanges int
+		changes    []string
+		lint       int
+	}{
+		{
+			maxChanges: 2,
+			changes:    []string{"added method api.Doer.Close", "removed func api.Removed"},
+			lint:       2,
+		},
+		{
+			// the incompatible changes come first, then the compatible
+			// removals, signature changes, additions and doc changes.
+			maxChanges: 6,
+			changes: []string{
+				"added method api.Doer.Close",
+				"removed func api.Removed",
+				"changed const api.Version",
+				"removed package example.com/api/oldpkg",
+				"changed func api.Do",
+				"added field api.Client.Port",
+			},
+			lint: 2,
+		},
+		{maxChanges: 0, lint: 0},
+	}
+	for _, test := range tests {
+		s := r.Summary(test.maxChanges)
+		if s.Added != 4 || s.Removed != 2 || s.Changed != 2 || s.DocChanged != 2 || s.Incompatible != 4 || s.Problems != 2 {
+			t.Errorf("max %d: got counts %+v", test.maxChanges, s)
+		}
+		if !reflect.DeepEqual(s.Changes, test.changes) {
+			t.Errorf("max %d: got changes %q, want %q", test.maxChanges, s.Changes, test.changes)
+		}
+		if len(s.Lint) != test.lint {
+			t.Errorf("max %d: got %d problems, want %d", test.maxChanges, len(s.Lint), test.lint)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package apidiff
+
+import (
+	"html/template"
+	"io"
+)
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; }
+.added { background: #e6ffed; }
+.removed { background: #ffeef0; }
+.changed { background: #fff5b1; }
+.doc-changed { background: #f1f8ff; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Report.Count "added"}} added, {{.Report.Count "removed"}} removed, {{.Report.Count "changed"}} changed, {{.Report.Count "doc-changed"}} doc comments changed.
+{{with .Report.Incompatible}}<strong>{{len .}} changes are incompatible.</strong>{{else}}All the changes are compatible.{{end}}</p>
+{{- range .Packages}}
+<h2>{{.ImportPath}}</h2>
+<table>
+<tr><th>Change</th><th>Compatible</th><th>Declaration</th><th>Base</th><th>Head</th></tr>
+{{- range .Changes}}
+<tr class="{{.Kind}}">
+<td>{{.Kind}}</td>
+<td>{{if .Compatible}}yes{{else}}<strong>no</strong>{{end}}</td>
+<td>{{.Decl.Kind}} {{.Decl.Name}}</td>
+<td>{{with .Old}}<pre>{{.Signature}}</pre>{{if .Doc}}<pre>{{.Doc}}</pre>{{end}}{{end}}</td>
+<td>{{with .New}}<pre>{{.Signature}}</pre>{{if .Doc}}<pre>{{.Doc}}</pre>{{end}}{{end}}</td>
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>The exported API is unchanged.</p>
+{{- end}}
+{{- with .Report.Problems}}
+<h2>Doc comment problems</h2>
+<ul>
+{{- range .}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// packageChanges are the changes of a package.
+type packageChanges struct {
+	ImportPath string
+	Changes    []*Change
+}
+
+// WriteHTML writes the report as an HTML page with the given title.
+func (r *Report) WriteHTML(w io.Writer, title string) error {
+	var pkgs []*packageChanges
+	for _, c := range r.Changes {
+		if len(pkgs) == 0 || pkgs[len(pkgs)-1].ImportPath != c.ImportPath {
+			pkgs = append(pkgs, &packageChanges{ImportPath: c.ImportPath})
+		}
+		pkgs[len(pkgs)-1].Changes = append(pkgs[len(pkgs)-1].Changes, c)
+	}
+	return reportTemplate.Execute(w, map[string]interface{}{
+		"Title":    title,
+		"Report":   r,
+		"Packages": pkgs,
+	})
+}
@@ -0,0 +1,55 @@
+package apidiff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		report *Report
+		want   []string
+	}{
+		{
+			name: "changes",
+			report: func() *Report {
+				r := Diff(loadFixture(t, "base"), loadFixture(t, "head"))
+				r.Problems = []Problem{{Message: "comment on exported func New should be of the form \"New ...\""}}
+				return r
+			}(),
+			want: []string{
+				"<title>API changes &lt;head&gt;</title>",
+				"4 added, 2 removed, 2 changed, 2 doc comments changed.",
+				"<strong>4 changes are incompatible.</strong>",
+				"<h2>example.com/api/newpkg</h2>",
+				`<tr class="removed">`,
+				"<pre>func (Doer) Close() error</pre>",
+				"<h2>Doc comment problems</h2>",
+				"comment on exported func New should be of the form &#34;New ...&#34;",
+			},
+		},
+		{
+			name:   "no changes",
+			report: &Report{},
+			want: []string{
+				"All the changes are compatible.",
+				"<p>The exported API is unchanged.</p>",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := test.report.WriteHTML(&buf, "API changes <head>"); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("HTML does not contain %q:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package apidiff
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Problem is a doc comment problem of the exported API.
+type Problem struct {
+	// File and Line of the declaration, the file is relative to the root of
+	// the checkout. Line is zero for problems of a package as a whole.
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// Lint records in the report the doc comment problems of the declarations it
+// shows as added or changed in head, and of the packages of head without a
+// package comment. Only the files listed in touched, relative to the root of
+// the checkout, are linted; typically the files changed by a PR.
+//
+// Following golint, the comment of a function, method or type must start with
+// its name, optionally preceded by an article for types. Constants and
+// variables only need a comment, which may be the comment of their group.
+// Struct fields and interface methods need none.
+func (r *Report) Lint(head API, touched []string) []Problem {
+	files := map[string]bool{}
+	for _, f := range touched {
+		files[f] = true
+	}
+
+	r.Problems = nil
+	for _, c := range r.Changes {
+		d := c.New
+		if d == nil || d.Kind == KindPackage || !files[d.Pos.Filename] {
+			continue
+		}
+		if msg := lintDecl(d); msg != "" {
+			r.Problems = append(r.Problems, Problem{File: d.Pos.Filename, Line: d.Pos.Line, Message: msg})
+		}
+	}
+
+	for _, pkg := range head {
+		if pkg.Doc != "" {
+			continue
+		}
+		// the problem is reported on the first touched file of the package.
+		var pkgFiles []string
+		for f := range files {
+			if path.Dir(f) == path.Clean(pkg.Dir) && strings.HasSuffix(f, ".go") && !strings.HasSuffix(f, "_test.go") {
+				pkgFiles = append(pkgFiles, f)
+			}
+		}
+		if len(pkgFiles) == 0 {
+			continue
+		}
+		sort.Strings(pkgFiles)
+		r.Problems = append(r.Problems, Problem{File: pkgFiles[0], Message: fmt.Sprintf("package %s should have a package comment", pkg.Name)})
+	}
+
+	sort.SliceStable(r.Problems, func(i, j int) bool {
+		a, b := r.Problems[i], r.Problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return r.Problems
+}
+
+// lintDecl returns the doc comment problem of the declaration, or an empty
+// string if it has none.
+func lintDecl(d *Decl) string {
+	if d.Kind == KindField || d.InInterface {
+		return ""
+	}
+	if d.Doc == "" {
+		return fmt.Sprintf("exported %s %s should have a comment", d.Kind, d.Name)
+	}
+	if d.Kind == KindConst || d.Kind == KindVar {
+		return ""
+	}
+
+	name := d.Name[strings.LastIndex(d.Name, ".")+1:]
+	doc := d.Doc
+	if d.Kind == KindType {
+		for _, article := range []string{"A ", "An ", "The "} {
+			doc = strings.TrimPrefix(doc, article)
+		}
+	}
+	if !strings.HasPrefix(doc, name+" ") && doc != name {
+		return fmt.Sprintf("comment on exported %s %s should be of the form \"%s ...\"", d.Kind, d.Name, name)
+	}
+	return ""
+}
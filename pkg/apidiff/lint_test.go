@@ -0,0 +1,88 @@
+package apidiff
+
+import (
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestLintDecl(t *testing.T) {
+	tests := []struct {
+		decl *Decl
+		want string
+	}{
+		{&Decl{Kind: KindFunc, Name: "Do", Doc: "Do does the request.\n"}, ""},
+		{&Decl{Kind: KindFunc, Name: "Do"}, "exported func Do should have a comment"},
+		{&Decl{Kind: KindFunc, Name: "Do", Doc: "Does the request.\n"}, `comment on exported func Do should be of the form "Do ..."`},
+		{&Decl{Kind: KindMethod, Name: "Client.Get", Doc: "Get gets the path.\n"}, ""},
+		{&Decl{Kind: KindMethod, Name: "Client.Get", Doc: "Client.Get gets the path.\n"}, `comment on exported method Client.Get should be of the form "Get ..."`},
+		{&Decl{Kind: KindType, Name: "Client", Doc: "A Client calls the API.\n"}, ""},
+		{&Decl{Kind: KindType, Name: "Client", Doc: "Calls the API.\n"}, `comment on exported type Client should be of the form "Client ..."`},
+		{&Decl{Kind: KindConst, Name: "Version", Doc: "The version of the API.\n"}, ""},
+		{&Decl{Kind: KindVar, Name: "Default"}, "exported var Default should have a comment"},
+		{&Decl{Kind: KindField, Name: "Client.Host"}, ""},
+		{&Decl{Kind: KindMethod, Name: "Doer.Close", InInterface: true}, ""},
+	}
+	for _, test := range tests {
+		if got := lintDecl(test.decl); got != test.want {
+			t.Errorf("%s %s: got %q, want %q", test.decl.Kind, test.decl.Name, got, test.want)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	pos := func(file string, line int) token.Position {
+		return token.Position{Filename: file, Line: line}
+	}
+	base := API{
+		"example.com/api": {
+			ImportPath: "example.com/api",
+			Name:       "api",
+			Doc:        "Package api is linted.\n",
+			Decls: map[string]*Decl{
+				"Old": {Kind: KindFunc, Name: "Old", Pos: pos("api.go", 3)},
+			},
+		},
+	}
+	head := API{
+		"example.com/api": {
+			ImportPath: "example.com/api",
+			Name:       "api",
+			Doc:        "Package api is linted.\n",
+			Decls: map[string]*Decl{
+				// unchanged declarations are not linted.
+				"Old": {Kind: KindFunc, Name: "Old", Pos: pos("api.go", 3)},
+				"New": {Kind: KindFunc, Name: "New", Pos: pos("api.go", 9)},
+				"Do":  {Kind: KindFunc, Name: "Do", Doc: "Does.\n", Pos: pos("api.go", 5)},
+				// only the touched files are linted.
+				"Other": {Kind: KindFunc, Name: "Other", Pos: pos("other.go", 1)},
+			},
+		},
+		// the declarations of new packages are linted.
+		"example.com/api/newpkg": {
+			ImportPath: "example.com/api/newpkg",
+			Name:       "newpkg",
+			Dir:        "newpkg",
+			Decls: map[string]*Decl{
+				"Make": {Kind: KindFunc, Name: "Make", Pos: pos("newpkg/b.go", 2)},
+			},
+		},
+	}
+	touched := []string{"api.go", "newpkg/b.go", "newpkg/a.go", "newpkg/a_test.go", "newpkg/README.md"}
+	want := []Problem{
+		{File: "api.go", Line: 5, Message: `comment on exported func Do should be of the form "Do ..."`},
+		{File: "api.go", Line: 9, Message: "exported func New should have a comment"},
+		{File: "newpkg/a.go", Message: "package newpkg should have a package comment"},
+		{File: "newpkg/b.go", Line: 2, Message: "exported func Make should have a comment"},
+	}
+	r := Diff(base, head)
+	if got := r.Lint(head, touched); !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(r.Problems, want) {
+		t.Errorf("got problems %v recorded in the report, want %v", r.Problems, want)
+	}
+	if got := r.Lint(head, nil); len(got) != 0 {
+		t.Errorf("got problems %v without touched files", got)
+	}
+}
@@ -0,0 +1,29 @@
+// Package api is the base fixture of the apidiff tests.
+package api
+
+// Version is the version of the API.
+const Version = "1"
+
+// Client calls the API.
+type Client struct {
+	// Host is the host of the API.
+	Host string
+
+	timeout int
+}
+
+// Get gets the path.
+func (c *Client) Get(path string) error { return nil }
+
+// Do does the request.
+func Do(req string) error { return nil }
+
+// Doer does requests.
+type Doer interface {
+	Do(req string) error
+}
+
+// Removed is removed in the head.
+func Removed() {}
+
+func unexported() {}
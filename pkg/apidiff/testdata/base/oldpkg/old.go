@@ -0,0 +1,5 @@
+// Package oldpkg is removed in the head.
+package oldpkg
+
+// Old is removed along with its package.
+func Old() {}
@@ -0,0 +1,30 @@
+// Package api is the head fixture of the apidiff tests.
+package api
+
+// Version is the version of the API.
+const Version = "2"
+
+// Client calls the API.
+type Client struct {
+	// Host is the host of the API.
+	Host string
+	// Port is the port of the API.
+	Port int
+
+	timeout int
+}
+
+// Get gets the path, relative to the host.
+func (c *Client) Get(path string) error { return nil }
+
+// Do does the request.
+func Do(request string) error { return nil }
+
+// Doer does requests.
+type Doer interface {
+	Do(req string) error
+	// Close closes the doer.
+	Close() error
+}
+
+func unexported() {}
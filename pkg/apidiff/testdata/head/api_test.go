@@ -0,0 +1,4 @@
+package api
+
+// TestOnly is declared in a test file.
+func TestOnly() {}
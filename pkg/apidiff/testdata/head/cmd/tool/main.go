@@ -0,0 +1,6 @@
+package main
+
+// Exported is not part of the API of a command.
+func Exported() {}
+
+func main() {}
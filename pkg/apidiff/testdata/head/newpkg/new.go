@@ -0,0 +1,5 @@
+// Package newpkg is added in the head.
+package newpkg
+
+// New is added along with its package.
+func New() {}
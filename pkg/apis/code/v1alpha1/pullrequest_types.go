@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -10,16 +11,223 @@ type PullRequestSpec struct {
 	URL string `json:"url"`
 
 	// Latest commit ID on the PR. This is optional.
-	CommitID string `json:"commit_id, omitempty"`
+	CommitID string `json:"commit_id,omitempty"`
+
+	// Commit ID of the base branch the PR is compared with. This is
+	// optional.
+	BaseCommitID string `json:"base_commit_id,omitempty"`
+
+	// Modules lists the module paths, or the subdirectories of the repo, to
+	// document. All the modules of the repo are documented if empty.
+	Modules []string `json:"modules,omitempty"`
+
+	// Fetch configures the checkout of private repos and the download of
+	// private dependencies. This is optional.
+	Fetch *FetchSpec `json:"fetch,omitempty"`
+}
+
+// FetchSpec configures how the commits of a PR and the dependencies of its
+// modules are fetched.
+type FetchSpec struct {
+	// Name of the Secret in the namespace of the PullRequest holding the git
+	// credentials: an HTTPS token under the token key, along with an
+	// optional username, or an SSH private key under the ssh-privatekey key
+	// along with the known_hosts of the git hosts.
+	SecretName string `json:"secret_name,omitempty"`
+
+	// GOPRIVATE of the dependency download, the module path patterns of
+	// the private modules which are fetched with the git credentials.
+	GoPrivate string `json:"goprivate,omitempty"`
+
+	// GONOSUMDB of the dependency download, defaults to GOPRIVATE.
+	GoNoSumDB string `json:"gonosumdb,omitempty"`
+
+	// GOPROXY of the dependency download, overriding the one of the
+	// controller.
+	GoProxy string `json:"goproxy,omitempty"`
 }
 
 // PullRequestStatus defines the observed state of PullRequest
 type PullRequestStatus struct {
-	// The URL which is serving the godoc for the PR.
+	// The URL which is serving the godoc for the PR, the link of the first
+	// documented module.
 	GoDocLink string `json:"godoc_link"`
 
+	// The URLs serving the godoc of each documented module of the PR.
+	GoDocLinks []ModuleLink `json:"godoc_links,omitempty"`
+
 	// CommitID for which the godoc is being served
 	CommitID string `json:"commit_id"`
+
+	// ID of the comment on the Github PR which carries the godoc link.
+	CommentID int64 `json:"comment_id,omitempty"`
+
+	// CommitID the godoc link comment was last updated for.
+	CommentCommitID string `json:"comment_commit_id,omitempty"`
+
+	// State of the godoc commit status last reported to Github.
+	CommitStatusState string `json:"commit_status_state,omitempty"`
+
+	// CommitID the godoc commit status was last reported for.
+	CommitStatusCommitID string `json:"commit_status_commit_id,omitempty"`
+
+	// State of the PR in Github. One of open, closed or merged.
+	State string `json:"state,omitempty"`
+
+	// Time at which the PR was found to be closed or merged.
+	ClosedAt *metav1.Time `json:"closed_at,omitempty"`
+
+	// Archived is set when godoc is no longer served for a closed PR.
+	Archived bool `json:"archived,omitempty"`
+
+	// The generation of the PullRequest spec the status was computed for.
+	ObservedGeneration int64 `json:"observed_generation,omitempty"`
+
+	// Time at which the PR was last synced with Github. Syncs which change
+	// nothing are only recorded every few minutes.
+	LastSyncTime *metav1.Time `json:"last_sync_time,omitempty"`
+
+	// Conditions describe the progress of serving godoc for the PR.
+	Conditions []PullRequestCondition `json:"conditions,omitempty"`
+
+	// Changes made by the PR to the exported API of the repo.
+	APIDiff *APIDiff `json:"api_diff,omitempty"`
+
+	// Compatibility of the changes made by the PR to the exported API.
+	APICompatibility *APICompatibility `json:"api_compatibility,omitempty"`
+
+	// Doc comment problems of the exported API added or changed by the PR.
+	DocLint *DocLint `json:"doc_lint,omitempty"`
+
+	// Labels of the PR in Github, as last received by the webhook or
+	// fetched for the compatibility of its API changes.
+	Labels *PullRequestLabels `json:"labels,omitempty"`
+}
+
+// PullRequestLabels are the labels of a PR when its head was at a commit.
+type PullRequestLabels struct {
+	// CommitID of the head of the PR when the labels were received.
+	CommitID string `json:"commit_id"`
+
+	// Names of the labels.
+	Names []string `json:"names,omitempty"`
+}
+
+// APIDiff summarizes the changes made by a PR to the exported API of the
+// repo, between its base and head commits.
+type APIDiff struct {
+	// CommitID of the head of the PR.
+	CommitID string `json:"commit_id"`
+
+	// BaseCommitID the API of CommitID is compared with.
+	BaseCommitID string `json:"base_commit_id"`
+
+	// Number of exported declarations added, removed, whose signature
+	// changed and whose doc comment changed.
+	Added      int32 `json:"added"`
+	Removed    int32 `json:"removed"`
+	Changed    int32 `json:"changed"`
+	DocChanged int32 `json:"doc_changed"`
+
+	// Descriptions of the first changes, e.g. "removed func pkg.Foo".
+	Changes []string `json:"changes,omitempty"`
+
+	// The URL of the page listing all the changes.
+	Link string `json:"link,omitempty"`
+}
+
+// APICompatibility tells whether the changes made by a PR to the exported API
+// of the repo follow the Go 1 compatibility rules.
+type APICompatibility struct {
+	// CommitID of the head of the PR.
+	CommitID string `json:"commit_id"`
+
+	// BaseCommitID the API of CommitID is compared with.
+	BaseCommitID string `json:"base_commit_id"`
+
+	// Compatible is false if any change may break the code using the API.
+	Compatible bool `json:"compatible"`
+
+	// Number of incompatible changes.
+	Incompatible int32 `json:"incompatible,omitempty"`
+
+	// Descriptions of the first incompatible changes.
+	IncompatibleChanges []string `json:"incompatible_changes,omitempty"`
+
+	// State of the compatibility commit status last reported to Github.
+	CommitStatusState string `json:"commit_status_state,omitempty"`
+}
+
+// ModuleLink is the godoc link of a module, or of a subdirectory of the repo.
+type ModuleLink struct {
+	// Import path of the module or subdirectory.
+	ImportPath string `json:"import_path"`
+
+	// Link to its godoc.
+	Link string `json:"link"`
+}
+
+// DocLint lists the doc comment problems of the exported identifiers added or
+// changed by a PR in the files it touches, and of the packages of these files
+// without a package comment.
+type DocLint struct {
+	// CommitID of the head of the PR.
+	CommitID string `json:"commit_id"`
+
+	// Number of problems found.
+	Total int32 `json:"total"`
+
+	// The first problems found.
+	Problems []DocProblem `json:"problems,omitempty"`
+
+	// Reviewed is set once the problems are posted as a review of the PR.
+	Reviewed bool `json:"reviewed,omitempty"`
+}
+
+// DocProblem is a doc comment problem.
+type DocProblem struct {
+	// File of the problem relative to the root of the repo.
+	File string `json:"file"`
+
+	// Line of the problem, zero for the problems of a package as a whole.
+	Line int32 `json:"line,omitempty"`
+
+	// Message describing the problem.
+	Message string `json:"message"`
+}
+
+// PullRequestConditionType is the type of a PullRequest condition.
+type PullRequestConditionType string
+
+const (
+	// CommitResolved is true once the commitID of the PR is known.
+	CommitResolved PullRequestConditionType = "CommitResolved"
+	// DeploymentAvailable is true once the godoc deployment serves the
+	// commitID of the PR.
+	DeploymentAvailable PullRequestConditionType = "DeploymentAvailable"
+	// LinkPublished is true once the godoc link for the commitID of the PR is
+	// published in the status.
+	LinkPublished PullRequestConditionType = "LinkPublished"
+	// Ready is true when all the other conditions are true.
+	Ready PullRequestConditionType = "Ready"
+)
+
+// PullRequestCondition describes the state of a PullRequest at a certain point.
+type PullRequestCondition struct {
+	// Type of the condition.
+	Type PullRequestConditionType `json:"type"`
+
+	// Status of the condition, one of True, False or Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// Last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"last_transition_time,omitempty"`
+
+	// One word CamelCase reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+
+	// Human readable message with details about the last transition.
+	Message string `json:"message,omitempty"`
 }
 
 // +genclient
@@ -28,6 +236,7 @@ type PullRequestStatus struct {
 // PullRequest
 // +k8s:openapi-gen=true
 // +kubebuilder:resource:path=pullrequests
+// +kubebuilder:subresource:status
 type PullRequest struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -35,3 +244,36 @@ type PullRequest struct {
 	Spec   PullRequestSpec   `json:"spec,omitempty"`
 	Status PullRequestStatus `json:"status,omitempty"`
 }
+
+// GetCondition returns the condition of the given type, or nil if the status
+// has no such condition.
+func (s *PullRequestStatus) GetCondition(t PullRequestConditionType) *PullRequestCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition of the given type. The last
+// transition time is only changed when the status of the condition changes.
+func (s *PullRequestStatus) SetCondition(t PullRequestConditionType, status corev1.ConditionStatus, reason, message string) {
+	c := s.GetCondition(t)
+	if c == nil {
+		s.Conditions = append(s.Conditions, PullRequestCondition{Type: t})
+		c = &s.Conditions[len(s.Conditions)-1]
+	}
+	if c.Status != status {
+		c.Status = status
+		c.LastTransitionTime = metav1.Now()
+	}
+	c.Reason = reason
+	c.Message = message
+}
+
+// IsConditionTrue returns true if the condition of the given type is true.
+func (s *PullRequestStatus) IsConditionTrue(t PullRequestConditionType) bool {
+	c := s.GetCondition(t)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
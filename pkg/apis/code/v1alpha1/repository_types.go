@@ -0,0 +1,71 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RepositorySpec defines the desired state of Repository
+type RepositorySpec struct {
+	// Host of the repository. Defaults to github.com.
+	Host string `json:"host,omitempty"`
+
+	// Org owning the repository.
+	Org string `json:"org"`
+
+	// Name of the repository.
+	Repo string `json:"repo"`
+
+	// Labels selects the PRs carrying any of the labels. If both Labels and
+	// Paths are set, PRs matching either of them are selected.
+	Labels []string `json:"labels,omitempty"`
+
+	// Paths selects the PRs touching a file matching any of the glob
+	// patterns, e.g. *.go. Patterns without a slash match the base name of
+	// the files.
+	Paths []string `json:"paths,omitempty"`
+
+	// Authors selects the PRs opened by any of the users.
+	Authors []string `json:"authors,omitempty"`
+
+	// BaseBranches selects the PRs against any of the branches.
+	BaseBranches []string `json:"base_branches,omitempty"`
+
+	// MaxPreviews is the maximum number of PRs godoc is served for at the
+	// same time. Unlimited if zero.
+	MaxPreviews int32 `json:"max_previews,omitempty"`
+
+	// Modules lists the module paths, or the subdirectories of the
+	// repository, documented for its PRs. All the modules are documented if
+	// empty.
+	Modules []string `json:"modules,omitempty"`
+
+	// Fetch configures the checkout of the PRs of a private repository and
+	// the download of its private dependencies.
+	Fetch *FetchSpec `json:"fetch,omitempty"`
+}
+
+// RepositoryStatus defines the observed state of Repository
+type RepositoryStatus struct {
+	// Names of the PullRequest objects created for the open PRs of the
+	// repository which are selected.
+	PullRequests []string `json:"pull_requests,omitempty"`
+
+	// The generation of the Repository spec the status was computed for.
+	ObservedGeneration int64 `json:"observed_generation,omitempty"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Repository is a repository whose PRs get godoc previews without registering
+// them one by one.
+// +k8s:openapi-gen=true
+// +kubebuilder:resource:path=repositories
+// +kubebuilder:subresource:status
+type Repository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RepositorySpec   `json:"spec,omitempty"`
+	Status RepositoryStatus `json:"status,omitempty"`
+}
@@ -12,6 +12,7 @@ import (
 type CodeV1alpha1Interface interface {
 	RESTClient() rest.Interface
 	PullRequestsGetter
+	RepositoriesGetter
 }
 
 // CodeV1alpha1Client is used to interact with features provided by the code.godocs.io group.
@@ -23,6 +24,10 @@ func (c *CodeV1alpha1Client) PullRequests(namespace string) PullRequestInterface
 	return newPullRequests(c, namespace)
 }
 
+func (c *CodeV1alpha1Client) Repositories(namespace string) RepositoryInterface {
+	return newRepositories(c, namespace)
+}
+
 // NewForConfig creates a new CodeV1alpha1Client for the given config.
 func NewForConfig(c *rest.Config) (*CodeV1alpha1Client, error) {
 	config := *c
@@ -16,6 +16,10 @@ func (c *FakeCodeV1alpha1) PullRequests(namespace string) v1alpha1.PullRequestIn
 	return &FakePullRequests{c, namespace}
 }
 
+func (c *FakeCodeV1alpha1) Repositories(namespace string) v1alpha1.RepositoryInterface {
+	return &FakeRepositories{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeCodeV1alpha1) RESTClient() rest.Interface {
@@ -0,0 +1,270 @@
+package pullrequest
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AdmissionWebhook serves the validating and mutating admission webhooks of
+// PullRequest objects, so that malformed objects are rejected by the API
+// server instead of being ignored by the reconcilers:
+//   - /mutate normalizes the URL of the PR, e.g. strips /files, /commits and
+//     fragments from it
+//   - /validate rejects the URLs which are not PRs of a supported host, the
+//     commit IDs which are not full hex SHAs, and the objects tracking a PR
+//     which already has a PullRequest object in the namespace
+type AdmissionWebhook struct {
+	Client client.Client
+
+	addr     string
+	certFile string
+	keyFile  string
+	// hosts are the hosts PRs are served from.
+	hosts map[string]bool
+}
+
+// AdmissionWebhookOptions are the options for creating an AdmissionWebhook.
+type AdmissionWebhookOptions struct {
+	// Addr is the address the webhooks are served on over TLS.
+	Addr string
+	// CertFile and KeyFile are the paths of the PEM encoded serving
+	// certificate and its private key, the API server requires TLS.
+	CertFile string
+	KeyFile  string
+	// Hosts are the hosts of the supported PR URLs.
+	Hosts []string
+}
+
+// NewAdmissionWebhook creates the admission webhook server and registers it
+// with the manager, so that it is started and stopped along with the
+// controllers.
+func NewAdmissionWebhook(mgr manager.Manager, opts AdmissionWebhookOptions) (*AdmissionWebhook, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, fmt.Errorf("admission webhooks need a serving certificate and key")
+	}
+	if len(opts.Hosts) == 0 {
+		return nil, fmt.Errorf("admission webhooks need at least one supported host")
+	}
+	wh := &AdmissionWebhook{
+		Client:   mgr.GetClient(),
+		addr:     opts.Addr,
+		certFile: opts.CertFile,
+		keyFile:  opts.KeyFile,
+		hosts:    map[string]bool{},
+	}
+	for _, host := range opts.Hosts {
+		wh.hosts[strings.ToLower(host)] = true
+	}
+	if err := mgr.Add(wh); err != nil {
+		return nil, err
+	}
+	return wh, nil
+}
+
+// Start runs the webhook HTTPS server until stop is closed.
+func (wh *AdmissionWebhook) Start(stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle("/validate", admissionHandler(wh.validate))
+	mux.Handle("/mutate", admissionHandler(wh.mutate))
+	srv := &http.Server{Addr: wh.addr, Handler: mux}
+	go func() {
+		<-stop
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("starting admission webhooks on %s", wh.addr)
+	if err := srv.ListenAndServeTLS(wh.certFile, wh.keyFile); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// admissionReview, admissionRequest and admissionResponse are the parts of
+// the admission.k8s.io/v1beta1 AdmissionReview used by the webhooks, whose
+// API package is not vendored.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Namespace string          `json:"namespace,omitempty"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object,omitempty"`
+	// OldObject is the object being updated by UPDATE requests.
+	OldObject json.RawMessage `json:"oldObject,omitempty"`
+}
+
+type admissionResponse struct {
+	UID       string         `json:"uid"`
+	Allowed   bool           `json:"allowed"`
+	Result    *metav1.Status `json:"status,omitempty"`
+	Patch     []byte         `json:"patch,omitempty"`
+	PatchType *string        `json:"patchType,omitempty"`
+}
+
+// jsonPatchOp is an operation of the JSON patch of a mutating webhook.
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// admissionHandler serves the AdmissionReviews of PullRequest objects with
+// review, which returns the response to the admission request.
+type admissionHandler func(ctx context.Context, req *admissionRequest, pr *v1alpha1.PullRequest) *admissionResponse
+
+func (h admissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	review := &admissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+	req := review.Request
+	resp := &admissionResponse{Allowed: true}
+	if req.Operation == "CREATE" || req.Operation == "UPDATE" {
+		pr := &v1alpha1.PullRequest{}
+		if err := json.Unmarshal(req.Object, pr); err != nil {
+			http.Error(w, "invalid PullRequest object", http.StatusBadRequest)
+			return
+		}
+		if pr.Namespace == "" {
+			pr.Namespace = req.Namespace
+		}
+		resp = h(r.Context(), req, pr)
+	}
+	resp.UID = req.UID
+	review.Request, review.Response = nil, resp
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		log.Printf("error writing the admission review of %s: %v", req.UID, err)
+	}
+}
+
+// mutate patches the URL of the PR to its normalized form.
+func (wh *AdmissionWebhook) mutate(ctx context.Context, req *admissionRequest, pr *v1alpha1.PullRequest) *admissionResponse {
+	normalized, err := normalizePullRequestURL(pr.Spec.URL)
+	if err != nil || normalized == pr.Spec.URL {
+		// invalid URLs are rejected by the validating webhook.
+		return &admissionResponse{Allowed: true}
+	}
+	patch, err := json.Marshal([]jsonPatchOp{{Op: "replace", Path: "/spec/url", Value: normalized}})
+	if err != nil {
+		return denied(err)
+	}
+	log.Printf("normalizing the URL of PullRequest %s/%s from %q to %q", pr.Namespace, pr.Name, pr.Spec.URL, normalized)
+	patchType := "JSONPatch"
+	return &admissionResponse{Allowed: true, Patch: patch, PatchType: &patchType}
+}
+
+// validate rejects the PullRequest objects the reconcilers cannot serve.
+// Updates which leave the spec unchanged, e.g. of the finalizers, and the
+// updates of objects being deleted are allowed, so that the objects created
+// before the webhook was registered, or whose host is no longer supported,
+// can still be updated and deleted.
+func (wh *AdmissionWebhook) validate(ctx context.Context, req *admissionRequest, pr *v1alpha1.PullRequest) *admissionResponse {
+	if req.Operation == "UPDATE" {
+		if pr.DeletionTimestamp != nil {
+			return &admissionResponse{Allowed: true}
+		}
+		old := &v1alpha1.PullRequest{}
+		if err := json.Unmarshal(req.OldObject, old); err == nil && reflect.DeepEqual(old.Spec, pr.Spec) {
+			return &admissionResponse{Allowed: true}
+		}
+	}
+	prinfo, err := wh.validateURL(pr.Spec.URL)
+	if err != nil {
+		return denied(err)
+	}
+	for field, commitID := range map[string]string{"spec.commit_id": pr.Spec.CommitID, "spec.base_commit_id": pr.Spec.BaseCommitID} {
+		if commitID != "" && !validCommitID(commitID) {
+			return denied(fmt.Errorf("%s %q is not the full hex SHA of a commit", field, commitID))
+		}
+	}
+	existing, err := findPullRequest(ctx, wh.Client, pr.Namespace, prinfo)
+	if err != nil {
+		log.Printf("error looking for the PullRequests tracking %s: %v", pr.Spec.URL, err)
+		return denied(err)
+	}
+	if existing != nil && existing.Name != pr.Name {
+		return denied(fmt.Errorf("PR %s is already tracked by PullRequest %s/%s", pr.Spec.URL, existing.Namespace, existing.Name))
+	}
+	return &admissionResponse{Allowed: true}
+}
+
+// validateURL returns the parsed URL of a PR of a supported host.
+func (wh *AdmissionWebhook) validateURL(prURL string) (*prInfo, error) {
+	u, err := url.Parse(prURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("spec.url %q is not an absolute http(s) URL", prURL)
+	}
+	prinfo, err := parsePullRequestURL(prURL)
+	if err != nil {
+		return nil, fmt.Errorf("spec.url %q is not the URL of a pull request: %v", prURL, err)
+	}
+	if !wh.hosts[strings.ToLower(prinfo.host)] {
+		var hosts []string
+		for host := range wh.hosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		return nil, fmt.Errorf("host %s of spec.url is not supported, PRs are served from %s", prinfo.host, strings.Join(hosts, ", "))
+	}
+	return prinfo, nil
+}
+
+// denied returns the response rejecting an admission request for err.
+func denied(err error) *admissionResponse {
+	return &admissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}
+
+// validCommitID returns true if commitID is a full SHA-1 or SHA-256 commit ID.
+func validCommitID(commitID string) bool {
+	if len(commitID) != 40 && len(commitID) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(commitID)
+	return err == nil
+}
+
+// normalizePullRequestURL returns the canonical URL of the PR of prURL, e.g.
+// https://github.com/org/repo/pull/15 for the URL of its files
+// https://github.com/org/repo/pull/15/files#diff-1, without the query and
+// fragment.
+func normalizePullRequestURL(prURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(prURL))
+	if err != nil {
+		return "", err
+	}
+	prinfo, err := parsePullRequestURL(u.String())
+	if err != nil {
+		return "", err
+	}
+	normalized := &url.URL{Scheme: u.Scheme, Host: strings.ToLower(u.Host), Path: prinfo.layout().prPath(prinfo)}
+	return normalized.String(), nil
+}
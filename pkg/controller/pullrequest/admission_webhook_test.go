@@ -0,0 +1,190 @@
+package pullrequest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// review sends the AdmissionReview of the operation on the PullRequest to the
+// handler and returns its response. old is the object being updated, if any.
+func review(t *testing.T, h admissionHandler, operation string, pr, old *v1alpha1.PullRequest) *admissionResponse {
+	req := &admissionRequest{UID: "42", Namespace: pr.Namespace, Operation: operation}
+	var err error
+	if req.Object, err = json.Marshal(pr); err != nil {
+		t.Fatal(err)
+	}
+	if old != nil {
+		if req.OldObject, err = json.Marshal(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := json.Marshal(&admissionReview{
+		APIVersion: "admission.k8s.io/v1beta1",
+		Kind:       "AdmissionReview",
+		Request:    req,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	resp := &admissionReview{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response == nil || resp.Response.UID != "42" {
+		t.Fatalf("got response %+v, want the response to request 42", resp.Response)
+	}
+	return resp.Response
+}
+
+func newTestAdmissionWebhook(c *fakeClient) *AdmissionWebhook {
+	return &AdmissionWebhook{Client: c, hosts: map[string]bool{"github.com": true}}
+}
+
+func TestAdmissionWebhookMutate(t *testing.T) {
+	wh := newTestAdmissionWebhook(newFakeClient())
+	const normalized = "https://github.com/kubernetes-sigs/kubebuilder/pull/1"
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"normalized", normalized, ""},
+		{"files", normalized + "/files", normalized},
+		{"commits", normalized + "/commits/" + sha(1), normalized},
+		{"fragment", normalized + "/files#diff-1", normalized},
+		{"query", normalized + "?w=1", normalized},
+		{"host case", "https://GitHub.com/kubernetes-sigs/kubebuilder/pull/1", normalized},
+		{"invalid", "https://github.com/kubernetes-sigs/kubebuilder", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pr := trackedPullRequest(1, sha(1))
+			pr.Spec.URL = test.url
+			resp := review(t, wh.mutate, "CREATE", pr, nil)
+			if !resp.Allowed {
+				t.Fatalf("got request denied: %+v", resp.Result)
+			}
+			var patch []jsonPatchOp
+			if len(resp.Patch) != 0 {
+				if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.want == "" {
+				if len(patch) != 0 {
+					t.Errorf("got patch %+v, want none", patch)
+				}
+				return
+			}
+			want := []jsonPatchOp{{Op: "replace", Path: "/spec/url", Value: test.want}}
+			if len(patch) != 1 || patch[0] != want[0] {
+				t.Errorf("got patch %+v, want %+v", patch, want)
+			}
+			if resp.PatchType == nil || *resp.PatchType != "JSONPatch" {
+				t.Errorf("got patch type %v, want JSONPatch", resp.PatchType)
+			}
+		})
+	}
+}
+
+func TestAdmissionWebhookValidate(t *testing.T) {
+	existing := trackedPullRequest(1, sha(1))
+	wh := newTestAdmissionWebhook(newFakeClient(existing))
+	tests := []struct {
+		name      string
+		operation string
+		pr        func(pr *v1alpha1.PullRequest)
+		wantError string
+	}{
+		{"valid", "CREATE", func(pr *v1alpha1.PullRequest) {}, ""},
+		{"no commit yet", "CREATE", func(pr *v1alpha1.PullRequest) { pr.Spec.CommitID = "" }, ""},
+		{"sha256 commit", "CREATE", func(pr *v1alpha1.PullRequest) { pr.Spec.CommitID = strings.Repeat("ab", 32) }, ""},
+		{"short commit", "CREATE", func(pr *v1alpha1.PullRequest) { pr.Spec.CommitID = sha(2)[:7] }, "spec.commit_id"},
+		{"non-hex commit", "CREATE", func(pr *v1alpha1.PullRequest) { pr.Spec.CommitID = strings.Repeat("g", 40) }, "spec.commit_id"},
+		{"non-hex base commit", "UPDATE", func(pr *v1alpha1.PullRequest) { pr.Spec.BaseCommitID = "main" }, "spec.base_commit_id"},
+		{"not a PR", "CREATE", func(pr *v1alpha1.PullRequest) { pr.Spec.URL = "https://github.com/kubernetes-sigs/kubebuilder" }, "not the URL of a pull request"},
+		{"relative URL", "CREATE", func(pr *v1alpha1.PullRequest) { pr.Spec.URL = "kubernetes-sigs/kubebuilder/pull/2" }, "absolute"},
+		{"unsupported host", "CREATE", func(pr *v1alpha1.PullRequest) {
+			pr.Spec.URL = "https://github.example.com/kubernetes-sigs/kubebuilder/pull/2"
+		}, "not supported"},
+		{"duplicate", "CREATE", func(pr *v1alpha1.PullRequest) {
+			pr.Name = "kubebuilder-copy"
+			pr.Spec.URL = existing.Spec.URL
+		}, "already tracked by PullRequest docs/kubebuilder-pr-1"},
+		{"duplicate in another namespace", "CREATE", func(pr *v1alpha1.PullRequest) {
+			pr.Namespace = "other"
+			pr.Spec.URL = existing.Spec.URL
+		}, ""},
+		{"update of the tracking object", "UPDATE", func(pr *v1alpha1.PullRequest) {
+			pr.Name = existing.Name
+			pr.Spec.URL = existing.Spec.URL
+		}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pr := trackedPullRequest(2, sha(2))
+			var old *v1alpha1.PullRequest
+			if test.operation == "UPDATE" {
+				old = trackedPullRequest(2, sha(2))
+			}
+			test.pr(pr)
+			resp := review(t, wh.validate, test.operation, pr, old)
+			if test.wantError == "" {
+				if !resp.Allowed {
+					t.Errorf("got request denied: %+v", resp.Result)
+				}
+				return
+			}
+			if resp.Allowed {
+				t.Fatalf("got request allowed, want it denied for %s", test.wantError)
+			}
+			if resp.Result == nil || !strings.Contains(resp.Result.Message, test.wantError) {
+				t.Errorf("got result %+v, want a message containing %q", resp.Result, test.wantError)
+			}
+			if resp.Result.Code != http.StatusUnprocessableEntity {
+				t.Errorf("got code %d, want %d", resp.Result.Code, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+}
+
+func TestAdmissionWebhookUpdateInvalid(t *testing.T) {
+	wh := newTestAdmissionWebhook(newFakeClient())
+	// the object was created before the webhook was registered.
+	old := trackedPullRequest(1, sha(1))
+	old.Spec.URL = "https://gitlab.example.com/org/repo/merge_requests/1"
+	old.Finalizers = []string{staticCleanupFinalizer}
+
+	deleting := old.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	unfinalized := deleting.DeepCopy()
+	unfinalized.Finalizers = nil
+	if resp := review(t, wh.validate, "UPDATE", unfinalized, deleting); !resp.Allowed {
+		t.Errorf("got the removal of the finalizer denied: %+v", resp.Result)
+	}
+
+	labeled := old.DeepCopy()
+	labeled.Labels = map[string]string{"team": "docs"}
+	if resp := review(t, wh.validate, "UPDATE", labeled, old); !resp.Allowed {
+		t.Errorf("got an update of the metadata denied: %+v", resp.Result)
+	}
+
+	changed := old.DeepCopy()
+	changed.Spec.CommitID = "main"
+	if resp := review(t, wh.validate, "UPDATE", changed, old); resp.Allowed {
+		t.Error("got an update of the spec of an invalid object allowed")
+	}
+}
@@ -0,0 +1,124 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// States of a PR in Github as recorded in the PullRequest status.
+const (
+	prStateOpen   = "open"
+	prStateClosed = "closed"
+	prStateMerged = "merged"
+)
+
+// Actions taken on the PullRequest objects of closed PRs.
+const (
+	// ClosedPRDelete deletes the PullRequest object, along with its godoc
+	// deployment, once the grace period has passed.
+	ClosedPRDelete = "delete"
+	// ClosedPRKeep keeps the PullRequest object around and scales its godoc
+	// deployment down to zero.
+	ClosedPRKeep = "keep"
+)
+
+// ClosedPRPolicy decides what happens to PullRequest objects whose PR has been
+// closed or merged in Github.
+type ClosedPRPolicy struct {
+	// Action is either ClosedPRDelete or ClosedPRKeep.
+	Action string
+	// GracePeriod for which godoc keeps being served before the PullRequest
+	// object is deleted. Only used with ClosedPRDelete.
+	GracePeriod time.Duration
+}
+
+// Validate returns an error if the policy is not valid.
+func (p ClosedPRPolicy) Validate() error {
+	switch p.Action {
+	case ClosedPRDelete, ClosedPRKeep:
+	default:
+		return fmt.Errorf("unknown closed PR action %q, must be one of %q or %q", p.Action, ClosedPRDelete, ClosedPRKeep)
+	}
+	if p.GracePeriod < 0 {
+		return fmt.Errorf("closed PR grace period must not be negative")
+	}
+	return nil
+}
+
+// apply records that the PR is closed or merged in the PullRequest status and
+// deletes or archives the PullRequest object according to the policy. It is
+// called every time the PR is found closed, so that deletions which are due
+// after the grace period eventually happen.
+func (p ClosedPRPolicy) apply(ctx context.Context, c client.Client, sw StatusWriter, pr *v1alpha1.PullRequest, state string) error {
+	prCopy := pr.DeepCopy()
+	if prCopy.Status.State != state || prCopy.Status.ClosedAt == nil {
+		now := metav1.Now()
+		prCopy.Status.State = state
+		prCopy.Status.ClosedAt = &now
+	}
+
+	switch p.Action {
+	case ClosedPRKeep:
+		prCopy.Status.Archived = true
+	case ClosedPRDelete:
+		if time.Since(prCopy.Status.ClosedAt.Time) >= p.GracePeriod {
+			log.Printf("deleting PullRequest %s/%s, PR is %s in github", pr.Namespace, pr.Name, state)
+			if err := c.Delete(ctx, pr); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			return nil
+		}
+	}
+
+	if reflect.DeepEqual(pr.Status, prCopy.Status) {
+		return nil
+	}
+	log.Printf("PullRequest %s/%s is %s in github, archived: %v", pr.Namespace, pr.Name, state, prCopy.Status.Archived)
+	return sw.UpdateStatus(ctx, prCopy)
+}
+
+// deletionDelay returns how long is left before the PullRequest object of a
+// closed PR is deleted, and false if the policy does not delete it or the PR
+// is not recorded as closed.
+func (p ClosedPRPolicy) deletionDelay(pr *v1alpha1.PullRequest) (time.Duration, bool) {
+	if p.Action != ClosedPRDelete || !isClosed(pr) || pr.Status.ClosedAt == nil {
+		return 0, false
+	}
+	return pr.Status.ClosedAt.Add(p.GracePeriod).Sub(time.Now()), true
+}
+
+// reopen clears the closed state from the status of a PullRequest whose PR has
+// been reopened in Github. It returns false if the PR was not recorded as
+// closed.
+func reopen(pr *v1alpha1.PullRequest) bool {
+	if !isClosed(pr) {
+		return false
+	}
+	log.Printf("PullRequest %s/%s has been reopened in github", pr.Namespace, pr.Name)
+	pr.Status.State = prStateOpen
+	pr.Status.ClosedAt = nil
+	pr.Status.Archived = false
+	return true
+}
+
+// closedState returns the state recorded for a closed PR.
+func closedState(merged bool) string {
+	if merged {
+		return prStateMerged
+	}
+	return prStateClosed
+}
+
+// isClosed returns true if the PullRequest status records the PR as closed or
+// merged.
+func isClosed(pr *v1alpha1.PullRequest) bool {
+	return pr.Status.State == prStateClosed || pr.Status.State == prStateMerged
+}
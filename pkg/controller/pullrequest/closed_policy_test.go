@@ -0,0 +1,62 @@
+package pullrequest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDeleteClosedPullRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      ClosedPRPolicy
+		state       string
+		closedAgo   time.Duration
+		wantDeleted bool
+		wantRequeue bool
+	}{
+		{"open", ClosedPRPolicy{Action: ClosedPRDelete, GracePeriod: time.Hour}, prStateOpen, 0, false, false},
+		{"in grace period", ClosedPRPolicy{Action: ClosedPRDelete, GracePeriod: time.Hour}, prStateMerged, time.Minute, false, true},
+		{"grace period passed", ClosedPRPolicy{Action: ClosedPRDelete, GracePeriod: time.Hour}, prStateClosed, 2 * time.Hour, true, false},
+		{"keep", ClosedPRPolicy{Action: ClosedPRKeep}, prStateMerged, 2 * time.Hour, false, false},
+		{"no policy", ClosedPRPolicy{}, prStateMerged, 2 * time.Hour, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pr := trackedPullRequest(7, sha(1))
+			pr.Status.State = test.state
+			if test.state != prStateOpen {
+				closedAt := metav1.NewTime(time.Now().Add(-test.closedAgo))
+				pr.Status.ClosedAt = &closedAt
+			}
+			c := newFakeClient(pr)
+			r := &pullRequestReconciler{
+				Client:         c,
+				statusWriter:   c,
+				closedPRPolicy: test.policy,
+				requeuer:       newRequeuer(),
+			}
+
+			deleted, err := r.deleteClosedPullRequest(context.Background(), c.pullRequest(pr.Namespace, pr.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != test.wantDeleted || (c.pullRequest(pr.Namespace, pr.Name) == nil) != test.wantDeleted {
+				t.Errorf("got deleted %v, want %v", deleted, test.wantDeleted)
+			}
+			due, requeued := r.requeuer.due[types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}]
+			if requeued != test.wantRequeue {
+				t.Fatalf("got requeued %v, want %v", requeued, test.wantRequeue)
+			}
+			if !requeued {
+				return
+			}
+			if want := pr.Status.ClosedAt.Add(test.policy.GracePeriod); due.Before(want.Add(-time.Second)) || due.After(want.Add(time.Second)) {
+				t.Errorf("got requeued at %v, want at the end of the grace period %v", due, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package pullrequest
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// godocPort is the port the godoc server listens on.
+const godocPort = 6060
+
+// proxyPort is the port the godoc-proxy sidecar serving godoc under the path
+// prefixes of the ingress exposer listens on.
+const proxyPort = 6061
+
+// Exposer makes the godoc server of a PullRequest reachable from outside the
+// cluster and knows the URL it is reachable at.
+type Exposer interface {
+	// Sidecars returns the containers to run next to the godoc server.
+	Sidecars(prinfo *prInfo) []v1.Container
+	// Expose creates the objects which expose the godoc deployment of pr,
+	// whose pods are matched by selector.
+	Expose(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo, selector map[string]string) error
+	// BaseURL returns the URL godoc of pr is reachable at. It returns an
+	// empty string if the URL is not known yet.
+	BaseURL(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo) (string, error)
+}
+
+// Exposer types.
+const (
+	// ExposeSSHTunnel exposes godoc through a reverse SSH tunnel to a public
+	// tunnel service such as serveo.net.
+	ExposeSSHTunnel = "ssh-tunnel"
+	// ExposeIngress exposes godoc through a ClusterIP Service and an Ingress.
+	ExposeIngress = "ingress"
+	// ExposeNodePort exposes godoc through a NodePort Service.
+	ExposeNodePort = "nodeport"
+)
+
+// ExposerConfig configures how godoc servers are exposed.
+type ExposerConfig struct {
+	// Type of the exposer, one of ExposeSSHTunnel, ExposeIngress or
+	// ExposeNodePort.
+	Type string
+
+	// TunnelHost is the SSH tunnel service, serveo.net by default.
+	TunnelHost string
+	// TunnelImage is the image running the SSH client.
+	TunnelImage string
+
+	// IngressHostPattern is the host godoc is served at by the Ingress. The
+	// placeholders {name}, {org}, {repo} and {pr} are replaced with the
+	// PullRequest object name, the org, the repo and the PR number, e.g.
+	// "{name}.docs.example.com".
+	IngressHostPattern string
+	// IngressPathPattern is the path godoc is served at by the Ingress, with
+	// the same placeholders as IngressHostPattern, e.g. "/pr/{org}/{repo}/{pr}".
+	// If empty, godoc is served at the root of the host. Otherwise, a
+	// godoc-proxy sidecar strips the path from the requests and adds it back
+	// to the absolute links of the godoc pages, so the Ingress must not
+	// rewrite the path.
+	IngressPathPattern string
+	// IngressProxyImage is the image of cmd/godoc-proxy, the sidecar of the
+	// godoc pods served under IngressPathPattern.
+	IngressProxyImage string
+	// IngressClass is set as the kubernetes.io/ingress.class annotation of the
+	// Ingress if not empty.
+	IngressClass string
+	// IngressAnnotations are set on the Ingress.
+	IngressAnnotations map[string]string
+	// IngressTLS serves the godoc link over https.
+	IngressTLS bool
+
+	// NodeHost is the address of the nodes the NodePort is reachable at.
+	NodeHost string
+}
+
+// NewExposer returns the Exposer configured by cfg.
+func NewExposer(cfg ExposerConfig) (Exposer, error) {
+	switch cfg.Type {
+	case ExposeSSHTunnel, "":
+		e := &sshTunnelExposer{host: cfg.TunnelHost, image: cfg.TunnelImage}
+		if e.host == "" {
+			e.host = "serveo.net"
+		}
+		if e.image == "" {
+			e.image = "gcr.io/sunilarora-sandbox/ssh-client:0.0.2"
+		}
+		return e, nil
+	case ExposeIngress:
+		if cfg.IngressHostPattern == "" {
+			return nil, fmt.Errorf("ingress host pattern is required for the %s exposer", ExposeIngress)
+		}
+		annotations := map[string]string{}
+		for k, v := range cfg.IngressAnnotations {
+			annotations[k] = v
+		}
+		if cfg.IngressClass != "" {
+			annotations["kubernetes.io/ingress.class"] = cfg.IngressClass
+		}
+		e := &ingressExposer{
+			hostPattern: cfg.IngressHostPattern,
+			pathPattern: cfg.IngressPathPattern,
+			proxyImage:  cfg.IngressProxyImage,
+			annotations: annotations,
+			tls:         cfg.IngressTLS,
+		}
+		if e.proxyImage == "" {
+			e.proxyImage = "gcr.io/sunilarora-sandbox/godoc-proxy:0.0.1"
+		}
+		return e, nil
+	case ExposeNodePort:
+		if cfg.NodeHost == "" {
+			return nil, fmt.Errorf("node host is required for the %s exposer", ExposeNodePort)
+		}
+		return &nodePortExposer{nodeHost: cfg.NodeHost}, nil
+	default:
+		return nil, fmt.Errorf("unknown exposer %q, must be one of %q, %q or %q", cfg.Type, ExposeSSHTunnel, ExposeIngress, ExposeNodePort)
+	}
+}
+
+// sshTunnelExposer runs an SSH client sidecar which opens a reverse tunnel
+// from the tunnel service to the godoc server.
+type sshTunnelExposer struct {
+	host  string
+	image string
+}
+
+func (e *sshTunnelExposer) Sidecars(prinfo *prInfo) []v1.Container {
+	tunnelArgs := fmt.Sprintf("%s:80:localhost:%d", prinfo.subdomain(), godocPort)
+	return []v1.Container{
+		{
+			Image:           e.image,
+			Name:            "ssh",
+			ImagePullPolicy: "Always",
+			Command:         []string{"ssh"},
+			Args:            []string{"-tt", "-o", "StrictHostKeyChecking=no", "-R", tunnelArgs, e.host},
+		},
+	}
+}
+
+func (e *sshTunnelExposer) Expose(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo, selector map[string]string) error {
+	return nil
+}
+
+func (e *sshTunnelExposer) BaseURL(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo) (string, error) {
+	return fmt.Sprintf("https://%s.%s", prinfo.subdomain(), e.host), nil
+}
+
+// ingressExposer exposes godoc with a ClusterIP Service and an Ingress routing
+// a per PR host, or a per PR path of a shared host, to it. godoc links its
+// pages with absolute paths, so it is served under the per PR paths by a
+// godoc-proxy sidecar.
+type ingressExposer struct {
+	hostPattern string
+	pathPattern string
+	proxyImage  string
+	annotations map[string]string
+	tls         bool
+}
+
+func (e *ingressExposer) Sidecars(prinfo *prInfo) []v1.Container {
+	if e.pathPattern == "" {
+		return nil
+	}
+	return []v1.Container{
+		{
+			Name:  "godoc-proxy",
+			Image: e.proxyImage,
+			Args: []string{
+				"-listen", fmt.Sprintf(":%d", proxyPort),
+				"-target", fmt.Sprintf("http://localhost:%d", godocPort),
+				"-path-pattern", e.pathPattern,
+			},
+		},
+	}
+}
+
+func (e *ingressExposer) Expose(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo, selector map[string]string) error {
+	targetPort := godocPort
+	if e.pathPattern != "" {
+		targetPort = proxyPort
+	}
+	if err := reconcileService(ctx, c, serviceForPullRequest(pr, v1.ServiceTypeClusterIP, selector, targetPort)); err != nil {
+		return err
+	}
+	return reconcileIngress(ctx, c, e.ingressForPullRequest(pr, prinfo))
+}
+
+// BaseURL returns the URL built from the host and path of the Ingress of pr.
+func (e *ingressExposer) BaseURL(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo) (string, error) {
+	ing := &extensionsv1beta1.Ingress{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}, ing)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(ing.Spec.Rules) == 0 || ing.Spec.Rules[0].HTTP == nil || len(ing.Spec.Rules[0].HTTP.Paths) == 0 {
+		return "", nil
+	}
+	scheme := "http"
+	if e.tls {
+		scheme = "https"
+	}
+	rule := ing.Spec.Rules[0]
+	return fmt.Sprintf("%s://%s%s", scheme, rule.Host, strings.TrimSuffix(rule.HTTP.Paths[0].Path, "/")), nil
+}
+
+// expand replaces the placeholders of pattern with the details of pr.
+func (e *ingressExposer) expand(pattern string, pr *v1alpha1.PullRequest, prinfo *prInfo) string {
+	return strings.NewReplacer(
+		"{name}", pr.Name,
+		"{org}", strings.ToLower(prinfo.org),
+		"{repo}", strings.ToLower(prinfo.repo),
+		"{pr}", strconv.FormatInt(prinfo.pr, 10),
+	).Replace(pattern)
+}
+
+// path returns the path godoc of pr is served at.
+func (e *ingressExposer) path(pr *v1alpha1.PullRequest, prinfo *prInfo) string {
+	if e.pathPattern == "" {
+		return "/"
+	}
+	return "/" + strings.Trim(e.expand(e.pathPattern, pr, prinfo), "/")
+}
+
+// ingressForPullRequest creates an Ingress object routing the host of pr to
+// its godoc Service.
+func (e *ingressExposer) ingressForPullRequest(pr *v1alpha1.PullRequest, prinfo *prInfo) *extensionsv1beta1.Ingress {
+	ing := &extensionsv1beta1.Ingress{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "extensions/v1beta1",
+			Kind:       "Ingress",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pr.Name,
+			Namespace: pr.Namespace,
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: e.expand(e.hostPattern, pr, prinfo),
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+							Paths: []extensionsv1beta1.HTTPIngressPath{
+								{
+									Path: e.path(pr, prinfo),
+									Backend: extensionsv1beta1.IngressBackend{
+										ServiceName: serviceName(pr),
+										ServicePort: intstr.FromInt(godocPort),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if len(e.annotations) > 0 {
+		ing.Annotations = map[string]string{}
+		for k, v := range e.annotations {
+			ing.Annotations[k] = v
+		}
+	}
+	addOwnerRefToObject(ing, pullRequestOwnerRef(pr))
+	return ing
+}
+
+// nodePortExposer exposes godoc with a NodePort Service.
+type nodePortExposer struct {
+	nodeHost string
+}
+
+func (e *nodePortExposer) Sidecars(prinfo *prInfo) []v1.Container {
+	return nil
+}
+
+func (e *nodePortExposer) Expose(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo, selector map[string]string) error {
+	return reconcileService(ctx, c, serviceForPullRequest(pr, v1.ServiceTypeNodePort, selector, godocPort))
+}
+
+func (e *nodePortExposer) BaseURL(ctx context.Context, c client.Client, pr *v1alpha1.PullRequest, prinfo *prInfo) (string, error) {
+	svc := &v1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: serviceName(pr)}, svc)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, port := range svc.Spec.Ports {
+		if port.NodePort != 0 {
+			return fmt.Sprintf("http://%s:%d", e.nodeHost, port.NodePort), nil
+		}
+	}
+	// node port is not allocated yet
+	return "", nil
+}
+
+// serviceName returns the name of the godoc Service of pr. Service names are
+// DNS-1035 labels, which the names of PullRequest objects are not when, e.g.,
+// the org starts with a digit. Such names are prefixed, and shortened with a
+// hash of the name if they get too long.
+func serviceName(pr *v1alpha1.PullRequest) string {
+	if len(validation.IsDNS1035Label(pr.Name)) == 0 {
+		return pr.Name
+	}
+	name := "godoc-" + strings.Replace(pr.Name, ".", "-", -1)
+	if len(name) > validation.DNS1035LabelMaxLength {
+		sum := sha1.Sum([]byte(pr.Name))
+		name = name[:validation.DNS1035LabelMaxLength-9] + "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return name
+}
+
+// serviceForPullRequest creates a Service object of the given type selecting
+// the godoc pods of pr, whose targetPort serves godoc.
+func serviceForPullRequest(pr *v1alpha1.PullRequest, serviceType v1.ServiceType, selector map[string]string, targetPort int) *v1.Service {
+	svc := &v1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceName(pr),
+			Namespace: pr.Namespace,
+		},
+		Spec: v1.ServiceSpec{
+			Type:     serviceType,
+			Selector: selector,
+			Ports: []v1.ServicePort{
+				{
+					Name:       "http",
+					Protocol:   v1.ProtocolTCP,
+					Port:       godocPort,
+					TargetPort: intstr.FromInt(targetPort),
+				},
+			},
+		},
+	}
+	addOwnerRefToObject(svc, pullRequestOwnerRef(pr))
+	return svc
+}
+
+// reconcileService creates the desired Service, or updates the existing one if
+// it has drifted from the desired state. Fields allocated by K8s, such as the
+// cluster IP and node ports, are kept.
+func reconcileService(ctx context.Context, c client.Client, desired *v1.Service) error {
+	svc := &v1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, svc)
+	if errors.IsNotFound(err) {
+		log.Printf("creating service %s/%s", desired.Namespace, desired.Name)
+		return c.Create(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	ports := make([]v1.ServicePort, len(desired.Spec.Ports))
+	for i, port := range desired.Spec.Ports {
+		ports[i] = port
+		for _, existing := range svc.Spec.Ports {
+			if existing.Name == port.Name && desired.Spec.Type == svc.Spec.Type {
+				ports[i].NodePort = existing.NodePort
+			}
+		}
+	}
+	if svc.Spec.Type == desired.Spec.Type &&
+		reflect.DeepEqual(svc.Spec.Selector, desired.Spec.Selector) &&
+		reflect.DeepEqual(svc.Spec.Ports, ports) {
+		return nil
+	}
+
+	log.Printf("service %s/%s has drifted, updating it", desired.Namespace, desired.Name)
+	svcCopy := svc.DeepCopy()
+	svcCopy.Spec.Type = desired.Spec.Type
+	svcCopy.Spec.Selector = desired.Spec.Selector
+	svcCopy.Spec.Ports = ports
+	return c.Update(ctx, svcCopy)
+}
+
+// reconcileIngress creates the desired Ingress, or updates the existing one if
+// its rules or annotations have drifted from the desired state.
+func reconcileIngress(ctx context.Context, c client.Client, desired *extensionsv1beta1.Ingress) error {
+	ing := &extensionsv1beta1.Ingress{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, ing)
+	if errors.IsNotFound(err) {
+		log.Printf("creating ingress %s/%s", desired.Namespace, desired.Name)
+		return c.Create(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	annotationsDrifted := false
+	for k, v := range desired.Annotations {
+		if ing.Annotations[k] != v {
+			annotationsDrifted = true
+		}
+	}
+	if !annotationsDrifted && reflect.DeepEqual(ing.Spec, desired.Spec) {
+		return nil
+	}
+
+	log.Printf("ingress %s/%s has drifted, updating it", desired.Namespace, desired.Name)
+	ingCopy := ing.DeepCopy()
+	ingCopy.Spec = desired.Spec
+	if ingCopy.Annotations == nil {
+		ingCopy.Annotations = map[string]string{}
+	}
+	for k, v := range desired.Annotations {
+		ingCopy.Annotations[k] = v
+	}
+	return c.Update(ctx, ingCopy)
+}
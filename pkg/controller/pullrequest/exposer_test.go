@@ -0,0 +1,64 @@
+package pullrequest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestServiceName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"kubernetes-sigs-controller-runtime-pr-15", "kubernetes-sigs-controller-runtime-pr-15"},
+		{"99designs-gqlgen-pr-1", "godoc-99designs-gqlgen-pr-1"},
+		{"docs.example.com-pr-1", "godoc-docs-example-com-pr-1"},
+		{"1" + strings.Repeat("a", 62), ""},
+	}
+	for _, test := range tests {
+		got := serviceName(namedPullRequest(test.name))
+		if errs := validation.IsDNS1035Label(got); len(errs) > 0 {
+			t.Errorf("name of the Service of %s is %q: %v", test.name, got, errs)
+		}
+		if test.want != "" && got != test.want {
+			t.Errorf("got name %q for the Service of %s, want %q", got, test.name, test.want)
+		}
+	}
+}
+
+// namedPullRequest returns a PullRequest object of the given name.
+func namedPullRequest(name string) *v1alpha1.PullRequest {
+	return &v1alpha1.PullRequest{ObjectMeta: metaFor("docs", name)}
+}
+
+func TestReconcileServiceStable(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient()
+	desired := func() *v1.Service {
+		return serviceForPullRequest(namedPullRequest("org-repo-pr-1"), v1.ServiceTypeClusterIP, map[string]string{pullRequestLabel: "org-repo-pr-1"}, godocPort)
+	}
+	if err := reconcileService(ctx, c, desired()); err != nil {
+		t.Fatal(err)
+	}
+	// the API server defaults the protocol of the ports, which must not
+	// make the Service drift.
+	svc := &v1.Service{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "docs", Name: "org-repo-pr-1"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Ports[0].Protocol != v1.ProtocolTCP {
+		t.Fatalf("got protocol %q, want %q", svc.Spec.Ports[0].Protocol, v1.ProtocolTCP)
+	}
+	if err := reconcileService(ctx, c, desired()); err != nil {
+		t.Fatal(err)
+	}
+	if c.updates != 0 {
+		t.Error("Service was updated although it has not drifted")
+	}
+}
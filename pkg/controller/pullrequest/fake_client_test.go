@@ -0,0 +1,323 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeClient is an in-memory client.Client keeping objects by kind, namespace
+// and name. The kind of list objects is the name of their type without the
+// List suffix.
+type fakeClient struct {
+	mu      sync.Mutex
+	objects map[fakeKey]runtime.Object
+	nextUID int
+	// updates and statusUpdates count the calls to Update and
+	// UpdateStatus.
+	updates       int
+	statusUpdates int
+	// indexes are the field indexes added with IndexField, keyed by field.
+	indexes map[string]client.IndexerFunc
+	// logs are the logs of containers read with ReadLogs, keyed by
+	// "namespace/pod/container".
+	logs map[string]string
+}
+
+type fakeKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+var (
+	_ client.Client       = &fakeClient{}
+	_ client.FieldIndexer = &fakeClient{}
+)
+
+// newFakeClient returns a fakeClient holding copies of the objects.
+func newFakeClient(objs ...runtime.Object) *fakeClient {
+	c := &fakeClient{objects: map[fakeKey]runtime.Object{}, indexes: map[string]client.IndexerFunc{}, logs: map[string]string{}}
+	for _, obj := range objs {
+		if err := c.Create(context.Background(), obj); err != nil {
+			panic(err)
+		}
+	}
+	return c
+}
+
+func kindOf(obj runtime.Object) string {
+	return reflect.TypeOf(obj).Elem().Name()
+}
+
+func (c *fakeClient) key(obj runtime.Object) (fakeKey, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return fakeKey{}, err
+	}
+	return fakeKey{kind: kindOf(obj), namespace: m.GetNamespace(), name: m.GetName()}, nil
+}
+
+func notFound(key fakeKey) error {
+	return errors.NewNotFound(schema.GroupResource{Resource: strings.ToLower(key.kind)}, key.name)
+}
+
+func (c *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := fakeKey{kind: kindOf(obj), namespace: key.Namespace, name: key.Name}
+	stored, found := c.objects[k]
+	if !found {
+		return notFound(k)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(stored.DeepCopyObject()).Elem())
+	return nil
+}
+
+func (c *fakeClient) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kind := strings.TrimSuffix(kindOf(list), "List")
+	var keys []fakeKey
+	for k := range c.objects {
+		if k.kind == kind && (opts == nil || opts.Namespace == "" || opts.Namespace == k.namespace) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].namespace+"/"+keys[i].name < keys[j].namespace+"/"+keys[j].name
+	})
+	var items []runtime.Object
+	for _, k := range keys {
+		obj := c.objects[k]
+		if opts != nil && opts.LabelSelector != nil {
+			m, _ := meta.Accessor(obj)
+			if !opts.LabelSelector.Matches(labels.Set(m.GetLabels())) {
+				continue
+			}
+		}
+		if opts != nil && opts.FieldSelector != nil {
+			matches, err := c.matchesFields(obj, opts.FieldSelector)
+			if err != nil {
+				return err
+			}
+			if !matches {
+				continue
+			}
+		}
+		items = append(items, obj.DeepCopyObject())
+	}
+	return meta.SetList(list, items)
+}
+
+// matchesFields returns true if the indexed fields of the object match the
+// selector, which must require exact values as with the cache of the manager.
+func (c *fakeClient) matchesFields(obj runtime.Object, sel fields.Selector) (bool, error) {
+	for _, req := range sel.Requirements() {
+		extract, found := c.indexes[req.Field]
+		if !found || req.Operator != selection.Equals && req.Operator != selection.DoubleEquals {
+			return false, fmt.Errorf("field selector %s is not supported", sel)
+		}
+		matches := false
+		for _, v := range extract(obj) {
+			matches = matches || v == req.Value
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// IndexField adds an index of the field, which List then selects on.
+func (c *fakeClient) IndexField(obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes[field] = extractValue
+	return nil
+}
+
+func (c *fakeClient) Create(ctx context.Context, obj runtime.Object) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, err := c.key(obj)
+	if err != nil {
+		return err
+	}
+	if _, found := c.objects[k]; found {
+		return errors.NewAlreadyExists(schema.GroupResource{Resource: strings.ToLower(k.kind)}, k.name)
+	}
+	m, _ := meta.Accessor(obj)
+	if m.GetUID() == "" {
+		c.nextUID++
+		m.SetUID(types.UID(fmt.Sprintf("uid-%d", c.nextUID)))
+	}
+	m.SetGeneration(1)
+	c.objects[k] = obj.DeepCopyObject()
+	return nil
+}
+
+func (c *fakeClient) Update(ctx context.Context, obj runtime.Object) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updates++
+	k, err := c.key(obj)
+	if err != nil {
+		return err
+	}
+	stored, found := c.objects[k]
+	if !found {
+		return notFound(k)
+	}
+	// like the status subresource, updates of PullRequests and
+	// Repositories keep their status.
+	obj = obj.DeepCopyObject()
+	switch o := obj.(type) {
+	case *v1alpha1.PullRequest:
+		old := stored.(*v1alpha1.PullRequest)
+		o.Status = old.Status
+		if !reflect.DeepEqual(o.Spec, old.Spec) {
+			o.Generation = old.Generation + 1
+		}
+	case *v1alpha1.Repository:
+		old := stored.(*v1alpha1.Repository)
+		o.Status = old.Status
+		if !reflect.DeepEqual(o.Spec, old.Spec) {
+			o.Generation = old.Generation + 1
+		}
+	}
+	// objects being deleted go away with their last finalizer.
+	if m, _ := meta.Accessor(obj); m.GetDeletionTimestamp() != nil && len(m.GetFinalizers()) == 0 {
+		delete(c.objects, k)
+		return nil
+	}
+	c.objects[k] = obj
+	return nil
+}
+
+func (c *fakeClient) Delete(ctx context.Context, obj runtime.Object) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, err := c.key(obj)
+	if err != nil {
+		return err
+	}
+	stored, found := c.objects[k]
+	if !found {
+		return notFound(k)
+	}
+	// objects with finalizers are only marked as being deleted.
+	if m, _ := meta.Accessor(stored); len(m.GetFinalizers()) > 0 {
+		if m.GetDeletionTimestamp() == nil {
+			now := metav1.Now()
+			m.SetDeletionTimestamp(&now)
+		}
+		return nil
+	}
+	delete(c.objects, k)
+	return nil
+}
+
+// ReadLogs implements PodLogReader by returning the logs set for the
+// container.
+func (c *fakeClient) ReadLogs(ctx context.Context, pod *v1.Pod, container string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logs, found := c.logs[pod.Namespace+"/"+pod.Name+"/"+container]
+	if !found {
+		return nil, errors.NewNotFound(v1.Resource("pods/log"), pod.Name)
+	}
+	return []byte(logs), nil
+}
+
+// DeleteJob implements JobDeleter by deleting the job along with the pods
+// labeled with its name.
+func (c *fakeClient) DeleteJob(ctx context.Context, job *batchv1.Job) error {
+	if err := c.Delete(ctx, job); err != nil {
+		return err
+	}
+	pods := &v1.PodList{}
+	opts := client.InNamespace(job.Namespace).MatchingLabels(map[string]string{"job-name": job.Name})
+	if err := c.List(ctx, opts, pods); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		if err := c.Delete(ctx, &pods.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateStatus implements StatusWriter by only writing the status of the
+// stored PullRequest.
+func (c *fakeClient) UpdateStatus(ctx context.Context, pr *v1alpha1.PullRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statusUpdates++
+	k, err := c.key(pr)
+	if err != nil {
+		return err
+	}
+	stored, found := c.objects[k]
+	if !found {
+		return notFound(k)
+	}
+	updated := stored.DeepCopyObject().(*v1alpha1.PullRequest)
+	updated.Status = *pr.Status.DeepCopy()
+	c.objects[k] = updated
+	*pr = *updated.DeepCopy()
+	return nil
+}
+
+// UpdateRepositoryStatus implements StatusWriter by only writing the status
+// of the stored Repository.
+func (c *fakeClient) UpdateRepositoryStatus(ctx context.Context, repo *v1alpha1.Repository) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statusUpdates++
+	k, err := c.key(repo)
+	if err != nil {
+		return err
+	}
+	stored, found := c.objects[k]
+	if !found {
+		return notFound(k)
+	}
+	updated := stored.DeepCopyObject().(*v1alpha1.Repository)
+	updated.Status = *repo.Status.DeepCopy()
+	c.objects[k] = updated
+	*repo = *updated.DeepCopy()
+	return nil
+}
+
+// pullRequest returns the stored PullRequest, or nil if there is none.
+func (c *fakeClient) pullRequest(namespace, name string) *v1alpha1.PullRequest {
+	pr := &v1alpha1.PullRequest{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, pr); err != nil {
+		return nil
+	}
+	return pr
+}
+
+// metaFor returns the ObjectMeta of the named object.
+func metaFor(namespace, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: namespace, Name: name}
+}
@@ -0,0 +1,310 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+var _ GithubClient = &fakeGithubClient{}
+
+// fakeGithubClient is an in-memory GithubClient for tests. PRs are added to it
+// with AddPullRequest, and the comments, reviews and statuses created through it
+// can be inspected with Comments, Reviews and Statuses.
+type fakeGithubClient struct {
+	mu sync.Mutex
+
+	// pull requests keyed by "owner/repo" and then by PR number.
+	pullRequests map[string]map[int]*github.PullRequest
+	// files changed by pull requests keyed by "owner/repo#number".
+	files map[string][]*github.CommitFile
+	// labels of pull requests keyed by "owner/repo#number".
+	labels map[string][]*github.Label
+	// comments keyed by "owner/repo#number".
+	comments map[string][]*github.IssueComment
+	// reviews keyed by "owner/repo#number" in the order they were created.
+	reviews map[string][]*github.PullRequestReviewRequest
+	// statuses keyed by "owner/repo@ref" in the order they were created.
+	statuses map[string][]*github.RepoStatus
+
+	nextCommentID int64
+	nextReviewID  int64
+}
+
+// newFakeGithubClient returns an empty fakeGithubClient.
+func newFakeGithubClient() *fakeGithubClient {
+	return &fakeGithubClient{
+		pullRequests: map[string]map[int]*github.PullRequest{},
+		files:        map[string][]*github.CommitFile{},
+		labels:       map[string][]*github.Label{},
+		comments:     map[string][]*github.IssueComment{},
+		reviews:      map[string][]*github.PullRequestReviewRequest{},
+		statuses:     map[string][]*github.RepoStatus{},
+	}
+}
+
+// AddPullRequest adds or replaces a PR of the given repo.
+func (f *fakeGithubClient) AddPullRequest(owner, repo string, pr *github.PullRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := repoKey(owner, repo)
+	if f.pullRequests[key] == nil {
+		f.pullRequests[key] = map[int]*github.PullRequest{}
+	}
+	f.pullRequests[key][pr.GetNumber()] = pr
+}
+
+// DeletePullRequest removes a PR of the given repo.
+func (f *fakeGithubClient) DeletePullRequest(owner, repo string, number int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.pullRequests[repoKey(owner, repo)], number)
+}
+
+// SetFiles sets the names of the files changed by the given PR.
+func (f *fakeGithubClient) SetFiles(owner, repo string, number int, filenames ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var files []*github.CommitFile
+	for _, name := range filenames {
+		files = append(files, &github.CommitFile{Filename: github.String(name)})
+	}
+	f.files[issueKey(owner, repo, number)] = files
+}
+
+// SetPatch sets the patch of a file changed by the given PR, the file is added
+// to the files changed by the PR if needed.
+func (f *fakeGithubClient) SetPatch(owner, repo string, number int, filename, patch string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := issueKey(owner, repo, number)
+	for _, file := range f.files[key] {
+		if file.GetFilename() == filename {
+			file.Patch = github.String(patch)
+			return
+		}
+	}
+	f.files[key] = append(f.files[key], &github.CommitFile{Filename: github.String(filename), Patch: github.String(patch)})
+}
+
+// SetLabels sets the labels of the given PR.
+func (f *fakeGithubClient) SetLabels(owner, repo string, number int, names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var labels []*github.Label
+	for _, name := range names {
+		labels = append(labels, &github.Label{Name: github.String(name)})
+	}
+	f.labels[issueKey(owner, repo, number)] = labels
+}
+
+// Comments returns the comments on the given PR.
+func (f *fakeGithubClient) Comments(owner, repo string, number int) []*github.IssueComment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*github.IssueComment(nil), f.comments[issueKey(owner, repo, number)]...)
+}
+
+// Reviews returns the reviews posted on the given PR, oldest first.
+func (f *fakeGithubClient) Reviews(owner, repo string, number int) []*github.PullRequestReviewRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*github.PullRequestReviewRequest(nil), f.reviews[issueKey(owner, repo, number)]...)
+}
+
+// Statuses returns the statuses created for the given ref, oldest first.
+func (f *fakeGithubClient) Statuses(owner, repo, ref string) []*github.RepoStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*github.RepoStatus(nil), f.statuses[refKey(owner, repo, ref)]...)
+}
+
+func (f *fakeGithubClient) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pr, found := f.pullRequests[repoKey(owner, repo)][number]
+	if !found {
+		return nil, fakeResponse(http.StatusNotFound), notFoundError()
+	}
+	return pr, fakeResponse(http.StatusOK), nil
+}
+
+func (f *fakeGithubClient) ListPullRequests(ctx context.Context, owner, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state := "open"
+	if opt != nil && opt.State != "" {
+		state = opt.State
+	}
+	var prs []*github.PullRequest
+	for _, pr := range f.pullRequests[repoKey(owner, repo)] {
+		if state == "all" || pr.GetState() == state || (pr.State == nil && state == "open") {
+			prs = append(prs, pr)
+		}
+	}
+	sort.Slice(prs, func(i, j int) bool { return prs[i].GetNumber() > prs[j].GetNumber() })
+
+	var listOpt github.ListOptions
+	if opt != nil {
+		listOpt = opt.ListOptions
+	}
+	start, end, resp := paginate(len(prs), listOpt)
+	return prs[start:end], resp, nil
+}
+
+func (f *fakeGithubClient) ListFiles(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, found := f.pullRequests[repoKey(owner, repo)][number]; !found {
+		return nil, fakeResponse(http.StatusNotFound), notFoundError()
+	}
+	return append([]*github.CommitFile(nil), f.files[issueKey(owner, repo, number)]...), fakeResponse(http.StatusOK), nil
+}
+
+func (f *fakeGithubClient) ListLabelsByIssue(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, found := f.pullRequests[repoKey(owner, repo)][number]; !found {
+		return nil, fakeResponse(http.StatusNotFound), notFoundError()
+	}
+	return append([]*github.Label(nil), f.labels[issueKey(owner, repo, number)]...), fakeResponse(http.StatusOK), nil
+}
+
+func (f *fakeGithubClient) ListComments(ctx context.Context, owner, repo string, number int, opt *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	comments := f.Comments(owner, repo, number)
+	var listOpt github.ListOptions
+	if opt != nil {
+		listOpt = opt.ListOptions
+	}
+	start, end, resp := paginate(len(comments), listOpt)
+	return comments[start:end], resp, nil
+}
+
+func (f *fakeGithubClient) CreateComment(ctx context.Context, owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextCommentID++
+	c := &github.IssueComment{
+		ID:   github.Int64(f.nextCommentID),
+		Body: github.String(comment.GetBody()),
+	}
+	key := issueKey(owner, repo, number)
+	f.comments[key] = append(f.comments[key], c)
+	return c, fakeResponse(http.StatusCreated), nil
+}
+
+func (f *fakeGithubClient) EditComment(ctx context.Context, owner, repo string, id int64, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, comments := range f.comments {
+		if !strings.HasPrefix(key, repoKey(owner, repo)+"#") {
+			continue
+		}
+		for _, c := range comments {
+			if c.GetID() == id {
+				c.Body = github.String(comment.GetBody())
+				return c, fakeResponse(http.StatusOK), nil
+			}
+		}
+	}
+	return nil, fakeResponse(http.StatusNotFound), notFoundError()
+}
+
+func (f *fakeGithubClient) CreateReview(ctx context.Context, owner, repo string, number int, review *github.PullRequestReviewRequest) (*github.PullRequestReview, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, found := f.pullRequests[repoKey(owner, repo)][number]; !found {
+		return nil, fakeResponse(http.StatusNotFound), notFoundError()
+	}
+	f.nextReviewID++
+	key := issueKey(owner, repo, number)
+	r := *review
+	f.reviews[key] = append(f.reviews[key], &r)
+	return &github.PullRequestReview{
+		ID:       github.Int64(f.nextReviewID),
+		Body:     r.Body,
+		CommitID: r.CommitID,
+		State:    github.String("COMMENTED"),
+	}, fakeResponse(http.StatusOK), nil
+}
+
+func (f *fakeGithubClient) CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := refKey(owner, repo, ref)
+	s := *status
+	f.statuses[key] = append(f.statuses[key], &s)
+	return &s, fakeResponse(http.StatusCreated), nil
+}
+
+func repoKey(owner, repo string) string {
+	return fmt.Sprintf("%s/%s", owner, repo)
+}
+
+func issueKey(owner, repo string, number int) string {
+	return fmt.Sprintf("%s/%s#%d", owner, repo, number)
+}
+
+func refKey(owner, repo, ref string) string {
+	return fmt.Sprintf("%s/%s@%s", owner, repo, ref)
+}
+
+// paginate returns the bounds of the page of n items requested by opt, and the
+// response pointing to the next page the way Github does.
+func paginate(n int, opt github.ListOptions) (int, int, *github.Response) {
+	page, perPage := 1, 30
+	if opt.Page > 0 {
+		page = opt.Page
+	}
+	if opt.PerPage > 0 {
+		perPage = opt.PerPage
+	}
+	resp := fakeResponse(http.StatusOK)
+	start := (page - 1) * perPage
+	if start >= n {
+		return n, n, resp
+	}
+	end := start + perPage
+	if end < n {
+		resp.NextPage = page + 1
+	} else {
+		end = n
+	}
+	return start, end, resp
+}
+
+func fakeResponse(code int) *github.Response {
+	return &github.Response{Response: &http.Response{StatusCode: code, Header: http.Header{}}}
+}
+
+func notFoundError() error {
+	return &github.ErrorResponse{
+		Response: &http.Response{
+			StatusCode: http.StatusNotFound,
+			Request:    &http.Request{Method: "GET", URL: &url.URL{}},
+		},
+		Message: "Not Found",
+	}
+}
@@ -0,0 +1,97 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+// GithubClient is the subset of the Github API used by the controllers. It
+// mirrors the methods of github.Client so that the controllers can be tested
+// against an in-memory fake.
+type GithubClient interface {
+	// GetPullRequest fetches a single PR.
+	GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error)
+	// ListPullRequests lists the PRs of a repo.
+	ListPullRequests(ctx context.Context, owner, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error)
+	// ListFiles lists the files changed by a PR.
+	ListFiles(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.CommitFile, *github.Response, error)
+	// ListLabelsByIssue lists the labels of a PR.
+	ListLabelsByIssue(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.Label, *github.Response, error)
+
+	// ListComments lists the comments on a PR.
+	ListComments(ctx context.Context, owner, repo string, number int, opt *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error)
+	// CreateComment adds a comment to a PR.
+	CreateComment(ctx context.Context, owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	// EditComment updates an existing comment on a PR.
+	EditComment(ctx context.Context, owner, repo string, id int64, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	// CreateReview posts a review of a PR, with comments on lines of its
+	// diff.
+	CreateReview(ctx context.Context, owner, repo string, number int, review *github.PullRequestReviewRequest) (*github.PullRequestReview, *github.Response, error)
+
+	// CreateStatus creates a commit status for the given ref.
+	CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error)
+}
+
+// GithubClients maps the hosts of PR URLs to the client of their Github API,
+// e.g. github.com to a client of https://api.github.com/ and a Github
+// Enterprise host github.mycorp.com to one of https://github.mycorp.com/api/v3/.
+type GithubClients map[string]GithubClient
+
+// lookup returns the client of the Github host of the PR.
+func (c GithubClients) lookup(prinfo *prInfo) (GithubClient, error) {
+	ghClient, found := c[prinfo.host]
+	if !found || prinfo.provider != ProviderGithub {
+		return nil, fmt.Errorf("no Github API configured for host %s", prinfo.host)
+	}
+	return ghClient, nil
+}
+
+// NewGithubClient returns a GithubClient backed by the given github.Client.
+// Its calls are suspended while the Github host rate limits us, so all the
+// users of the client back off together.
+func NewGithubClient(c *github.Client) GithubClient {
+	return rateLimited(c.BaseURL.Host, &githubClient{c: c})
+}
+
+// githubClient implements GithubClient by calling the Github API.
+type githubClient struct {
+	c *github.Client
+}
+
+func (gc *githubClient) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	return gc.c.PullRequests.Get(ctx, owner, repo, number)
+}
+
+func (gc *githubClient) ListPullRequests(ctx context.Context, owner, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	return gc.c.PullRequests.List(ctx, owner, repo, opt)
+}
+
+func (gc *githubClient) ListFiles(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+	return gc.c.PullRequests.ListFiles(ctx, owner, repo, number, opt)
+}
+
+func (gc *githubClient) ListLabelsByIssue(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
+	return gc.c.Issues.ListLabelsByIssue(ctx, owner, repo, number, opt)
+}
+
+func (gc *githubClient) ListComments(ctx context.Context, owner, repo string, number int, opt *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	return gc.c.Issues.ListComments(ctx, owner, repo, number, opt)
+}
+
+func (gc *githubClient) CreateComment(ctx context.Context, owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	return gc.c.Issues.CreateComment(ctx, owner, repo, number, comment)
+}
+
+func (gc *githubClient) EditComment(ctx context.Context, owner, repo string, id int64, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	return gc.c.Issues.EditComment(ctx, owner, repo, int(id), comment)
+}
+
+func (gc *githubClient) CreateReview(ctx context.Context, owner, repo string, number int, review *github.PullRequestReviewRequest) (*github.PullRequestReview, *github.Response, error) {
+	return gc.c.PullRequests.CreateReview(ctx, owner, repo, number, review)
+}
+
+func (gc *githubClient) CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error) {
+	return gc.c.Repositories.CreateStatus(ctx, owner, repo, ref, status)
+}
@@ -0,0 +1,299 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/controller"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/source"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// commentMarker is embedded in the comments posted by the bot so that the
+// comment can be found again if its ID is lost from the PullRequest status.
+const commentMarker = "<!-- godocbot -->"
+
+// GithubCommenter watches PullRequest objects and keeps a single comment on
+// the Github PR up to date with the godoc link and the commitID the godoc was
+// built from. The doc comment problems of the API changed by each commit are
+// posted once as a review of the PR.
+type GithubCommenter struct {
+	controller.Controller
+}
+
+func NewGithubCommenter(mgr manager.Manager, ghClients GithubClients) (*GithubCommenter, error) {
+	statusWriter, err := newStatusWriter(mgr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := controller.New(
+		"github-pullrequest-commenter",
+		mgr,
+		controller.Options{
+			Reconcile: &pullRequestCommentReconciler{
+				Client:       mgr.GetClient(),
+				statusWriter: statusWriter,
+				ghClients:    ghClients,
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch PullRequests objects
+	if err := c.Watch(
+		&source.Kind{Type: &v1alpha1.PullRequest{}},
+		&handler.Enqueue{}); err != nil {
+		return nil, err
+	}
+	return &GithubCommenter{Controller: c}, nil
+}
+
+// pullRequestCommentReconciler posts the godoc link of a PullRequest to Github
+// once it is available and edits the comment whenever godoc is served for a
+// new commit. It reviews the doc comments of a commit once they are linted.
+type pullRequestCommentReconciler struct {
+	Client       client.Client
+	statusWriter StatusWriter
+	ghClients    GithubClients
+}
+
+func (r *pullRequestCommentReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+
+	pr := &v1alpha1.PullRequest{}
+	err := r.Client.Get(ctx, request.NamespacedName, pr)
+	if errors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		log.Printf("Could not fetch PullRequest %v for %+v\n", err, request)
+		return reconcile.Result{}, err
+	}
+
+	if pr.Status.GoDocLink == "" || pr.Status.CommitID == "" {
+		// godoc is not being served yet.
+		return reconcile.Result{}, nil
+	}
+	commentStale := pr.Status.CommentID == 0 || pr.Status.CommentCommitID != pr.Status.CommitID
+	lint := pr.Status.DocLint
+	reviewPending := lint != nil && lint.CommitID == pr.Status.CommitID && !lint.Reviewed && lint.Total > 0
+	if !commentStale && !reviewPending {
+		// comment and review are up to date.
+		return reconcile.Result{}, nil
+	}
+
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil {
+		log.Printf("error in parsing the request, ignoring this pr %v err %v", request.NamespacedName, err)
+		return reconcile.Result{}, nil
+	}
+	ghClient, err := r.ghClients.lookup(prinfo)
+	if err != nil {
+		// only PRs of Github hosts are commented on.
+		return reconcile.Result{}, nil
+	}
+
+	prCopy := pr.DeepCopy()
+	if commentStale {
+		commentID, err := upsertComment(ctx, ghClient, prinfo, pr.Status.CommentID, godocLinkComment(pr.Status.GoDocLink, pr.Status.GoDocLinks, pr.Status.CommitID, pr.Status.APIDiff))
+		if err != nil {
+			log.Printf("error commenting on PR %v: %v", request.NamespacedName, err)
+			return reconcile.Result{}, err
+		}
+		prCopy.Status.CommentID = commentID
+		prCopy.Status.CommentCommitID = pr.Status.CommitID
+		log.Printf("godoc link comment %d updated successfully for pr %v", commentID, request.NamespacedName)
+	}
+	if reviewPending {
+		if err := postDocLintReview(ctx, ghClient, prinfo, lint); err != nil {
+			log.Printf("error posting the doc comment review on PR %v: %v", request.NamespacedName, err)
+			return reconcile.Result{}, err
+		}
+		prCopy.Status.DocLint.Reviewed = true
+		log.Printf("doc comment review of commit %s posted successfully for pr %v", lint.CommitID, request.NamespacedName)
+	}
+	if err = r.statusWriter.UpdateStatus(ctx, prCopy); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// upsertComment edits the bot comment with the given ID, or the one found on
+// the PR if the ID is not known, and creates a new comment if there is none. It
+// returns the ID of the comment.
+func upsertComment(ctx context.Context, ghClient GithubClient, prinfo *prInfo, commentID int64, body string) (int64, error) {
+	if commentID == 0 {
+		var err error
+		if commentID, err = findComment(ctx, ghClient, prinfo); err != nil {
+			return 0, err
+		}
+	}
+
+	comment := &github.IssueComment{Body: github.String(body)}
+	if commentID != 0 {
+		_, resp, err := ghClient.EditComment(ctx, prinfo.org, prinfo.repo, commentID, comment)
+		if err == nil {
+			return commentID, nil
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return 0, err
+		}
+		// comment has been deleted, post a new one.
+	}
+
+	c, _, err := ghClient.CreateComment(ctx, prinfo.org, prinfo.repo, int(prinfo.pr), comment)
+	if err != nil {
+		return 0, err
+	}
+	return c.GetID(), nil
+}
+
+// findComment pages through the comments of the PR and returns the ID of the
+// first one carrying commentMarker, or 0 if there is none.
+func findComment(ctx context.Context, ghClient GithubClient, prinfo *prInfo) (int64, error) {
+	opt := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := ghClient.ListComments(ctx, prinfo.org, prinfo.repo, int(prinfo.pr), opt)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range comments {
+			if strings.Contains(c.GetBody(), commentMarker) {
+				return c.GetID(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return 0, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// godocLinkComment returns the body of the comment carrying the godoc link, or
+// the links of the modules if there are several, along with the summary of the
+// API changes if they are known for commitID.
+func godocLinkComment(link string, links []v1alpha1.ModuleLink, commitID string, diff *v1alpha1.APIDiff) string {
+	body := fmt.Sprintf("%s\nGodoc for this PR is available at %s\n\nBuilt from commit %s.", commentMarker, link, commitID)
+	if len(links) > 1 {
+		body = fmt.Sprintf("%s\nGodoc for this PR is available for the modules:\n", commentMarker)
+		for _, l := range links {
+			body += fmt.Sprintf("\n- `%s`: %s", l.ImportPath, l.Link)
+		}
+		body += fmt.Sprintf("\n\nBuilt from commit %s.", commitID)
+	}
+	if diff == nil || diff.CommitID != commitID {
+		return body
+	}
+	if diff.Added+diff.Removed+diff.Changed+diff.DocChanged == 0 {
+		return body + fmt.Sprintf("\n\nThe exported API is unchanged since %s.", diff.BaseCommitID)
+	}
+	body += fmt.Sprintf("\n\n**Exported API changes** since %s: %d added, %d removed, %d changed, %d doc comments changed. See the [full list](%s).\n",
+		diff.BaseCommitID, diff.Added, diff.Removed, diff.Changed, diff.DocChanged, diff.Link)
+	for _, c := range diff.Changes {
+		body += fmt.Sprintf("\n- `%s`", c)
+	}
+	return body
+}
+
+// postDocLintReview posts the doc comment problems as a review of the commit
+// they were found in. Problems on lines of the diff of the PR are commented on
+// inline, the others are listed in the body of the review.
+func postDocLintReview(ctx context.Context, ghClient GithubClient, prinfo *prInfo, lint *v1alpha1.DocLint) error {
+	positions, err := diffPositions(ctx, ghClient, prinfo)
+	if err != nil {
+		return err
+	}
+
+	var comments []*github.DraftReviewComment
+	var others []string
+	for _, p := range lint.Problems {
+		if pos, found := positions[p.File][int(p.Line)]; found && p.Line != 0 {
+			comments = append(comments, &github.DraftReviewComment{
+				Path:     github.String(p.File),
+				Position: github.Int(pos),
+				Body:     github.String(p.Message),
+			})
+			continue
+		}
+		if p.Line == 0 {
+			others = append(others, fmt.Sprintf("- `%s`: %s", p.File, p.Message))
+		} else {
+			others = append(others, fmt.Sprintf("- `%s:%d`: %s", p.File, p.Line, p.Message))
+		}
+	}
+
+	body := fmt.Sprintf("%s\nFound %d doc comment problems in the exported API changed by commit %s.", commentMarker, lint.Total, lint.CommitID)
+	if len(others) > 0 {
+		body += "\n\n" + strings.Join(others, "\n")
+	}
+	if omitted := int(lint.Total) - len(lint.Problems); omitted > 0 {
+		body += fmt.Sprintf("\n\n%d more problems are not shown.", omitted)
+	}
+	_, _, err = ghClient.CreateReview(ctx, prinfo.org, prinfo.repo, int(prinfo.pr), &github.PullRequestReviewRequest{
+		CommitID: github.String(lint.CommitID),
+		Body:     github.String(body),
+		Event:    github.String("COMMENT"),
+		Comments: comments,
+	})
+	return err
+}
+
+// diffPositions returns the positions in the diff of the PR of the lines of
+// the files it changes, keyed by file name and then by line number in the
+// head. Review comments are attached to these positions.
+func diffPositions(ctx context.Context, ghClient GithubClient, prinfo *prInfo) (map[string]map[int]int, error) {
+	positions := map[string]map[int]int{}
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := ghClient.ListFiles(ctx, prinfo.org, prinfo.repo, int(prinfo.pr), opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			positions[f.GetFilename()] = patchPositions(f.GetPatch())
+		}
+		if resp.NextPage == 0 {
+			return positions, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// patchPositions maps the lines of the head found in the unified diff to
+// their position, which is the number of lines below the first hunk header.
+func patchPositions(patch string) map[int]int {
+	positions := map[int]int{}
+	pos, line := 0, 0
+	for i, l := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(l, "@@") {
+			// @@ -a,b +c,d @@, the hunk starts at line c of the head.
+			line = 0
+			if fields := strings.Fields(l); len(fields) > 2 {
+				fmt.Sscanf(fields[2], "+%d", &line)
+			}
+			// hunk headers but the first one count as lines.
+			if i > 0 {
+				pos++
+			}
+			continue
+		}
+		pos++
+		// removed lines and "\ No newline at end of file" are not in the
+		// head.
+		if strings.HasPrefix(l, "+") || strings.HasPrefix(l, " ") {
+			positions[line] = pos
+			line++
+		}
+	}
+	return positions
+}
@@ -0,0 +1,87 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestUpsertComment(t *testing.T) {
+	tests := []struct {
+		name string
+		// comments is the number of comments posted on the PR before the
+		// bot's, more than 100 push it to the second page.
+		comments int
+		// botComment is true if the bot has already commented.
+		botComment bool
+	}{
+		{"first comment", 5, false},
+		{"same page", 5, true},
+		{"later page", 150, true},
+		{"not on any page", 150, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			ghClient := newFakeGithubClient()
+			ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(7, "open", false, sha(1), sha(0)))
+			for i := 0; i < test.comments; i++ {
+				ghClient.CreateComment(ctx, "kubernetes-sigs", "kubebuilder", 7, &github.IssueComment{Body: github.String(fmt.Sprintf("LGTM %d", i))})
+			}
+			var botID int64
+			if test.botComment {
+				c, _, _ := ghClient.CreateComment(ctx, "kubernetes-sigs", "kubebuilder", 7, &github.IssueComment{Body: github.String(commentMarker + "\nold link")})
+				botID = c.GetID()
+			}
+			prinfo, err := parsePullRequestURL("https://github.com/kubernetes-sigs/kubebuilder/pull/7")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the ID of the comment is not known, as if it was lost from
+			// the status.
+			id, err := upsertComment(ctx, ghClient, prinfo, 0, commentMarker+"\nnew link")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.botComment && id != botID {
+				t.Errorf("got comment %d, want the existing comment %d", id, botID)
+			}
+			comments := ghClient.Comments("kubernetes-sigs", "kubebuilder", 7)
+			wantComments := test.comments + 1
+			if len(comments) != wantComments {
+				t.Errorf("got %d comments, want %d", len(comments), wantComments)
+			}
+			if last := comments[len(comments)-1]; last.GetID() != id || last.GetBody() != commentMarker+"\nnew link" {
+				t.Errorf("got comment %d %q, want %d with the new link", last.GetID(), last.GetBody(), id)
+			}
+		})
+	}
+}
+
+func TestPatchPositions(t *testing.T) {
+	patch := strings.Join([]string{
+		"@@ -1,3 +1,4 @@",
+		" package api",
+		"-func Old() {}",
+		"+func New() {}",
+		"+func Two() {}",
+		" var x int",
+		"@@ -10,2 +11,2 @@ var x int",
+		" var y int",
+		"+var z int",
+		`\ No newline at end of file`,
+	}, "\n")
+	// the second hunk header takes position 6.
+	want := map[int]int{1: 1, 2: 3, 3: 4, 4: 5, 11: 7, 12: 8}
+	if got := patchPositions(patch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got positions %v, want %v", got, want)
+	}
+	if got := patchPositions(""); len(got) != 0 {
+		t.Errorf("got positions %v for an empty patch", got)
+	}
+}
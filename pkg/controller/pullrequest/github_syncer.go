@@ -3,52 +3,137 @@ package pullrequest
 import (
 	"context"
 	"log"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
 	"github.com/google/go-github/github"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/controller"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/event"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/source"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// syncNowAnnotation on a PullRequest makes its commitID be synced right away
+// instead of at the next periodic sync. The annotation is removed once the PR
+// is synced.
+const syncNowAnnotation = "godocs.io/sync-now"
+
 // GithubSyncer implements following functionalities:
 //  - Watches newly created PRs in K8s and updates their commitID by calling
 //  Github
 //  - Periodically updates the PRs in K8s with their commitID in Github.
 type GithubSyncer struct {
-	mgr  manager.Manager
-	ctrl controller.Controller
-	// TODO(droot): take make GithubClient interface to make it easy to test the
-	// syncer
-	ghClient *github.Client
-	// TODO(droot): parameterize the sync duration
+	Client       client.Client
+	ctrl         controller.Controller
+	statusWriter StatusWriter
+
+	// ghClients are the clients of the Github hosts whose PRs are synced.
+	ghClients GithubClients
+	// providers resolve the commitID of PRs not hosted by Github.
+	providers Providers
+	// backoffs suspend the syncing of the Github hosts rate limiting us,
+	// they are shared with every other user of the Github clients.
+	backoffs map[string]*backoff
+
 	syncInterval time.Duration
+	syncJitter   float64
+	concurrency  int
+	// closedPRPolicy decides what happens to PRs closed in Github.
+	closedPRPolicy ClosedPRPolicy
+	// enablePRSync makes the periodic syncs update the tracked PRs, which
+	// are otherwise only synced once created.
+	enablePRSync bool
+
+	// listings hold the open PRs of the repos of the Repository objects,
+	// which every periodic sync lists along with their tracked PRs.
+	listings *openPullRequestListings
+	// repositories receives the Repository objects once their repo is
+	// listed, it is nil until a RepositoryController watches it.
+	mu           sync.Mutex
+	repositories chan event.GenericEvent
+	stop         <-chan struct{}
+	startOnce    sync.Once
 }
 
-func NewGithubSyncer(mgr manager.Manager, enablePRSync bool, stop <-chan struct{}) (*GithubSyncer, error) {
-	ghClient := github.NewClient(nil)
+// GithubSyncerOptions are the options for creating a GithubSyncer.
+type GithubSyncerOptions struct {
+	// EnablePRSync enables periodically syncing the PRs with Github.
+	EnablePRSync bool
+	// ClosedPRPolicy decides what happens to PullRequest objects whose PR is
+	// closed or merged in Github.
+	ClosedPRPolicy ClosedPRPolicy
+	// Providers serve the PRs of hosts which are not Github hosts, the PRs
+	// of Github hosts are served with their Github client.
+	Providers Providers
+	// SyncInterval is the time between two periodic syncs, 30 seconds if
+	// zero.
+	SyncInterval time.Duration
+	// SyncJitter is the maximum fraction of SyncInterval randomly added to
+	// it, so that many controllers do not hit Github at the same time.
+	SyncJitter float64
+	// Concurrency is the number of repos synced concurrently, 1 if zero.
+	Concurrency int
+}
+
+func NewGithubSyncer(mgr manager.Manager, ghClients GithubClients, opts GithubSyncerOptions, stop <-chan struct{}) (*GithubSyncer, error) {
+	if err := opts.ClosedPRPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	statusWriter, err := newStatusWriter(mgr)
+	if err != nil {
+		return nil, err
+	}
+	providers := Providers{}
+	for host, ghClient := range ghClients {
+		providers[host] = NewGithubProvider(ghClient)
+	}
+	for host, p := range opts.Providers {
+		providers[host] = p
+	}
 	ctrl, err := controller.New(
 		"github-pullrequest-syncer",
 		mgr,
 		controller.Options{
 			Reconcile: &pullRequestCommitIDReconciler{
-				Client:   mgr.GetClient(),
-				ghClient: ghClient,
+				Client:       mgr.GetClient(),
+				statusWriter: statusWriter,
+				providers:    providers,
 			},
 		})
 	if err != nil {
 		return nil, err
 	}
 	syncer := &GithubSyncer{
-		mgr:          mgr,
-		ctrl:         ctrl,
-		ghClient:     ghClient,
-		syncInterval: 30 * time.Second,
+		Client:         mgr.GetClient(),
+		ctrl:           ctrl,
+		statusWriter:   statusWriter,
+		ghClients:      ghClients,
+		providers:      providers,
+		backoffs:       map[string]*backoff{},
+		syncInterval:   opts.SyncInterval,
+		syncJitter:     opts.SyncJitter,
+		concurrency:    opts.Concurrency,
+		closedPRPolicy: opts.ClosedPRPolicy,
+		enablePRSync:   opts.EnablePRSync,
+		listings:       newOpenPullRequestListings(),
+		stop:           stop,
+	}
+	if syncer.syncInterval <= 0 {
+		syncer.syncInterval = 30 * time.Second
+	}
+	if syncer.concurrency <= 0 {
+		syncer.concurrency = 1
+	}
+	for host, ghClient := range ghClients {
+		syncer.backoffs[host] = backoffOf(host, ghClient)
 	}
 
 	// Watch PullRequests objects
@@ -58,18 +143,38 @@ func NewGithubSyncer(mgr manager.Manager, enablePRSync bool, stop <-chan struct{
 		return nil, err
 	}
 
-	if enablePRSync {
-		go syncer.Start(stop)
+	if opts.EnablePRSync {
+		syncer.start()
 	}
 	return syncer, nil
 }
 
+// start runs the periodic syncs until the stop channel of the syncer is
+// closed, unless they are already running.
+func (gs *GithubSyncer) start() {
+	gs.startOnce.Do(func() { go gs.Start(gs.stop) })
+}
+
+// repositorySource makes the periodic syncs list the open PRs of the repos of
+// the Repository objects, and returns the source of the Repository objects
+// whose repo has been listed.
+func (gs *GithubSyncer) repositorySource() source.Source {
+	gs.mu.Lock()
+	if gs.repositories == nil {
+		gs.repositories = make(chan event.GenericEvent)
+	}
+	repositories := gs.repositories
+	gs.mu.Unlock()
+	gs.start()
+	return &source.Channel{Source: repositories}
+}
+
 // pullRequestCommitIDReconciler reconciles commitID of newly created
 // pullrequests in K8s.
 type pullRequestCommitIDReconciler struct {
-	Client client.Client
-	// TODO(droot): take GithubClient interface to improve testability
-	ghClient *github.Client
+	Client       client.Client
+	statusWriter StatusWriter
+	providers    Providers
 }
 
 func (r *pullRequestCommitIDReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
@@ -89,102 +194,398 @@ func (r *pullRequestCommitIDReconciler) Reconcile(request reconcile.Request) (re
 		return reconcile.Result{}, err
 	}
 
-	if pr.Spec.CommitID != "" {
+	_, syncNow := pr.Annotations[syncNowAnnotation]
+	if pr.Spec.CommitID != "" && !syncNow {
 		// We already know the commit-id for the PR, so no need to update the commitID
 		return reconcile.Result{}, nil
 	}
 
-	// Looks like this is a fresh PR, so lets determine the latest commitID
+	// Looks like this is a fresh PR or a sync has been asked for, so lets
+	// determine the latest commitID
 	prinfo, err := parsePullRequestURL(pr.Spec.URL)
 	if err != nil {
 		log.Printf("error in parsing the request, ignoring this pr %v err %v", request.NamespacedName, err)
 		return reconcile.Result{}, nil
 	}
 
+	provider, err := r.providers.lookup(prinfo)
+	if err != nil {
+		log.Printf("ignoring pr %v: %v", request.NamespacedName, err)
+		return reconcile.Result{}, nil
+	}
+
 	log.Printf("fetching commit id for the PR: %v", prinfo)
-	ghPR, _, err := r.ghClient.PullRequests.Get(context.Background(), prinfo.org, prinfo.repo, int(prinfo.pr))
+	commitID, baseCommitID, err := provider.Commits(ctx, prinfo)
 	if err != nil {
-		log.Printf("error fetching PR details from github: %v", err)
+		log.Printf("error fetching PR details from %s: %v", prinfo.provider, err)
 		return reconcile.Result{}, err
 	}
 
 	// deep copy ? check if it is still required with pkg/cache or client ?
 	prCopy := pr.DeepCopy()
-	prCopy.Spec.CommitID = ghPR.Head.GetSHA()
+	prCopy.Spec.CommitID = commitID
+	prCopy.Spec.BaseCommitID = baseCommitID
+	delete(prCopy.Annotations, syncNowAnnotation)
 	err = r.Client.Update(context.Background(), prCopy)
 	if err != nil {
 		log.Printf("error updating PR github: %v", err)
 		return reconcile.Result{}, err
 	}
+	synced(prCopy, true)
+	if err = r.statusWriter.UpdateStatus(context.Background(), prCopy); err != nil {
+		log.Printf("error updating PR status: %v", err)
+		return reconcile.Result{}, err
+	}
 	return reconcile.Result{}, nil
 }
 
+// lastSyncResolution is how old LastSyncTime gets before a sync which changed
+// nothing is recorded, so that the periodic syncs do not write the status of
+// every PR on every tick.
+const lastSyncResolution = 10 * time.Minute
+
+// synced records in the status of pr that it has just been synced with Github.
+// It returns false if the status does not need to be written, as the sync
+// changed nothing and the previous one is recent enough.
+func synced(pr *v1alpha1.PullRequest, changed bool) bool {
+	if !changed && pr.Status.LastSyncTime != nil && time.Since(pr.Status.LastSyncTime.Time) < lastSyncResolution {
+		return false
+	}
+	now := metav1.Now()
+	pr.Status.LastSyncTime = &now
+	return true
+}
+
 // Start periodically syncs PRs in k8s with their commitID in Github.
 func (gs *GithubSyncer) Start(stop <-chan struct{}) {
-	ticker := time.NewTicker(gs.syncInterval)
 	for {
+		interval := gs.syncInterval
+		if gs.syncJitter > 0 {
+			interval = wait.Jitter(interval, gs.syncJitter)
+		}
 		select {
 		case <-stop:
 			/* we got signalled */
 			return
-		case <-ticker.C:
+		case <-time.After(interval):
 			gs.syncPullRequests()
 		}
 	}
 }
 
 // syncPullRequests performs the following:
-//  - fetches all the PRs registered in K8s
+//  - fetches all the PRs registered in K8s, and the Repository objects if a
+//  RepositoryController watches the syncer
 //  - Organize them by orgs/repo so that batch calls can be made
-//  - Fetches details of PRs from Github and updates the commitID of the PRs in
-//  k8s if required.
-// TODO(droot): delete the PRs from K8s if closed in Github.
+//  - Fetches details of PRs from Github, one by one for repos with few tracked
+//  PRs and by paging through the open PRs otherwise, and updates the commitID
+//  of the PRs in k8s if required. The open PRs of the repos of Repository
+//  objects are all listed, and the Repository objects are then reconciled.
+//  - Applies the closed PR policy to the PRs which are closed in Github.
+//  - Updates the commitID of the PRs hosted by other providers one by one.
 func (gs *GithubSyncer) syncPullRequests() {
 	prList := &v1alpha1.PullRequestList{}
-	// get pull requests in all namespaces
-	err := gs.mgr.GetClient().List(context.Background(), &client.ListOptions{Namespace: ""}, prList)
+	if gs.enablePRSync {
+		// get pull requests in all namespaces
+		err := gs.Client.List(context.Background(), &client.ListOptions{Namespace: ""}, prList)
+		if err != nil {
+			log.Printf("error fetching all the PRs from k8s: %v", err)
+			return
+		}
+	}
+	repoList, err := gs.listRepositories()
 	if err != nil {
-		log.Printf("error fetching all the PRs from k8s: %v", err)
+		log.Printf("error fetching all the repositories from k8s: %v", err)
 		return
 	}
 
-	// TODO(droot): simplify the following loop
-	orgs := pullRequestByRepoAndOrg(prList)
-	for org, repos := range orgs {
-		for repo, prs := range repos {
-			ghPRs, _, err := gs.ghClient.PullRequests.List(context.Background(), org, repo, nil)
-			if err != nil {
-				log.Printf("cannont get PR list from GH: %v", err)
-				continue
+	// repos are synced by up to concurrency workers.
+	sem := make(chan struct{}, gs.concurrency)
+	var wg sync.WaitGroup
+	listed := map[string]bool{}
+	for host, ghClient := range gs.ghClients {
+		bo := gs.backoffs[host]
+		orgs := pullRequestByRepoAndOrg(prList, host)
+		repositories := repositoriesByRepoAndOrg(repoList, host)
+		for org, repos := range repositories {
+			if orgs[org] == nil {
+				orgs[org] = map[string]map[int64]*v1alpha1.PullRequest{}
 			}
-			for _, ghPR := range ghPRs {
-				ghPRNum := int64(ghPR.GetNumber())
-				if pr, found := prs[ghPRNum]; found {
-					commitID := pr.Spec.CommitID
-					// github PR found in our cluster
-					if ghPR.Head.GetSHA() != commitID {
-						// PR has been updated in GitHub
-						log.Printf("PR Updated: org: %s repo:%s pr: %d commitID: %s ghCommitID: %s \n", org, repo, ghPRNum, commitID, ghPR.Head.GetSHA())
-						pr.Spec.CommitID = ghPR.Head.GetSHA()
-						err = gs.mgr.GetClient().Update(context.Background(), pr)
-						if err != nil {
-							log.Printf("error updating PR github: %v", err)
-							continue
-						}
-					} else {
-						log.Printf("PR is same: org: %s repo:%s pr: %d commitID: %s ghCommitID: %s \n", org, repo, ghPRNum, commitID, ghPR.Head.GetSHA())
-					}
-				} else {
-					log.Printf("PR not found: org: %s repo:%s pr: %d \n", org, repo, ghPRNum)
+			for repo := range repos {
+				if orgs[org][repo] == nil {
+					orgs[org][repo] = map[int64]*v1alpha1.PullRequest{}
 				}
+				listed[listingKey(host, org, repo)] = true
+			}
+		}
+		for org, repos := range orgs {
+			for repo, prs := range repos {
+				if bo.active() {
+					continue
+				}
+				sem <- struct{}{}
+				wg.Add(1)
+				go func(ghClient GithubClient, host, org, repo string, prs map[int64]*v1alpha1.PullRequest, repositories []*v1alpha1.Repository) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					gs.syncRepo(ghClient, bo, host, org, repo, prs, repositories)
+				}(ghClient, host, org, repo, prs, repositories[org][repo])
+			}
+		}
+	}
+	wg.Wait()
+	if repoList != nil {
+		gs.listings.retain(listed)
+	}
+
+	for i := range prList.Items {
+		gs.syncProviderPullRequest(&prList.Items[i])
+	}
+}
+
+// listRepositories returns the Repository objects of all the namespaces, nil
+// if no RepositoryController watches the syncer.
+func (gs *GithubSyncer) listRepositories() (*v1alpha1.RepositoryList, error) {
+	gs.mu.Lock()
+	watched := gs.repositories != nil
+	gs.mu.Unlock()
+	if !watched {
+		return nil, nil
+	}
+	repoList := &v1alpha1.RepositoryList{}
+	if err := gs.Client.List(context.Background(), &client.ListOptions{Namespace: ""}, repoList); err != nil {
+		return nil, err
+	}
+	return repoList, nil
+}
+
+// enqueueRepositories makes the RepositoryController reconcile the Repository
+// objects.
+func (gs *GithubSyncer) enqueueRepositories(repositories []*v1alpha1.Repository) {
+	for _, repo := range repositories {
+		gs.repositories <- event.GenericEvent{Meta: repo, Object: repo}
+	}
+}
+
+// maxDirectFetches is the number of tracked PRs of a repo up to which they are
+// fetched one by one instead of listing all the open PRs of the repo.
+const maxDirectFetches = 3
+
+// syncRepo syncs the tracked PRs of a repo of a Github host. The open PRs of
+// the repos of Repository objects are all listed, the listing is kept for the
+// RepositoryController which then reconciles the Repository objects. It stops
+// as soon as the host rate limits us.
+func (gs *GithubSyncer) syncRepo(ghClient GithubClient, bo *backoff, host, org, repo string, prs map[int64]*v1alpha1.PullRequest, repositories []*v1alpha1.Repository) {
+	if len(repositories) == 0 && len(prs) <= maxDirectFetches {
+		gs.fetchPullRequests(ghClient, bo, org, repo, prs)
+		return
+	}
+	tracked := prs
+	if len(repositories) > 0 {
+		tracked = nil
+	}
+	ghPRs, err := listOpenPullRequests(ghClient, org, repo, tracked)
+	if err != nil {
+		log.Printf("cannont get PR list from GH: %v", err)
+		return
+	}
+	if len(repositories) > 0 {
+		gs.listings.set(host, org, repo, ghPRs)
+		gs.enqueueRepositories(repositories)
+	}
+	for prNum, pr := range prs {
+		if ghPR, found := ghPRs[prNum]; found {
+			gs.syncOpenPullRequest(org, repo, pr, ghPR)
+		} else if !bo.active() {
+			gs.syncClosedPullRequest(ghClient, org, repo, prNum, pr)
+		}
+	}
+}
+
+// fetchPullRequests fetches the given PRs of a repo one by one and syncs them.
+func (gs *GithubSyncer) fetchPullRequests(ghClient GithubClient, bo *backoff, org, repo string, prs map[int64]*v1alpha1.PullRequest) {
+	for prNum, pr := range prs {
+		if bo.active() {
+			return
+		}
+		ghPR, _, err := ghClient.GetPullRequest(context.Background(), org, repo, int(prNum))
+		if err != nil {
+			log.Printf("error fetching PR details from github: %v", err)
+			continue
+		}
+		if ghPR.GetState() == "closed" {
+			gs.applyClosedPRPolicy(pr, closedState(ghPR.GetMerged()))
+			continue
+		}
+		gs.syncOpenPullRequest(org, repo, pr, ghPR)
+	}
+}
+
+// listOpenPullRequests pages through the open PRs of a repo until all the
+// tracked PRs are found, or through all of them if no PR is tracked. It returns
+// the open PRs found by their number.
+func listOpenPullRequests(ghClient GithubClient, org, repo string, tracked map[int64]*v1alpha1.PullRequest) (map[int64]*github.PullRequest, error) {
+	open := map[int64]*github.PullRequest{}
+	found := 0
+	opt := &github.PullRequestListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		ghPRs, resp, err := ghClient.ListPullRequests(context.Background(), org, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, ghPR := range ghPRs {
+			ghPRNum := int64(ghPR.GetNumber())
+			open[ghPRNum] = ghPR
+			if _, isTracked := tracked[ghPRNum]; isTracked {
+				found++
 			}
-			// TODO(droot): if a PR is not found in GH, it is closed, so we need to probably
-			// delete that PR from k8s cluster
 		}
+		if (len(tracked) > 0 && found == len(tracked)) || resp.NextPage == 0 {
+			return open, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// openPullRequestListings holds the last listing of the open PRs of the repos
+// of the Repository objects, by number.
+type openPullRequestListings struct {
+	mu  sync.Mutex
+	prs map[string]map[int64]*github.PullRequest
+}
+
+func newOpenPullRequestListings() *openPullRequestListings {
+	return &openPullRequestListings{prs: map[string]map[int64]*github.PullRequest{}}
+}
+
+// listingKey returns the key of the listing of a repo.
+func listingKey(host, org, repo string) string {
+	return path.Join(host, org, repo)
+}
+
+// get returns the last listing of the open PRs of the repo, nil if it was
+// never listed. The listing is shared and must not be modified.
+func (l *openPullRequestListings) get(host, org, repo string) map[int64]*github.PullRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.prs[listingKey(host, org, repo)]
+}
+
+func (l *openPullRequestListings) set(host, org, repo string, prs map[int64]*github.PullRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prs[listingKey(host, org, repo)] = prs
+}
+
+// retain drops the listings of the repos which are no longer listed.
+func (l *openPullRequestListings) retain(listed map[string]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.prs {
+		if !listed[key] {
+			delete(l.prs, key)
+		}
+	}
+}
+
+// syncOpenPullRequest updates the commitID of a PR found open in Github.
+func (gs *GithubSyncer) syncOpenPullRequest(org, repo string, pr *v1alpha1.PullRequest, ghPR *github.PullRequest) {
+	ghPRNum := ghPR.GetNumber()
+	if prCopy := pr.DeepCopy(); reopen(prCopy) {
+		synced(prCopy, true)
+		if err := gs.statusWriter.UpdateStatus(context.Background(), prCopy); err != nil {
+			log.Printf("error updating PR status: %v", err)
+		}
+		return
+	}
+	commitID := pr.Spec.CommitID
+	// github PR found in our cluster
+	updated := setCommitID(pr, ghPR.GetHead().GetSHA(), ghPR.GetBase().GetSHA())
+	if updated {
+		// PR has been updated in GitHub
+		if err := gs.Client.Update(context.Background(), pr); err != nil {
+			log.Printf("error updating PR github: %v", err)
+			return
+		}
+	} else {
+		log.Printf("PR is same: org: %s repo:%s pr: %d commitID: %s ghCommitID: %s \n", org, repo, ghPRNum, commitID, ghPR.Head.GetSHA())
+	}
+	if !synced(pr, updated) {
+		return
+	}
+	if err := gs.statusWriter.UpdateStatus(context.Background(), pr); err != nil {
+		log.Printf("error updating PR status: %v", err)
 	}
 }
 
-// this function organizes all the PRs present in K8s by repo names and orgs, so that
+// syncProviderPullRequest updates the commitID of a PR which is not hosted by
+// Github. Closed PRs are only detected for Github.
+func (gs *GithubSyncer) syncProviderPullRequest(pr *v1alpha1.PullRequest) {
+	ctx := context.Background()
+
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil || prinfo.provider == ProviderGithub {
+		// Github PRs are synced by syncGithubPullRequests.
+		return
+	}
+	provider, err := gs.providers.lookup(prinfo)
+	if err != nil {
+		log.Printf("ignoring pr %s/%s: %v", pr.Namespace, pr.Name, err)
+		return
+	}
+	commitID, baseCommitID, err := provider.Commits(ctx, prinfo)
+	if err != nil {
+		log.Printf("error fetching PR details from %s: %v", prinfo.provider, err)
+		return
+	}
+	updated := setCommitID(pr, commitID, baseCommitID)
+	if updated {
+		if err = gs.Client.Update(ctx, pr); err != nil {
+			log.Printf("error updating PR %s/%s: %v", pr.Namespace, pr.Name, err)
+			return
+		}
+	}
+	if !synced(pr, updated) {
+		return
+	}
+	if err = gs.statusWriter.UpdateStatus(ctx, pr); err != nil {
+		log.Printf("error updating PR status: %v", err)
+	}
+}
+
+// syncClosedPullRequest handles a PR which is not in the list of open PRs
+// returned by Github. If Github confirms the PR is closed or merged, the closed
+// PR policy is applied to it.
+func (gs *GithubSyncer) syncClosedPullRequest(ghClient GithubClient, org, repo string, prNum int64, pr *v1alpha1.PullRequest) {
+	ctx := context.Background()
+
+	state := pr.Status.State
+	if !isClosed(pr) {
+		ghPR, _, err := ghClient.GetPullRequest(ctx, org, repo, int(prNum))
+		if err != nil {
+			log.Printf("error fetching PR details from github: %v", err)
+			return
+		}
+		if ghPR.GetState() != "closed" {
+			return
+		}
+		state = closedState(ghPR.GetMerged())
+	}
+	gs.applyClosedPRPolicy(pr, state)
+}
+
+// applyClosedPRPolicy applies the closed PR policy to a PR which is closed or
+// merged in Github.
+func (gs *GithubSyncer) applyClosedPRPolicy(pr *v1alpha1.PullRequest, state string) {
+	if err := gs.closedPRPolicy.apply(context.Background(), gs.Client, gs.statusWriter, pr, state); err != nil {
+		log.Printf("error applying closed PR policy to %s/%s: %v", pr.Namespace, pr.Name, err)
+	}
+}
+
+// this function organizes the PRs of a Github host present in K8s by repo names and orgs, so that
 // we can make batch calls to Github. It returns map organized as follows:
 //   {
 //		"org-1": {
@@ -204,10 +605,18 @@ func (gs *GithubSyncer) syncPullRequests() {
 //	}
 // TODO(droot): explore if indexing available under pkg/cache can help us achive
 // this organization.
-func pullRequestByRepoAndOrg(prs *v1alpha1.PullRequestList) map[string]map[string]map[int64]*v1alpha1.PullRequest {
+func pullRequestByRepoAndOrg(prs *v1alpha1.PullRequestList, host string) map[string]map[string]map[int64]*v1alpha1.PullRequest {
 	orgs := map[string]map[string]map[int64]*v1alpha1.PullRequest{}
-	for _, pr := range prs.Items {
-		prinfo, _ := parsePullRequestURL(pr.Spec.URL)
+	for i := range prs.Items {
+		pr := &prs.Items[i]
+		prinfo, err := parsePullRequestURL(pr.Spec.URL)
+		if err != nil {
+			log.Printf("error in parsing the request, ignoring this pr %s/%s err %v", pr.Namespace, pr.Name, err)
+			continue
+		}
+		if prinfo.provider != ProviderGithub || prinfo.host != host {
+			continue
+		}
 		_, orgFound := orgs[prinfo.org]
 		if !orgFound {
 			orgs[prinfo.org] = map[string]map[int64]*v1alpha1.PullRequest{}
@@ -216,7 +625,27 @@ func pullRequestByRepoAndOrg(prs *v1alpha1.PullRequestList) map[string]map[strin
 		if !repoFound {
 			orgs[prinfo.org][prinfo.repo] = map[int64]*v1alpha1.PullRequest{}
 		}
-		orgs[prinfo.org][prinfo.repo][prinfo.pr] = &pr
+		orgs[prinfo.org][prinfo.repo][prinfo.pr] = pr
+	}
+	return orgs
+}
+
+// repositoriesByRepoAndOrg organizes the Repository objects of the Github host
+// by org and repo.
+func repositoriesByRepoAndOrg(repos *v1alpha1.RepositoryList, host string) map[string]map[string][]*v1alpha1.Repository {
+	orgs := map[string]map[string][]*v1alpha1.Repository{}
+	if repos == nil {
+		return orgs
+	}
+	for i := range repos.Items {
+		repo := &repos.Items[i]
+		if repositoryHost(repo) != host {
+			continue
+		}
+		if orgs[repo.Spec.Org] == nil {
+			orgs[repo.Spec.Org] = map[string][]*v1alpha1.Repository{}
+		}
+		orgs[repo.Spec.Org][repo.Spec.Repo] = append(orgs[repo.Spec.Org][repo.Spec.Repo], repo)
 	}
 	return orgs
 }
@@ -0,0 +1,199 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const syncerTestNamespace = "docs"
+
+// sha returns a fake commit ID unique to n.
+func sha(n int) string {
+	return fmt.Sprintf("%040x", n)
+}
+
+// ghPullRequest returns the Github PR number of kubernetes-sigs/kubebuilder
+// with the given state and commits.
+func ghPullRequest(number int, state string, merged bool, head, base string) *github.PullRequest {
+	return &github.PullRequest{
+		Number: github.Int(number),
+		State:  github.String(state),
+		Merged: github.Bool(merged),
+		Head:   &github.PullRequestBranch{SHA: github.String(head)},
+		Base:   &github.PullRequestBranch{SHA: github.String(base)},
+	}
+}
+
+// trackedPullRequest returns a PullRequest object tracking the PR number of
+// kubernetes-sigs/kubebuilder at the given commit.
+func trackedPullRequest(number int, commitID string) *v1alpha1.PullRequest {
+	return &v1alpha1.PullRequest{
+		ObjectMeta: metaFor(syncerTestNamespace, fmt.Sprintf("kubebuilder-pr-%d", number)),
+		Spec: v1alpha1.PullRequestSpec{
+			URL:          fmt.Sprintf("https://github.com/kubernetes-sigs/kubebuilder/pull/%d", number),
+			CommitID:     commitID,
+			BaseCommitID: sha(0),
+		},
+	}
+}
+
+func TestPullRequestCommitIDReconcile(t *testing.T) {
+	tests := []struct {
+		name         string
+		commitID     string
+		annotations  map[string]string
+		wantCommitID string
+		wantSynced   bool
+	}{
+		{"fresh PR", "", nil, sha(2), true},
+		{"known commit", sha(1), nil, sha(1), false},
+		{"sync now", sha(1), map[string]string{syncNowAnnotation: "true"}, sha(2), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pr := trackedPullRequest(7, test.commitID)
+			pr.Spec.BaseCommitID = ""
+			pr.Annotations = test.annotations
+			c := newFakeClient(pr)
+			ghClient := newFakeGithubClient()
+			ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(7, "open", false, sha(2), sha(3)))
+			r := &pullRequestCommitIDReconciler{
+				Client:       c,
+				statusWriter: c,
+				providers:    Providers{"github.com": NewGithubProvider(ghClient)},
+			}
+
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}}
+			if _, err := r.Reconcile(request); err != nil {
+				t.Fatal(err)
+			}
+			got := c.pullRequest(pr.Namespace, pr.Name)
+			if got.Spec.CommitID != test.wantCommitID {
+				t.Errorf("got commitID %s, want %s", got.Spec.CommitID, test.wantCommitID)
+			}
+			if synced := got.Status.LastSyncTime != nil; synced != test.wantSynced {
+				t.Errorf("got synced %v, want %v", synced, test.wantSynced)
+			}
+			if !test.wantSynced {
+				return
+			}
+			if got.Spec.BaseCommitID != sha(3) {
+				t.Errorf("got base commitID %s, want %s", got.Spec.BaseCommitID, sha(3))
+			}
+			if _, found := got.Annotations[syncNowAnnotation]; found {
+				t.Errorf("annotation %s was not removed", syncNowAnnotation)
+			}
+		})
+	}
+}
+
+func TestPullRequestCommitIDReconcileNotFound(t *testing.T) {
+	c := newFakeClient(trackedPullRequest(7, ""))
+	r := &pullRequestCommitIDReconciler{
+		Client:       c,
+		statusWriter: c,
+		providers:    Providers{"github.com": NewGithubProvider(newFakeGithubClient())},
+	}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: syncerTestNamespace, Name: "kubebuilder-pr-7"}}
+	if _, err := r.Reconcile(request); err == nil {
+		t.Error("got no error for a PR missing in Github")
+	}
+}
+
+func TestSyncPullRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		// tracked is the number of tracked PRs, more than maxDirectFetches
+		// makes the syncer page through the open PRs.
+		tracked int
+		// open is the number of open PRs in Github, the tracked ones
+		// included.
+		open int
+	}{
+		{"direct fetches", maxDirectFetches, maxDirectFetches},
+		{"listing", 5, 5},
+		{"listing pages", 5, 250},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ghClient := newFakeGithubClient()
+			c := newFakeClient()
+			// the tracked PRs are the oldest ones, which are listed last.
+			for n := 1; n <= test.open; n++ {
+				ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(n, "open", false, sha(n+1000), sha(0)))
+				if n <= test.tracked {
+					if err := c.Create(context.Background(), trackedPullRequest(n, sha(n))); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			// the first PR has been merged.
+			ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(1, "closed", true, sha(1), sha(0)))
+
+			gs := &GithubSyncer{
+				Client:         c,
+				statusWriter:   c,
+				ghClients:      GithubClients{"github.com": ghClient},
+				backoffs:       map[string]*backoff{"github.com": {host: "github.com"}},
+				concurrency:    1,
+				closedPRPolicy: ClosedPRPolicy{Action: ClosedPRDelete},
+				enablePRSync:   true,
+			}
+			gs.syncPullRequests()
+
+			if c.pullRequest(syncerTestNamespace, "kubebuilder-pr-1") != nil {
+				t.Error("PullRequest of the merged PR was not deleted")
+			}
+			for n := 2; n <= test.tracked; n++ {
+				pr := c.pullRequest(syncerTestNamespace, fmt.Sprintf("kubebuilder-pr-%d", n))
+				if pr == nil {
+					t.Fatalf("PullRequest of open PR %d was deleted", n)
+				}
+				if pr.Spec.CommitID != sha(n+1000) {
+					t.Errorf("PR %d: got commitID %s, want %s", n, pr.Spec.CommitID, sha(n+1000))
+				}
+				if pr.Status.LastSyncTime == nil {
+					t.Errorf("PR %d: sync was not recorded in the status", n)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncPullRequestsStatusWrites(t *testing.T) {
+	ghClient := newFakeGithubClient()
+	c := newFakeClient(trackedPullRequest(1, sha(1)), trackedPullRequest(2, sha(2)))
+	ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(1, "open", false, sha(1), sha(0)))
+	ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(2, "open", false, sha(2), sha(0)))
+	gs := &GithubSyncer{
+		Client:         c,
+		statusWriter:   c,
+		ghClients:      GithubClients{"github.com": ghClient},
+		backoffs:       map[string]*backoff{"github.com": {host: "github.com"}},
+		concurrency:    1,
+		closedPRPolicy: ClosedPRPolicy{Action: ClosedPRDelete},
+		enablePRSync:   true,
+	}
+
+	// the first sync is recorded, the next ones are not until
+	// lastSyncResolution has passed or the PR has changed.
+	gs.syncPullRequests()
+	if c.statusUpdates != 2 {
+		t.Fatalf("got %d status updates on the first sync, want 2", c.statusUpdates)
+	}
+	gs.syncPullRequests()
+	if c.statusUpdates != 2 {
+		t.Fatalf("got %d status updates on a sync which changed nothing, want none", c.statusUpdates-2)
+	}
+	ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(2, "open", false, sha(3), sha(0)))
+	gs.syncPullRequests()
+	if c.statusUpdates != 3 {
+		t.Fatalf("got %d status updates on a sync which updated a PR, want 1", c.statusUpdates-2)
+	}
+}
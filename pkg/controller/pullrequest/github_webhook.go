@@ -0,0 +1,271 @@
+package pullrequest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GithubWebhook receives pull_request events from Github and keeps the
+// PullRequest objects in K8s in sync with them:
+//   - opened/reopened PRs get a new PullRequest object
+//   - synchronize events update the commitID of the PullRequest object
+//   - labeled/unlabeled events update the labels in the PullRequest status,
+//     which reconciles the compatibility of its API changes again
+//   - closed PRs have the closed PR policy applied to their PullRequest object
+type GithubWebhook struct {
+	Client       client.Client
+	statusWriter StatusWriter
+
+	addr string
+	// secret is the key Github uses to sign the webhook payloads.
+	secret []byte
+	// namespace is where PullRequest objects are created for new PRs.
+	namespace string
+	// closedPRPolicy decides what happens to PRs closed in Github.
+	closedPRPolicy ClosedPRPolicy
+}
+
+// NewGithubWebhook creates a webhook receiver listening on addr and registers
+// it with the manager, so that it is started and stopped along with the
+// controllers.
+func NewGithubWebhook(mgr manager.Manager, addr string, secret []byte, namespace string, closedPRPolicy ClosedPRPolicy) (*GithubWebhook, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("webhook secret must not be empty")
+	}
+	if err := closedPRPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	statusWriter, err := newStatusWriter(mgr)
+	if err != nil {
+		return nil, err
+	}
+	wh := &GithubWebhook{
+		Client:         mgr.GetClient(),
+		statusWriter:   statusWriter,
+		addr:           addr,
+		secret:         secret,
+		namespace:      namespace,
+		closedPRPolicy: closedPRPolicy,
+	}
+	if err := mgr.Add(wh); err != nil {
+		return nil, err
+	}
+	return wh, nil
+}
+
+// Start runs the webhook HTTP server until stop is closed.
+func (wh *GithubWebhook) Start(stop <-chan struct{}) error {
+	srv := &http.Server{Addr: wh.addr, Handler: wh}
+	go func() {
+		<-stop
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("starting github webhook receiver on %s", wh.addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP validates the signature of the incoming webhook and dispatches
+// the event to its handler.
+func (wh *GithubWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := github.ValidatePayload(r, wh.secret)
+	if err != nil {
+		log.Printf("rejecting webhook delivery %s: %v", github.DeliveryID(r), err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		log.Printf("error parsing webhook delivery %s: %v", github.DeliveryID(r), err)
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	switch event := event.(type) {
+	case *github.PullRequestEvent:
+		labels, err := payloadLabels(payload)
+		if err != nil {
+			log.Printf("error parsing the labels of webhook delivery %s: %v", github.DeliveryID(r), err)
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		if err := wh.handlePullRequestEvent(r.Context(), event, labels); err != nil {
+			log.Printf("error handling webhook delivery %s: %v", github.DeliveryID(r), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		// ping and any other events we are subscribed to are acknowledged and
+		// ignored.
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// payloadLabels returns the names of the labels of the PR of a pull_request
+// event payload, which go-github does not decode.
+func payloadLabels(payload []byte) ([]string, error) {
+	var event struct {
+		PullRequest struct {
+			Labels []struct {
+				Name string `json:"name"`
+			} `json:"labels"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, l := range event.PullRequest.Labels {
+		names = append(names, l.Name)
+	}
+	return names, nil
+}
+
+// handlePullRequestEvent applies a pull_request event, whose PR carries the
+// given labels, to the PullRequest objects in K8s.
+func (wh *GithubWebhook) handlePullRequestEvent(ctx context.Context, event *github.PullRequestEvent, labels []string) error {
+	ghPR := event.GetPullRequest()
+	prinfo, err := parsePullRequestURL(ghPR.GetHTMLURL())
+	if err != nil {
+		// not a PR we know how to serve docs for.
+		log.Printf("ignoring pull_request event for %q: %v", ghPR.GetHTMLURL(), err)
+		return nil
+	}
+
+	pr, err := findPullRequest(ctx, wh.Client, wh.namespace, prinfo)
+	if err != nil {
+		return err
+	}
+
+	switch event.GetAction() {
+	case "opened", "reopened":
+		if pr != nil {
+			prCopy := pr.DeepCopy()
+			if reopen(prCopy) {
+				if err := wh.statusWriter.UpdateStatus(ctx, prCopy); err != nil {
+					return err
+				}
+			}
+			if setCommitID(prCopy, ghPR.GetHead().GetSHA(), ghPR.GetBase().GetSHA()) {
+				if err := wh.Client.Update(ctx, prCopy); err != nil {
+					return err
+				}
+			}
+			return wh.recordLabels(ctx, prCopy, ghPR.GetHead().GetSHA(), labels)
+		}
+		pr = &v1alpha1.PullRequest{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: v1alpha1.SchemeGroupVersion.String(),
+				Kind:       "PullRequest",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      prinfo.name(),
+				Namespace: wh.namespace,
+			},
+			Spec: v1alpha1.PullRequestSpec{
+				URL:          ghPR.GetHTMLURL(),
+				CommitID:     ghPR.GetHead().GetSHA(),
+				BaseCommitID: ghPR.GetBase().GetSHA(),
+			},
+		}
+		log.Printf("creating PullRequest %s/%s for %s", pr.Namespace, pr.Name, pr.Spec.URL)
+		if err := wh.Client.Create(ctx, pr); err != nil {
+			if errors.IsAlreadyExists(err) {
+				return nil
+			}
+			return err
+		}
+		return wh.recordLabels(ctx, pr, ghPR.GetHead().GetSHA(), labels)
+	case "synchronize":
+		if pr == nil {
+			log.Printf("PR not found: org: %s repo:%s pr: %d \n", prinfo.org, prinfo.repo, prinfo.pr)
+			return nil
+		}
+		prCopy := pr.DeepCopy()
+		if setCommitID(prCopy, ghPR.GetHead().GetSHA(), ghPR.GetBase().GetSHA()) {
+			if err := wh.Client.Update(ctx, prCopy); err != nil {
+				return err
+			}
+		}
+		return wh.recordLabels(ctx, prCopy, ghPR.GetHead().GetSHA(), labels)
+	case "labeled", "unlabeled":
+		if pr == nil {
+			return nil
+		}
+		return wh.recordLabels(ctx, pr.DeepCopy(), ghPR.GetHead().GetSHA(), labels)
+	case "closed":
+		if pr == nil {
+			return nil
+		}
+		return wh.closedPRPolicy.apply(ctx, wh.Client, wh.statusWriter, pr, closedState(ghPR.GetMerged()))
+	}
+	return nil
+}
+
+// recordLabels records in the status of the PullRequest the labels its PR
+// carries at the given commit, unless they are already recorded.
+func (wh *GithubWebhook) recordLabels(ctx context.Context, pr *v1alpha1.PullRequest, commitID string, labels []string) error {
+	want := &v1alpha1.PullRequestLabels{CommitID: commitID, Names: labels}
+	if reflect.DeepEqual(pr.Status.Labels, want) {
+		return nil
+	}
+	pr.Status.Labels = want
+	return wh.statusWriter.UpdateStatus(ctx, pr)
+}
+
+// setCommitID points the PullRequest object at the given commit and at the
+// given commit of its base branch. It returns false if the object already
+// points at them. The base commit is only updated along with the commit unless
+// it is not known yet, so that the PR is not rebuilt whenever its base branch
+// moves.
+func setCommitID(pr *v1alpha1.PullRequest, commitID, baseCommitID string) bool {
+	if commitID == "" {
+		return false
+	}
+	if pr.Spec.CommitID == commitID && (pr.Spec.BaseCommitID != "" || baseCommitID == "") {
+		return false
+	}
+	log.Printf("PR Updated: %s/%s commitID: %s ghCommitID: %s \n", pr.Namespace, pr.Name, pr.Spec.CommitID, commitID)
+	pr.Spec.CommitID = commitID
+	pr.Spec.BaseCommitID = baseCommitID
+	return true
+}
+
+// findPullRequest returns the PullRequest object in the given namespace which
+// tracks the same PR as prinfo, or nil if there is none. PullRequest objects
+// can be named arbitrarily when created by hand, so they are matched by URL.
+func findPullRequest(ctx context.Context, c client.Client, namespace string, prinfo *prInfo) (*v1alpha1.PullRequest, error) {
+	prList := &v1alpha1.PullRequestList{}
+	if err := c.List(ctx, &client.ListOptions{Namespace: namespace}, prList); err != nil {
+		return nil, err
+	}
+	for i := range prList.Items {
+		info, err := parsePullRequestURL(prList.Items[i].Spec.URL)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(info.host, prinfo.host) &&
+			strings.EqualFold(info.org, prinfo.org) &&
+			strings.EqualFold(info.repo, prinfo.repo) &&
+			info.pr == prinfo.pr {
+			return &prList.Items[i], nil
+		}
+	}
+	return nil, nil
+}
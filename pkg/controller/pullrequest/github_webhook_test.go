@@ -0,0 +1,191 @@
+package pullrequest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+)
+
+const (
+	webhookTestSecret    = "s3cr3t"
+	webhookTestNamespace = "docs"
+	webhookTestPRName    = "kubernetes-sigs-controller-runtime-pr-15"
+)
+
+// deliver sends the recorded payload of the testdata file to the webhook as
+// the given event, signed with secret, and returns the response code.
+func deliver(t *testing.T, wh *GithubWebhook, event, file, secret string) int {
+	payload, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(payload)
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Github-Event", event)
+	req.Header.Set("X-Github-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	rec := httptest.NewRecorder()
+	wh.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func newTestWebhook(c *fakeClient, policy ClosedPRPolicy) *GithubWebhook {
+	return &GithubWebhook{
+		Client:         c,
+		statusWriter:   c,
+		secret:         []byte(webhookTestSecret),
+		namespace:      webhookTestNamespace,
+		closedPRPolicy: policy,
+	}
+}
+
+func TestGithubWebhookOpened(t *testing.T) {
+	c := newFakeClient()
+	wh := newTestWebhook(c, ClosedPRPolicy{Action: ClosedPRDelete})
+
+	if code := deliver(t, wh, "pull_request", "pull_request_opened.json", webhookTestSecret); code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	pr := c.pullRequest(webhookTestNamespace, webhookTestPRName)
+	if pr == nil {
+		t.Fatal("PullRequest was not created")
+	}
+	want := v1alpha1.PullRequestSpec{
+		URL:          "https://github.com/kubernetes-sigs/controller-runtime/pull/15",
+		CommitID:     "ec7d3a1f0c2fa19a3b95f0e4a6fc5a3c1dba8f29",
+		BaseCommitID: "9049f1265b7d61be4a8904a9a27f06d2005e8e2f",
+	}
+	if pr.Spec.URL != want.URL || pr.Spec.CommitID != want.CommitID || pr.Spec.BaseCommitID != want.BaseCommitID {
+		t.Errorf("got spec %+v, want %+v", pr.Spec, want)
+	}
+
+	// redeliveries leave the object alone.
+	if code := deliver(t, wh, "pull_request", "pull_request_opened.json", webhookTestSecret); code != http.StatusOK {
+		t.Fatalf("got status %d on redelivery, want %d", code, http.StatusOK)
+	}
+}
+
+func TestGithubWebhookSynchronize(t *testing.T) {
+	c := newFakeClient(&v1alpha1.PullRequest{
+		ObjectMeta: metaFor(webhookTestNamespace, "hand-written"),
+		Spec: v1alpha1.PullRequestSpec{
+			URL:          "https://github.com/kubernetes-sigs/controller-runtime/pull/15",
+			CommitID:     "ec7d3a1f0c2fa19a3b95f0e4a6fc5a3c1dba8f29",
+			BaseCommitID: "9049f1265b7d61be4a8904a9a27f06d2005e8e2f",
+		},
+	})
+	wh := newTestWebhook(c, ClosedPRPolicy{Action: ClosedPRDelete})
+
+	if code := deliver(t, wh, "pull_request", "pull_request_synchronize.json", webhookTestSecret); code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	// objects created by hand are found by their URL.
+	pr := c.pullRequest(webhookTestNamespace, "hand-written")
+	if got, want := pr.Spec.CommitID, "5e4c1a9fb0df7c0a2f1d4e5f6a7b8c9d0e1f2a3b"; got != want {
+		t.Errorf("got commitID %s, want %s", got, want)
+	}
+	if c.pullRequest(webhookTestNamespace, webhookTestPRName) != nil {
+		t.Error("synchronize created a second PullRequest")
+	}
+}
+
+func TestGithubWebhookLabels(t *testing.T) {
+	c := newFakeClient()
+	wh := newTestWebhook(c, ClosedPRPolicy{Action: ClosedPRDelete})
+	deliver(t, wh, "pull_request", "pull_request_opened.json", webhookTestSecret)
+	deliver(t, wh, "pull_request", "pull_request_synchronize.json", webhookTestSecret)
+
+	head := "5e4c1a9fb0df7c0a2f1d4e5f6a7b8c9d0e1f2a3b"
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"pull_request_labeled.json", []string{"breaking-change"}},
+		{"pull_request_unlabeled.json", nil},
+	}
+	for _, test := range tests {
+		if code := deliver(t, wh, "pull_request", test.file, webhookTestSecret); code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d", test.file, code, http.StatusOK)
+		}
+		pr := c.pullRequest(webhookTestNamespace, webhookTestPRName)
+		want := &v1alpha1.PullRequestLabels{CommitID: head, Names: test.want}
+		if !reflect.DeepEqual(pr.Status.Labels, want) {
+			t.Errorf("%s: got labels %+v, want %+v", test.file, pr.Status.Labels, want)
+		}
+	}
+
+	// redeliveries do not write the status again.
+	c.statusUpdates = 0
+	deliver(t, wh, "pull_request", "pull_request_unlabeled.json", webhookTestSecret)
+	if c.statusUpdates != 0 {
+		t.Errorf("got %d status updates for unchanged labels, want 0", c.statusUpdates)
+	}
+}
+
+func TestGithubWebhookClosed(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      ClosedPRPolicy
+		wantDeleted bool
+	}{
+		{"delete", ClosedPRPolicy{Action: ClosedPRDelete}, true},
+		{"grace period", ClosedPRPolicy{Action: ClosedPRDelete, GracePeriod: time.Hour}, false},
+		{"keep", ClosedPRPolicy{Action: ClosedPRKeep}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClient()
+			wh := newTestWebhook(c, test.policy)
+			deliver(t, wh, "pull_request", "pull_request_opened.json", webhookTestSecret)
+
+			if code := deliver(t, wh, "pull_request", "pull_request_closed.json", webhookTestSecret); code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", code, http.StatusOK)
+			}
+			pr := c.pullRequest(webhookTestNamespace, webhookTestPRName)
+			if deleted := pr == nil; deleted != test.wantDeleted {
+				t.Fatalf("got deleted %v, want %v", deleted, test.wantDeleted)
+			}
+			if pr == nil {
+				return
+			}
+			if pr.Status.State != prStateMerged || pr.Status.ClosedAt == nil {
+				t.Errorf("got state %q closed at %v, want %q", pr.Status.State, pr.Status.ClosedAt, prStateMerged)
+			}
+			if archived := test.policy.Action == ClosedPRKeep; pr.Status.Archived != archived {
+				t.Errorf("got archived %v, want %v", pr.Status.Archived, archived)
+			}
+		})
+	}
+}
+
+func TestGithubWebhookBadSignature(t *testing.T) {
+	c := newFakeClient()
+	wh := newTestWebhook(c, ClosedPRPolicy{Action: ClosedPRDelete})
+
+	if code := deliver(t, wh, "pull_request", "pull_request_opened.json", "not-the-secret"); code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if c.pullRequest(webhookTestNamespace, webhookTestPRName) != nil {
+		t.Error("PullRequest was created for a delivery with a bad signature")
+	}
+}
+
+func TestGithubWebhookPing(t *testing.T) {
+	wh := newTestWebhook(newFakeClient(), ClosedPRPolicy{Action: ClosedPRDelete})
+	if code := deliver(t, wh, "ping", "ping.json", webhookTestSecret); code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,387 @@
+package pullrequest
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"path"
+	"reflect"
+
+	"github.com/droot/godocbot/pkg/apidiff"
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// apiDiffContainerName is the name of the container comparing the
+	// exported API of the commitID of a PR with its base commit, in the
+	// render jobs of static godoc and in the apidiff jobs of the godoc
+	// servers.
+	apiDiffContainerName = "apidiff"
+
+	// apiDiffJobLabel is set on the apidiff jobs and their pods to the name
+	// of their PullRequest. They do not carry pullRequestLabel, which
+	// selects the godoc pods of the PR.
+	apiDiffJobLabel = "godocs.io/apidiff"
+	// apiDiffPrintContainer is the container of the apidiff jobs printing
+	// the HTML page of the API changes, which the controller reads from its
+	// log.
+	apiDiffPrintContainer = "print"
+	// apiDiffOutDir is where the apidiff container writes the HTML page of
+	// the API changes in the apidiff jobs.
+	apiDiffOutDir = "/out"
+
+	// apiDiffPagesVolume is the volume of the ConfigMap holding the HTML
+	// pages of the API changes in the godoc pods. It is mounted in the
+	// GOROOT of the godoc container at apiDiffServeDir, which godoc serves
+	// at apiDiffServePath, as is since the pages are full HTML documents.
+	apiDiffPagesVolume = "apidiff"
+	apiDiffServeDir    = "/usr/local/go/doc/apidiff"
+	apiDiffServePath   = "/doc/apidiff/"
+	// maxAPIDiffPagesSize bounds the size of the pages held by a
+	// ConfigMap, whose size is limited to 1MiB.
+	maxAPIDiffPagesSize = 768 << 10
+)
+
+// apiDiffEnabled returns true if the API changes of the PR are published.
+func (r *pullRequestReconciler) apiDiffEnabled(pr *v1alpha1.PullRequest) bool {
+	return r.apiDiffImage != "" && pr.Spec.BaseCommitID != ""
+}
+
+// apiDiffRecorded returns true if the API changes between the commitID of
+// the PR and its base commit are recorded in its status.
+func apiDiffRecorded(pr *v1alpha1.PullRequest) bool {
+	d := pr.Status.APIDiff
+	return d != nil && d.CommitID == pr.Spec.CommitID && d.BaseCommitID == pr.Spec.BaseCommitID
+}
+
+// reconcileAPIDiff ensures a job compares the commitID of the PR with its base
+// commit when godoc is served by godoc servers. Once it has, the API changes
+// are recorded in the status, and their HTML page is stored in the pages
+// ConfigMap, which the godoc pods serve at apiDiffServePath. The page is
+// thereby reachable through the exposer next to godoc, at baseURL. The pages
+// of the ConfigMap other than the ones of the keys are dropped. Jobs of
+// previous commits are deleted.
+func (r *pullRequestReconciler) reconcileAPIDiff(ctx context.Context, pr, prCopy *v1alpha1.PullRequest, prinfo *prInfo, baseURL string, pages *v1.ConfigMap, keys []string) error {
+	labels := map[string]string{apiDiffJobLabel: pr.Name}
+	name := apiDiffJobName(pr)
+	if !r.apiDiffEnabled(pr) || pr.Status.Archived {
+		name = ""
+	}
+	if err := r.deleteStaleJobs(ctx, pr, labels, name); err != nil {
+		return err
+	}
+	// the link of the page is only known once godoc is exposed.
+	if name == "" || baseURL == "" || apiDiffRecorded(pr) {
+		return nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: name}, job)
+	if errors.IsNotFound(err) {
+		log.Printf("creating apidiff job %s for %s/%s", name, pr.Namespace, pr.Name)
+		return r.Client.Create(ctx, r.apiDiffJob(pr, prinfo, name, labels))
+	}
+	if err != nil {
+		return err
+	}
+	if job.Status.Succeeded == 0 {
+		// the job is watched.
+		return nil
+	}
+	pod, summary, err := r.apiDiffSummary(ctx, pr, job)
+	if err != nil || summary == nil {
+		return err
+	}
+	page, err := r.podLogs.ReadLogs(ctx, pod, apiDiffPrintContainer)
+	if err != nil {
+		return err
+	}
+	key := apiDiffPageKey(prinfo)
+	stored, err := r.storeAPIDiffPage(ctx, pages, keys, key, string(page))
+	if err != nil {
+		return err
+	}
+	link := ""
+	if stored {
+		link = baseURL + apiDiffServePath + key
+	}
+	setAPIDiff(pr, prCopy, summary, link)
+	return nil
+}
+
+// apiDiffJobName returns the name of the apidiff job of the commitID of the PR
+// and of its base commit.
+func apiDiffJobName(pr *v1alpha1.PullRequest) string {
+	return commitJobName(pr, "-apidiff")
+}
+
+// apiDiffJob returns the job comparing the commitID of the PR with its base
+// commit. Its apidiff init container writes the HTML page of the API changes,
+// which its main container prints.
+func (r *pullRequestReconciler) apiDiffJob(pr *v1alpha1.PullRequest, prinfo *prInfo, name string, labels map[string]string) *batchv1.Job {
+	backoffLimit := int32(2)
+	out := v1.VolumeMount{Name: "out", MountPath: apiDiffOutDir}
+	podSpec := v1.PodSpec{
+		RestartPolicy:  v1.RestartPolicyNever,
+		InitContainers: []v1.Container{r.apiDiffContainer(pr, prinfo, out)},
+		Containers: []v1.Container{
+			{
+				Name:         apiDiffPrintContainer,
+				Image:        "busybox",
+				Command:      []string{"cat", path.Join(apiDiffOutDir, "apidiff.html")},
+				VolumeMounts: []v1.VolumeMount{out},
+			},
+		},
+		Volumes: append([]v1.Volume{{
+			Name:         "out",
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		}}, credentialsVolumes(fetchContainerName, prinfo)...),
+	}
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pr.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	addOwnerRefToObject(job, pullRequestOwnerRef(pr))
+	return job
+}
+
+// apiDiffContainer returns the container writing the changes made by the PR
+// to the exported API as an HTML page to the out mount, and their summary to its
+// termination message along with the doc comment problems of the files the PR
+// touches. The head is compared with its merge base with the base branch, so
+// the changes made to the base branch since the PR was opened are left out.
+// Private repos are cloned with the git credentials mounted in the fetch
+// container, set up as godoc-fetch does.
+func (r *pullRequestReconciler) apiDiffContainer(pr *v1alpha1.PullRequest, prinfo *prInfo, out v1.VolumeMount) v1.Container {
+	script := `set -e
+if [ -n "$CREDENTIALS" ]; then
+  export HOME="$(mktemp -d)" GIT_TERMINAL_PROMPT=0
+  if [ -f "$CREDENTIALS/token" ]; then
+    USERNAME="$(cat "$CREDENTIALS/username" 2>/dev/null || true)"
+    printf 'machine %s login %s password %s\n' "$GIT_HOST" "${USERNAME:-x-access-token}" "$(cat "$CREDENTIALS/token")" > "$HOME/.netrc"
+    chmod 600 "$HOME/.netrc"
+  else
+    mkdir -m 700 "$HOME/.ssh"
+    printf '%s\n' "$(cat "$CREDENTIALS/ssh-privatekey")" > "$HOME/.ssh/id"
+    cp "$CREDENTIALS/known_hosts" "$HOME/.ssh/known_hosts"
+    chmod 600 "$HOME/.ssh/id" "$HOME/.ssh/known_hosts"
+    export GIT_SSH_COMMAND="ssh -i $HOME/.ssh/id -o UserKnownHostsFile=$HOME/.ssh/known_hosts -o StrictHostKeyChecking=yes"
+    git config --global url."ssh://git@$GIT_HOST/".insteadOf "https://$GIT_HOST/"
+  fi
+fi
+cd "$(mktemp -d)"
+git clone -q "$CLONE_URL" head
+cd head
+git fetch -q origin "$REFSPEC"
+git checkout -q "$COMMIT_ID"
+git cat-file -e "$BASE_COMMIT_ID" || git fetch -q origin "$BASE_COMMIT_ID"
+MERGE_BASE="$(git merge-base "$BASE_COMMIT_ID" "$COMMIT_ID")"
+git worktree add -q ../base "$MERGE_BASE"
+git diff --name-only "$MERGE_BASE" "$COMMIT_ID" > ../touched
+cd ..
+apidiff -base base -head head -import-path "$IMPORT_PATH" -touched touched -html "$OUT/apidiff.html" -summary /dev/termination-log`
+	c := v1.Container{
+		Name:    apiDiffContainerName,
+		Image:   r.apiDiffImage,
+		Command: []string{"/bin/sh", "-c", script},
+		Env: []v1.EnvVar{
+			{Name: "CLONE_URL", Value: prinfo.cloneURL()},
+			{Name: "REFSPEC", Value: prinfo.fetchRefspec()},
+			{Name: "COMMIT_ID", Value: pr.Spec.CommitID},
+			{Name: "BASE_COMMIT_ID", Value: pr.Spec.BaseCommitID},
+			{Name: "IMPORT_PATH", Value: path.Join(prinfo.host, prinfo.org, prinfo.repo)},
+			{Name: "OUT", Value: out.MountPath},
+		},
+		VolumeMounts: []v1.VolumeMount{out},
+	}
+	if prinfo.fetch != nil && prinfo.fetch.SecretName != "" {
+		c.Env = append(c.Env,
+			v1.EnvVar{Name: "CREDENTIALS", Value: credentialsDir},
+			v1.EnvVar{Name: "GIT_HOST", Value: prinfo.host})
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{Name: credentialsVolumeName(fetchContainerName), MountPath: credentialsDir, ReadOnly: true})
+	}
+	return c
+}
+
+// apiDiffSummary returns a succeeded pod of the job, along with the summary
+// of the API changes and of the doc comment problems its apidiff container
+// left in its termination message. Both are nil if the pods of the job are
+// gone or the summary cannot be decoded.
+func (r *pullRequestReconciler) apiDiffSummary(ctx context.Context, pr *v1alpha1.PullRequest, job *batchv1.Job) (*v1.Pod, *apidiff.Summary, error) {
+	pods := &v1.PodList{}
+	opts := client.InNamespace(pr.Namespace).MatchingLabels(map[string]string{"job-name": job.Name})
+	if err := r.Client.List(ctx, opts, pods); err != nil {
+		return nil, nil, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != v1.PodSucceeded {
+			continue
+		}
+		for _, cs := range pod.Status.InitContainerStatuses {
+			if cs.Name != apiDiffContainerName || cs.State.Terminated == nil {
+				continue
+			}
+			summary := &apidiff.Summary{}
+			if err := json.Unmarshal([]byte(cs.State.Terminated.Message), summary); err != nil {
+				log.Printf("error decoding the API changes of %s/%s: %v", pr.Namespace, pr.Name, err)
+				return nil, nil, nil
+			}
+			return pod, summary, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// setAPIDiff records in the status the summary of the API changes, their
+// compatibility and the doc comment problems, along with the link of the
+// HTML page of the API changes.
+func setAPIDiff(pr, prCopy *v1alpha1.PullRequest, summary *apidiff.Summary, link string) {
+	prCopy.Status.APIDiff = &v1alpha1.APIDiff{
+		CommitID:     pr.Spec.CommitID,
+		BaseCommitID: pr.Spec.BaseCommitID,
+		Added:        int32(summary.Added),
+		Removed:      int32(summary.Removed),
+		Changed:      int32(summary.Changed),
+		DocChanged:   int32(summary.DocChanged),
+		Changes:      summary.Changes,
+		Link:         link,
+	}
+	// the incompatible changes come first in the summary.
+	incompatible := summary.Changes
+	if len(incompatible) > summary.Incompatible {
+		incompatible = incompatible[:summary.Incompatible]
+	}
+	prCopy.Status.APICompatibility = &v1alpha1.APICompatibility{
+		CommitID:            pr.Spec.CommitID,
+		BaseCommitID:        pr.Spec.BaseCommitID,
+		Compatible:          summary.Incompatible == 0,
+		Incompatible:        int32(summary.Incompatible),
+		IncompatibleChanges: incompatible,
+	}
+	prCopy.Status.DocLint = &v1alpha1.DocLint{
+		CommitID: pr.Spec.CommitID,
+		Total:    int32(summary.Problems),
+		// the commit is reviewed once, even if its base changes.
+		Reviewed: pr.Status.DocLint != nil && pr.Status.DocLint.CommitID == pr.Spec.CommitID && pr.Status.DocLint.Reviewed,
+	}
+	for _, p := range summary.Lint {
+		prCopy.Status.DocLint.Problems = append(prCopy.Status.DocLint.Problems, v1alpha1.DocProblem{
+			File:    p.File,
+			Line:    int32(p.Line),
+			Message: p.Message,
+		})
+	}
+}
+
+// apiDiffPagesName returns the name of the ConfigMap holding the HTML pages of
+// the API changes served by the named godoc deployment.
+func apiDiffPagesName(deployment string) string {
+	return deployment + "-apidiff"
+}
+
+// apiDiffPageKey returns the key of the HTML page of the API changes of the
+// PR in its pages ConfigMap, which is the file name the page is served at.
+func apiDiffPageKey(prinfo *prInfo) string {
+	return prinfo.sharedPrefix() + ".html"
+}
+
+// apiDiffPages returns the metadata of the pages ConfigMap of the named godoc
+// deployment, owned by the given owners.
+func apiDiffPages(namespace, deployment string, ownerRefs []metav1.OwnerReference) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            apiDiffPagesName(deployment),
+			Namespace:       namespace,
+			OwnerReferences: ownerRefs,
+		},
+	}
+}
+
+// storeAPIDiffPage stores the page at the key of the desired pages ConfigMap,
+// and drops the pages of the keys which are not given. It returns false if
+// the page does not fit in the ConfigMap.
+func (r *pullRequestReconciler) storeAPIDiffPage(ctx context.Context, desired *v1.ConfigMap, keys []string, key, page string) (bool, error) {
+	cm := &v1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, cm)
+	found := err == nil
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	data := map[string]string{key: page}
+	size := len(page)
+	for _, k := range keys {
+		if p, ok := cm.Data[k]; ok && k != key {
+			data[k] = p
+			size += len(p)
+		}
+	}
+	if size > maxAPIDiffPagesSize {
+		log.Printf("not publishing the API changes page %s in ConfigMap %s/%s, the pages would take %d bytes", key, desired.Namespace, desired.Name, size)
+		return false, nil
+	}
+	if !found {
+		cm = desired.DeepCopy()
+		cm.Data = data
+		log.Printf("creating the API changes ConfigMap %s/%s", cm.Namespace, cm.Name)
+		return true, r.Client.Create(ctx, cm)
+	}
+	if reflect.DeepEqual(cm.Data, data) && ownerRefsEqual(cm.OwnerReferences, desired.OwnerReferences) {
+		return true, nil
+	}
+	cmCopy := cm.DeepCopy()
+	cmCopy.Data = data
+	cmCopy.OwnerReferences = desired.OwnerReferences
+	return true, r.Client.Update(ctx, cmCopy)
+}
+
+// addAPIDiffPages mounts the pages ConfigMap in the godoc container of the
+// godoc pod spec, if API changes are published. The ConfigMap is optional,
+// the kubelet adds the pages to the mount as they are stored.
+func (r *pullRequestReconciler) addAPIDiffPages(spec *v1.PodSpec, configMap string) {
+	if r.apiDiffImage == "" {
+		return
+	}
+	// the mode defaulted by the API server is set, so that the volumes of
+	// existing pods can be compared with the desired ones.
+	mode := int32(0644)
+	optional := true
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name: apiDiffPagesVolume,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: configMap},
+				DefaultMode:          &mode,
+				Optional:             &optional,
+			},
+		},
+	})
+	godoc := &spec.Containers[0]
+	godoc.VolumeMounts = append(godoc.VolumeMounts, v1.VolumeMount{Name: apiDiffPagesVolume, MountPath: apiDiffServeDir, ReadOnly: true})
+}
@@ -0,0 +1,166 @@
+package pullrequest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apidiff"
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// completeAPIDiffJob makes the apidiff job succeed with a pod which left the
+// summary in the termination message of its apidiff container and printed
+// the page.
+func completeAPIDiffJob(t *testing.T, c *fakeClient, job *batchv1.Job, summary *apidiff.Summary, page string) {
+	msg, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &v1.Pod{ObjectMeta: metaFor(job.Namespace, job.Name+"-abcde")}
+	pod.Labels = map[string]string{"job-name": job.Name}
+	pod.Status.Phase = v1.PodSucceeded
+	pod.Status.InitContainerStatuses = []v1.ContainerStatus{{
+		Name:  apiDiffContainerName,
+		State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Message: string(msg)}},
+	}}
+	if err := c.Create(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	c.logs[pod.Namespace+"/"+pod.Name+"/"+apiDiffPrintContainer] = page
+	job.Status.Succeeded = 1
+	if err := c.Update(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileAPIDiff(t *testing.T) {
+	ctx := context.Background()
+	pr := trackedPullRequest(7, sha(1))
+	c := newFakeClient(pr)
+	r := &pullRequestReconciler{Client: c, jobDeleter: c, podLogs: c, apiDiffImage: "apidiff"}
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prinfo.commitID = pr.Spec.CommitID
+	const baseURL = "https://docs.example.com"
+	pages := apiDiffPages(pr.Namespace, "godoc-github-com-kubernetes-sigs-kubebuilder", nil)
+	// the page of a PR which is no longer served is dropped.
+	stale := pages.DeepCopy()
+	stale.Data = map[string]string{"pr-1.html": "<!DOCTYPE html>"}
+	if err := c.Create(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"pr-2.html", apiDiffPageKey(prinfo)}
+	reconcileOnce := func(pr *v1alpha1.PullRequest) *v1alpha1.PullRequest {
+		prCopy := pr.DeepCopy()
+		if err := r.reconcileAPIDiff(ctx, pr, prCopy, prinfo, baseURL, pages, keys); err != nil {
+			t.Fatal(err)
+		}
+		return prCopy
+	}
+	getJob := func(name string) *batchv1.Job {
+		job := &batchv1.Job{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: name}, job); err != nil {
+			return nil
+		}
+		return job
+	}
+
+	// the job is keyed on the head and base commits, its pods are not
+	// selected as godoc pods of the PR.
+	reconcileOnce(pr)
+	job := getJob(apiDiffJobName(pr))
+	if job == nil {
+		t.Fatalf("apidiff job %s was not created", apiDiffJobName(pr))
+	}
+	if !strings.Contains(job.Name, "-apidiff-") {
+		t.Errorf("got job name %s, want the apidiff job to be told apart from render jobs", job.Name)
+	}
+	if _, found := job.Spec.Template.Labels[pullRequestLabel]; found {
+		t.Errorf("got pod labels %v, want no %s label", job.Spec.Template.Labels, pullRequestLabel)
+	}
+
+	summary := &apidiff.Summary{Removed: 1, Incompatible: 1, Changes: []string{"removed func Foo"}, Problems: 1, Lint: []apidiff.Problem{{File: "foo.go", Line: 3, Message: "exported Bar should have a comment"}}}
+	const page = "<!DOCTYPE html>\n<title>API changes</title>\n"
+	completeAPIDiffJob(t, c, job, summary, page)
+	got := reconcileOnce(pr)
+	if got.Status.APIDiff == nil || got.Status.APIDiff.Link != baseURL+"/doc/apidiff/pr-7.html" {
+		t.Fatalf("got API diff %+v, want it linked to the page served by godoc", got.Status.APIDiff)
+	}
+	if compat := got.Status.APICompatibility; compat == nil || compat.Compatible || compat.BaseCommitID != pr.Spec.BaseCommitID {
+		t.Errorf("got API compatibility %+v, want the incompatible change recorded", compat)
+	}
+	if lint := got.Status.DocLint; lint == nil || lint.Total != 1 || len(lint.Problems) != 1 {
+		t.Errorf("got doc lint %+v, want the problem recorded", lint)
+	}
+	cm := &v1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: pages.Namespace, Name: pages.Name}, cm); err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.Data) != 1 || cm.Data["pr-7.html"] != page {
+		t.Errorf("got pages %v, want the page of PR 7 only", cm.Data)
+	}
+
+	// a push replaces the job of the previous commit.
+	pushed := pr.DeepCopy()
+	pushed.Status = got.Status
+	pushed.Spec.CommitID = strings.Repeat("ab", 20)
+	reconcileOnce(pushed)
+	if getJob(job.Name) != nil {
+		t.Errorf("apidiff job %s of the previous commit was not deleted", job.Name)
+	}
+	if getJob(apiDiffJobName(pushed)) == nil {
+		t.Error("apidiff job of the new commit was not created")
+	}
+}
+
+func TestStoreAPIDiffPageTooLarge(t *testing.T) {
+	c := newFakeClient()
+	r := &pullRequestReconciler{Client: c}
+	pages := apiDiffPages("docs", "kubebuilder-pr-7", []metav1.OwnerReference{pullRequestOwnerRef(trackedPullRequest(7, sha(1)))})
+	stored, err := r.storeAPIDiffPage(context.Background(), pages, nil, "pr-7.html", strings.Repeat("x", maxAPIDiffPagesSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored {
+		t.Error("got a page larger than a ConfigMap stored")
+	}
+}
+
+func TestGodocPodServesAPIDiffPages(t *testing.T) {
+	pr := trackedPullRequest(7, sha(1))
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &pullRequestReconciler{exposer: &nodePortExposer{nodeHost: "node"}, apiDiffImage: "apidiff"}
+	spec := r.godocPodSpec(pr, prinfo)
+	var volume *v1.Volume
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == apiDiffPagesVolume {
+			volume = &spec.Volumes[i]
+		}
+	}
+	if volume == nil || volume.ConfigMap == nil || volume.ConfigMap.Name != apiDiffPagesName(pr.Name) || !*volume.ConfigMap.Optional {
+		t.Fatalf("got volume %+v, want the optional pages ConfigMap", volume)
+	}
+	mounted := false
+	for _, m := range spec.Containers[0].VolumeMounts {
+		mounted = mounted || m.Name == apiDiffPagesVolume && m.MountPath == apiDiffServeDir
+	}
+	if !mounted {
+		t.Errorf("got mounts %+v, want the pages mounted in the GOROOT of godoc", spec.Containers[0].VolumeMounts)
+	}
+
+	r.apiDiffImage = ""
+	if spec := r.godocPodSpec(pr, prinfo); len(spec.Volumes) != 1 {
+		t.Errorf("got volumes %+v without API changes, want the GOPATH only", spec.Volumes)
+	}
+}
@@ -5,34 +5,154 @@ import (
 	"fmt"
 	"log"
 	"net/url"
-	"strconv"
+	"path"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/droot/godocbot/pkg/godocsync"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/controller"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/event"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/predicate"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
 	"github.com/kubernetes-sigs/controller-runtime/pkg/source"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // GodocDeployer watches PullRequest object which have a commitID specified in
 // their Spec and deploys a Godoc deployment which runs godoc server for the PR.
 // It watches the PullRequest object for changes in commitID and reconciles the
-// generated godoc deployment.
+// generated godoc deployment. With GodocDeployerOptions.Static, godoc is
+// rendered to static HTML by a job per commit instead, and with
+// GodocDeployerOptions.Shared the PRs of a repo share a single deployment.
+// If GithubClients are given, the progress of the deployment is reported as a
+// commit status on the PR. With GodocDeployerOptions.APIDiffImage, the
+// changes made by the PRs to the exported API are published next to their
+// godoc in every mode.
 type GodocDeployer struct {
 	controller.Controller
 }
 
-func NewGodocDeployer(mgr manager.Manager) (*GodocDeployer, error) {
+// GodocDeployerOptions are the options for creating a GodocDeployer.
+type GodocDeployerOptions struct {
+	// GithubClients are used to report commit statuses on the PRs of their
+	// hosts, reporting is disabled if it is nil.
+	GithubClients GithubClients
+	// Exposer makes the godoc deployments reachable.
+	Exposer Exposer
+	// Resources are the compute resources of the godoc container.
+	Resources v1.ResourceRequirements
+	// LivenessInitialDelay is the time given to the godoc container to index
+	// the repo before its liveness is probed.
+	LivenessInitialDelay time.Duration
+	// Static makes godoc be rendered to static HTML by a Job per commit
+	// instead of being served by a godoc deployment per PR, if set.
+	Static *StaticConfig
+	// Shared makes the open PRs of a repo be served by a single godoc
+	// deployment, each at its own GOPATH prefix, instead of a deployment
+	// per PR. It is not supported by the ssh-tunnel exposer. The controller
+	// reads the checked out commits from the sync container of the godoc
+	// pods, on port 6061 of their IP.
+	Shared bool
+	// APIDiffImage is the image of cmd/apidiff. If set, the changes made to
+	// the exported API by the PRs whose base commit is known are published
+	// next to their godoc: along with the static HTML by the render jobs,
+	// and otherwise by a job per commit whose HTML page is served by the
+	// godoc servers. API changes are not published if empty.
+	APIDiffImage string
+	// BreakingChangeLabel is the label of the PRs whose incompatible API
+	// changes are intended. Defaults to breaking-change.
+	BreakingChangeLabel string
+	// LabelsRefreshInterval is the interval the labels of the PRs whose
+	// incompatible API changes are not labeled are fetched again at, so that
+	// labeling them is noticed without the Github webhook. Defaults to 30s.
+	LabelsRefreshInterval time.Duration
+	// FetchImage is the image of cmd/godoc-fetch checking out the commit of
+	// the PRs before godoc is served or rendered, along with cmd/godoc-sync
+	// in the shared godoc pods. Defaults to
+	// gcr.io/sunilarora-sandbox/godoc-fetch:0.0.1.
+	FetchImage string
+	// GoProxy is the GOPROXY the dependencies of modules are downloaded
+	// from by the fetch container, the go command default if empty.
+	GoProxy string
+	// ClosedPRPolicy decides when the PullRequest objects of closed PRs are
+	// deleted once their grace period has passed. They are left to the
+	// Github syncer and webhook if its Action is empty.
+	ClosedPRPolicy ClosedPRPolicy
+}
+
+func NewGodocDeployer(mgr manager.Manager, opts GodocDeployerOptions) (*GodocDeployer, error) {
+	if opts.Static != nil {
+		if err := opts.Static.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Shared {
+		if opts.Static != nil {
+			return nil, fmt.Errorf("godoc cannot be both static and shared")
+		}
+		if _, ok := opts.Exposer.(*sshTunnelExposer); ok {
+			return nil, fmt.Errorf("shared godoc deployments are not supported by the %s exposer", ExposeSSHTunnel)
+		}
+	}
+	if opts.ClosedPRPolicy.Action != "" {
+		if err := opts.ClosedPRPolicy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	statusWriter, err := newStatusWriter(mgr)
+	if err != nil {
+		return nil, err
+	}
+	jobDeleter, err := newJobDeleter(mgr)
+	if err != nil {
+		return nil, err
+	}
+	podLogs, err := newPodLogReader(mgr)
+	if err != nil {
+		return nil, err
+	}
 	prReconciler := &pullRequestReconciler{
-		Client: mgr.GetClient(),
+		Client:                mgr.GetClient(),
+		statusWriter:          statusWriter,
+		jobDeleter:            jobDeleter,
+		podLogs:               podLogs,
+		ghClients:             opts.GithubClients,
+		exposer:               opts.Exposer,
+		resources:             opts.Resources,
+		livenessInitialDelay:  opts.LivenessInitialDelay,
+		static:                opts.Static,
+		shared:                opts.Shared,
+		apiDiffImage:          opts.APIDiffImage,
+		breakingChangeLabel:   opts.BreakingChangeLabel,
+		labelsRefreshInterval: opts.LabelsRefreshInterval,
+		labelsFetched:         map[types.NamespacedName]time.Time{},
+		fetchImage:            opts.FetchImage,
+		goproxy:               opts.GoProxy,
+		closedPRPolicy:        opts.ClosedPRPolicy,
+		requeuer:              newRequeuer(),
+		worktreeStatuses:      httpWorktreeStatuses,
+	}
+	if prReconciler.breakingChangeLabel == "" {
+		prReconciler.breakingChangeLabel = "breaking-change"
+	}
+	if prReconciler.labelsRefreshInterval == 0 {
+		prReconciler.labelsRefreshInterval = 30 * time.Second
+	}
+	if prReconciler.fetchImage == "" {
+		prReconciler.fetchImage = "gcr.io/sunilarora-sandbox/godoc-fetch:0.0.1"
 	}
 
 	// Setup a new controller to Reconcile PullRequests
@@ -49,25 +169,136 @@ func NewGodocDeployer(mgr manager.Manager) (*GodocDeployer, error) {
 		return nil, err
 	}
 
-	// Watch deployments generated for PullRequests objects
+	// Watch the PullRequests requeued after a delay, e.g. at the end of
+	// the grace period of closed PRs
+	err = c.Watch(prReconciler.requeuer.source(), &handler.Enqueue{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch deployments generated for PullRequests objects, shared
+	// deployments are owned by all the PullRequests of their repo.
 	err = c.Watch(
 		&source.Kind{Type: &appsv1.Deployment{}},
 		&handler.EnqueueOwner{
 			OwnerType:    &v1alpha1.PullRequest{},
-			IsController: true,
+			IsController: false,
 		},
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	// Watch services and ingresses generated for PullRequests objects
+	for _, t := range []runtime.Object{&v1.Service{}, &extensionsv1beta1.Ingress{}} {
+		err = c.Watch(
+			&source.Kind{Type: t},
+			&handler.EnqueueOwner{
+				OwnerType:    &v1alpha1.PullRequest{},
+				IsController: true,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Watch the jobs rendering static godoc or comparing the API of
+	// PullRequests objects
+	if opts.Static != nil || opts.APIDiffImage != "" {
+		err = c.Watch(
+			&source.Kind{Type: &batchv1.Job{}},
+			&handler.EnqueueOwner{
+				OwnerType:    &v1alpha1.PullRequest{},
+				IsController: true,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Watch the Secrets holding the git credentials of PullRequests, which
+	// may be created after them. Only their existence matters, the kubelet
+	// updates the content of Secret volumes
+	if err = indexFetchSecret(mgr.GetFieldIndexer()); err != nil {
+		return nil, err
+	}
+	err = c.Watch(
+		&source.Kind{Type: &v1.Secret{}},
+		&handler.EnqueueMapped{
+			ToRequests: handler.ToRequestsFunc(prReconciler.pullRequestsForSecret),
+		},
+		predicate.Funcs{
+			UpdateFunc:  func(event.UpdateEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch godoc pods to notice when they become ready or crash
+	if err = indexSharedName(mgr.GetFieldIndexer()); err != nil {
+		return nil, err
+	}
+	err = c.Watch(
+		&source.Kind{Type: &v1.Pod{}},
+		&handler.EnqueueMapped{
+			ToRequests: handler.ToRequestsFunc(prReconciler.pullRequestsForPod),
+		},
+		godocPodPredicate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	return &GodocDeployer{Controller: c}, nil
 }
 
 // pullRequestReconciler ensures there is a godoc deployment is running with
 // the commitID specified in the PullRequest object.
 type pullRequestReconciler struct {
-	Client client.Client
+	Client       client.Client
+	statusWriter StatusWriter
+	// jobDeleter deletes the render and apidiff jobs of previous commits.
+	jobDeleter JobDeleter
+	// podLogs reads the HTML pages printed by the apidiff jobs.
+	podLogs PodLogReader
+	// ghClients are used to report commit statuses of Github PRs, it is
+	// nil if reporting is disabled.
+	ghClients GithubClients
+	// exposer makes the godoc deployments reachable.
+	exposer Exposer
+	// resources and livenessInitialDelay configure the godoc container.
+	resources            v1.ResourceRequirements
+	livenessInitialDelay time.Duration
+	// static is set when godoc is rendered to static HTML.
+	static *StaticConfig
+	// shared is set when the PRs of a repo share a godoc deployment.
+	shared bool
+	// apiDiffImage is the image of cmd/apidiff, API changes are not
+	// published if it is empty.
+	apiDiffImage string
+	// breakingChangeLabel marks the PRs whose incompatible API changes are
+	// intended.
+	breakingChangeLabel string
+	// labelsRefreshInterval is the interval the labels of the PRs failing
+	// the API compatibility status are fetched again at, and labelsFetched
+	// when they were last fetched by PR.
+	labelsRefreshInterval time.Duration
+	labelsFetched         map[types.NamespacedName]time.Time
+	// fetchImage is the image of the init container checking out the commit
+	// of the PR, and goproxy the GOPROXY it downloads dependencies from.
+	fetchImage string
+	goproxy    string
+	// closedPRPolicy decides when the PullRequests of closed PRs are
+	// deleted, and requeuer reconciles them again once they are due.
+	closedPRPolicy ClosedPRPolicy
+	requeuer       *requeuer
+	// worktreeStatuses reads the worktrees of the PRs served by a shared
+	// godoc pod.
+	worktreeStatuses func(ctx context.Context, pod *v1.Pod) (map[string]godocsync.Status, error)
 }
 
 func (r *pullRequestReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
@@ -79,6 +310,7 @@ func (r *pullRequestReconciler) Reconcile(request reconcile.Request) (reconcile.
 	err := r.Client.Get(ctx, request.NamespacedName, pr)
 	if errors.IsNotFound(err) {
 		log.Printf("Could not find PullRequest %v.\n", request)
+		delete(r.labelsFetched, request.NamespacedName)
 		return reconcile.Result{}, nil
 	}
 
@@ -87,17 +319,57 @@ func (r *pullRequestReconciler) Reconcile(request reconcile.Request) (reconcile.
 		return reconcile.Result{}, err
 	}
 
+	if deleting, err := r.finalizeStatic(ctx, pr); deleting || err != nil {
+		return reconcile.Result{}, err
+	}
+	if deleted, err := r.deleteClosedPullRequest(ctx, pr); deleted || err != nil {
+		return reconcile.Result{}, err
+	}
+
+	prCopy := pr.DeepCopy()
+	prCopy.Status.ObservedGeneration = pr.Generation
+
 	if pr.Spec.CommitID == "" {
 		log.Printf("Waiting for PR %s commitID to be updated", request.NamespacedName)
-		return reconcile.Result{}, nil
+		prCopy.Status.SetCondition(v1alpha1.CommitResolved, v1.ConditionFalse, "WaitingForCommit", "commitID of the PR is not known yet")
+		setReadyCondition(&prCopy.Status)
+		return reconcile.Result{}, r.writeStatus(ctx, pr, prCopy)
+	}
+	prCopy.Status.SetCondition(v1alpha1.CommitResolved, v1.ConditionTrue, "Resolved", fmt.Sprintf("commitID of the PR is %s", pr.Spec.CommitID))
+
+	// the fetch container of private repos cannot start without the
+	// Secret holding their git credentials.
+	if !pr.Status.Archived {
+		found, err := r.fetchSecretFound(ctx, pr)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !found {
+			prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, secretNotFoundReason, fmt.Sprintf("secret %s holding the git credentials is not found", pr.Spec.Fetch.SecretName))
+			setReadyCondition(&prCopy.Status)
+			return reconcile.Result{}, r.writeStatus(ctx, pr, prCopy)
+		}
+	}
+
+	if r.static != nil {
+		return reconcile.Result{}, r.reconcileStatic(ctx, pr, prCopy)
+	}
+	if r.shared {
+		return reconcile.Result{}, r.reconcileShared(ctx, pr, prCopy)
 	}
 
 	dp := &appsv1.Deployment{}
 	err = r.Client.Get(ctx, request.NamespacedName, dp)
+	if errors.IsNotFound(err) && pr.Status.Archived {
+		// godoc is not served for archived PRs
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "Archived", "godoc is not served for closed PRs")
+		setReadyCondition(&prCopy.Status)
+		return reconcile.Result{}, r.writeStatus(ctx, pr, prCopy)
+	}
 	if errors.IsNotFound(err) {
 		log.Printf("Could not find deployment for PullRequest %v. creating deployment", request)
 
-		dp, err = deploymentForPullRequest(pr)
+		dp, err = r.deploymentForPullRequest(pr)
 		if err != nil {
 			log.Printf("error creating new deployment for PullRequest: %v %v", request, err)
 			return reconcile.Result{}, nil
@@ -111,49 +383,206 @@ func (r *pullRequestReconciler) Reconcile(request reconcile.Request) (reconcile.
 
 	prinfo, _ := parsePullRequestURL(pr.Spec.URL)
 	prinfo.commitID = pr.Spec.CommitID
+	prinfo.modules = pr.Spec.Modules
+	prinfo.fetch = pr.Spec.Fetch
+	prinfo.importPaths = publishedImportPaths(pr)
 
-	if len(dp.Spec.Template.Spec.Containers[0].Args) <= 5 || (pr.Spec.CommitID != dp.Spec.Template.Spec.Containers[0].Args[5]) {
-		// deployment is not updated with latest commit-id
+	// the import paths fetched for the commit are the ones the readiness
+	// probe requests.
+	fetchFailure := ""
+	if !pr.Status.Archived {
+		var fetched []string
+		fetched, fetchFailure, err = r.fetchResult(ctx, pr, map[string]string{pullRequestLabel: pr.Name}, fetchContainerName)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if len(fetched) > 0 {
+			prinfo.importPaths = fetched
+		}
+	}
+
+	// a deployment which has just been updated is not available until it
+	// has rolled out the update.
+	updated := false
+	desired := r.godocPodSpec(pr, prinfo)
+	podLabels := godocPodLabels(pr, prinfo)
+	replicas := godocReplicas(pr)
+	if godocPodChanged(&dp.Spec.Template.Spec, &desired) ||
+		missingLabels(dp.Spec.Template.Labels, podLabels) ||
+		dp.Spec.Replicas == nil || *dp.Spec.Replicas != replicas {
+		// deployment is not updated with latest commit-id, probes,
+		// resources or pod labels, e.g. deployments created before
+		// crashes were detected, or is not scaled as per the PR state
 		dpCopy := dp.DeepCopy()
-		dpCopy.Spec.Template.Spec.Containers[0].Args = prinfo.godocContainerArgs()
+		updateGodocPod(&dpCopy.Spec.Template.Spec, &desired)
+		if dpCopy.Spec.Template.Labels == nil {
+			dpCopy.Spec.Template.Labels = map[string]string{}
+		}
+		for k, v := range podLabels {
+			dpCopy.Spec.Template.Labels[k] = v
+		}
+		dpCopy.Spec.Replicas = &replicas
 		if err = r.Client.Update(ctx, dpCopy); err != nil {
 			log.Printf("error updating the deployment for key %s", request.NamespacedName)
 			return reconcile.Result{}, err
 		}
+		updated = true
 	}
 
-	if pr.Status.GoDocLink == "" && dp.Status.AvailableReplicas > 0 {
-		log.Printf("deployment became available, updating the godoc link")
-		// update the status
-		prCopy := pr.DeepCopy()
-		prCopy.Status.GoDocLink = fmt.Sprintf("https://%s.serveo.net/pkg/%s/%s/%s", prinfo.subdomain(), prinfo.host, prinfo.org, prinfo.repo)
-		if err = r.Client.Update(ctx, prCopy); err != nil {
-			return reconcile.Result{}, err
-		}
-		log.Printf("godoc link updated successfully for pr %v", request.NamespacedName)
+	if err = r.exposer.Expose(ctx, r.Client, pr, prinfo, map[string]string{pullRequestLabel: pr.Name}); err != nil {
+		log.Printf("error exposing the deployment for key %s: %v", request.NamespacedName, err)
+		return reconcile.Result{}, err
+	}
+	baseURL, err := r.exposer.BaseURL(ctx, r.Client, pr, prinfo)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// pods only become available once the readiness probe finds godoc
+	// serving the repo.
+	available := !updated && deploymentCommitID(dp, fetchContainerName) == pr.Spec.CommitID &&
+		dp.Status.AvailableReplicas > 0 && dp.Status.UnavailableReplicas == 0
+	switch {
+	case pr.Status.Archived:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "Archived", "godoc is not served for closed PRs")
+	case available:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionTrue, "Available", fmt.Sprintf("deployment %s serves commit %s", dp.Name, pr.Spec.CommitID))
+	case fetchFailure != "":
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, fetchFailedReason, fetchFailure)
+	default:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "RollingOut", fmt.Sprintf("deployment %s is rolling out commit %s or godoc is still indexing it", dp.Name, pr.Spec.CommitID))
+	}
+
+	pages := apiDiffPages(pr.Namespace, dp.Name, []metav1.OwnerReference{pullRequestOwnerRef(pr)})
+	if err = r.reconcileAPIDiff(ctx, pr, prCopy, prinfo, baseURL, pages, []string{apiDiffPageKey(prinfo)}); err != nil {
+		log.Printf("error publishing the API changes for pr %v: %v", request.NamespacedName, err)
+		return reconcile.Result{}, err
+	}
+
+	var links []v1alpha1.ModuleLink
+	if baseURL != "" {
+		links = prinfo.godocLinks(baseURL, "")
+	}
+	if err = r.publishLink(ctx, pr, prCopy, prinfo, available, links); err != nil {
+		log.Printf("error publishing the godoc link for pr %v: %v", request.NamespacedName, err)
+		return reconcile.Result{}, err
 	}
 	return reconcile.Result{}, nil
 }
 
+// deleteClosedPullRequest deletes the PullRequest of a closed PR once its
+// grace period has passed, and otherwise makes it be reconciled again when it
+// does. It returns true if the PullRequest has been deleted.
+func (r *pullRequestReconciler) deleteClosedPullRequest(ctx context.Context, pr *v1alpha1.PullRequest) (bool, error) {
+	delay, deleting := r.closedPRPolicy.deletionDelay(pr)
+	if !deleting {
+		return false, nil
+	}
+	if delay > 0 {
+		r.requeuer.after(pr, delay)
+		return false, nil
+	}
+	log.Printf("deleting PullRequest %s/%s, the grace period of the %s PR has passed", pr.Namespace, pr.Name, pr.Status.State)
+	if err := r.Client.Delete(ctx, pr); err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// publishLink publishes the links of the documented modules in the status once
+// godoc is available for the commitID of the PR, reports the commit status and
+// writes the status. The link of the first module is the godoc link of the PR.
+func (r *pullRequestReconciler) publishLink(ctx context.Context, pr, prCopy *v1alpha1.PullRequest, prinfo *prInfo, available bool, links []v1alpha1.ModuleLink) error {
+	if available && len(links) > 0 &&
+		(pr.Status.GoDocLink != links[0].Link || !reflect.DeepEqual(pr.Status.GoDocLinks, links) || pr.Status.CommitID != pr.Spec.CommitID) {
+		log.Printf("godoc became available, updating the godoc link")
+		prCopy.Status.GoDocLink = links[0].Link
+		prCopy.Status.GoDocLinks = links
+		prCopy.Status.CommitID = pr.Spec.CommitID
+	}
+	if prCopy.Status.GoDocLink != "" && prCopy.Status.CommitID == pr.Spec.CommitID {
+		prCopy.Status.SetCondition(v1alpha1.LinkPublished, v1.ConditionTrue, "Published", prCopy.Status.GoDocLink)
+	} else {
+		prCopy.Status.SetCondition(v1alpha1.LinkPublished, v1.ConditionFalse, "WaitingForDeployment", "godoc link is published once the deployment is available")
+	}
+	setReadyCondition(&prCopy.Status)
+
+	if ghClient, err := r.ghClients.lookup(prinfo); err == nil {
+		if err = r.reportCommitStatus(ctx, ghClient, prinfo, prCopy); err != nil {
+			return fmt.Errorf("error reporting commit status: %v", err)
+		}
+		if err = r.reportAPICompatibility(ctx, ghClient, prinfo, prCopy); err != nil {
+			return fmt.Errorf("error reporting API compatibility: %v", err)
+		}
+	}
+
+	return r.writeStatus(ctx, pr, prCopy)
+}
+
+// writeStatus updates the status of the PullRequest if it has changed.
+func (r *pullRequestReconciler) writeStatus(ctx context.Context, pr, prCopy *v1alpha1.PullRequest) error {
+	if reflect.DeepEqual(pr.Status, prCopy.Status) {
+		return nil
+	}
+	if err := r.statusWriter.UpdateStatus(ctx, prCopy); err != nil {
+		return err
+	}
+	log.Printf("status updated successfully for pr %s/%s", pr.Namespace, pr.Name)
+	return nil
+}
+
+// setReadyCondition sets the Ready condition from the other conditions.
+func setReadyCondition(status *v1alpha1.PullRequestStatus) {
+	for _, t := range []v1alpha1.PullRequestConditionType{v1alpha1.CommitResolved, v1alpha1.DeploymentAvailable, v1alpha1.LinkPublished} {
+		if !status.IsConditionTrue(t) {
+			status.SetCondition(v1alpha1.Ready, v1.ConditionFalse, "NotReady", fmt.Sprintf("condition %s is not true", t))
+			return
+		}
+	}
+	status.SetCondition(v1alpha1.Ready, v1.ConditionTrue, "Ready", "godoc is served for the PR")
+}
+
+// godocReplicas returns the number of godoc replicas to run for the PR. We are
+// good with running with one replica, and none once the PR is archived.
+func godocReplicas(pr *v1alpha1.PullRequest) int32 {
+	if pr.Status.Archived {
+		return 0
+	}
+	return 1
+}
+
+// deploymentCommitID returns the commitID the given godoc deployment has
+// rolled out with the named fetch container, or an empty string if the rollout
+// is still in progress.
+func deploymentCommitID(dp *appsv1.Deployment, container string) string {
+	if dp.Status.ObservedGeneration < dp.Generation || dp.Status.UpdatedReplicas < dp.Status.Replicas {
+		return ""
+	}
+	c := initContainer(dp.Spec.Template.Spec.InitContainers, container)
+	if c == nil {
+		return ""
+	}
+	return fetchCommitID(c)
+}
+
 // deploymentForPullRequest creates a deployment object for a given PullRequest.
-func deploymentForPullRequest(pr *v1alpha1.PullRequest) (*appsv1.Deployment, error) {
-	// we are good with running with one replica
-	var replicas int32 = 1
+func (r *pullRequestReconciler) deploymentForPullRequest(pr *v1alpha1.PullRequest) (*appsv1.Deployment, error) {
+	replicas := godocReplicas(pr)
 
 	prinfo, err := parsePullRequestURL(pr.Spec.URL)
 	if err != nil {
 		return nil, err
 	}
 	prinfo.commitID = pr.Spec.CommitID
+	prinfo.modules = pr.Spec.Modules
+	prinfo.fetch = pr.Spec.Fetch
+	prinfo.importPaths = publishedImportPaths(pr)
 
 	labels := map[string]string{
-		"org":  prinfo.org,
+		"org":  strings.Replace(prinfo.org, "/", "-", -1),
 		"repo": prinfo.repo,
 	}
 
-	tunnelArgs := "80:localhost:6060"
-	tunnelArgs = fmt.Sprintf("%s:%s", prinfo.subdomain(), tunnelArgs)
-
 	dep := &appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "apps/v1",
@@ -170,35 +599,56 @@ func deploymentForPullRequest(pr *v1alpha1.PullRequest) (*appsv1.Deployment, err
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: v1.PodSpec{
-					Containers: []v1.Container{
-						{
-							Image:           "gcr.io/sunilarora-sandbox/godoc:0.0.1",
-							Name:            "godoc",
-							ImagePullPolicy: "Always",
-							Command:         []string{"/bin/bash"},
-							Args:            prinfo.godocContainerArgs(),
-						},
-						{
-							Image:           "gcr.io/sunilarora-sandbox/ssh-client:0.0.2",
-							Name:            "ssh",
-							ImagePullPolicy: "Always",
-							Command:         []string{"ssh"},
-							Args:            []string{"-tt", "-o", "StrictHostKeyChecking=no", "-R", tunnelArgs, "serveo.net"},
-						},
-					},
+					Labels: godocPodLabels(pr, prinfo),
 				},
+				Spec: r.godocPodSpec(pr, prinfo),
 			},
 		},
 	}
-	addOwnerRefToObject(dep, *metav1.NewControllerRef(pr, schema.GroupVersionKind{
+	addOwnerRefToObject(dep, pullRequestOwnerRef(pr))
+	return dep, nil
+}
+
+// publishedImportPaths returns the import paths of the modules whose links
+// are published for a previous commit of the PR, which the next commits
+// most likely document as well.
+func publishedImportPaths(pr *v1alpha1.PullRequest) []string {
+	var paths []string
+	for _, link := range pr.Status.GoDocLinks {
+		paths = append(paths, link.ImportPath)
+	}
+	return paths
+}
+
+// godocPodLabels returns the labels of the godoc pods of the PR. Besides the
+// labels selected by the deployment, the PR label lets pod crashes be noticed.
+func godocPodLabels(pr *v1alpha1.PullRequest, prinfo *prInfo) map[string]string {
+	return map[string]string{
+		"org":            strings.Replace(prinfo.org, "/", "-", -1),
+		"repo":           prinfo.repo,
+		pullRequestLabel: pr.Name,
+	}
+}
+
+// missingLabels returns true if labels lack any of the desired labels or
+// values.
+func missingLabels(labels, desired map[string]string) bool {
+	for k, v := range desired {
+		if value, found := labels[k]; !found || value != v {
+			return true
+		}
+	}
+	return false
+}
+
+// pullRequestOwnerRef returns the OwnerReference making pr the controller of
+// the objects generated for it.
+func pullRequestOwnerRef(pr *v1alpha1.PullRequest) metav1.OwnerReference {
+	return *metav1.NewControllerRef(pr, schema.GroupVersionKind{
 		Group:   v1alpha1.SchemeGroupVersion.Group,
 		Version: v1alpha1.SchemeGroupVersion.Version,
 		Kind:    "PullRequest",
-	}))
-	return dep, nil
+	})
 }
 
 // addOwnerRefToObject appends the desired OwnerReference to the object
@@ -209,44 +659,108 @@ func addOwnerRefToObject(obj metav1.Object, ownerRef metav1.OwnerReference) {
 // prInfo is an structure to represent PullRequest info. It will be used
 // internally to more as an convenience for passing it around.
 type prInfo struct {
+	// provider hosting the PR, e.g. ProviderGithub.
+	provider string
 	host     string
 	org      string
 	repo     string
 	pr       int64
 	commitID string
+	// modules are the module paths, or subdirectories of the repo, to
+	// document. All the modules are documented if empty.
+	modules []string
+	// importPaths are the documented import paths of the commit, read from
+	// its go.mod files. It is empty if they are not known.
+	importPaths []string
+	// fetch configures the checkout of private repos, it is nil for public
+	// ones.
+	fetch *v1alpha1.FetchSpec
 }
 
 // parsePullRequestURL parses given PullRequest URL into prInfo instance.
 // An example pull request URL looks like:
 // https://github.com/kubernetes-sigs/controller-runtime/pull/15
+// The URLs of GitLab merge requests and of Gitea and Bitbucket Server pull
+// requests are recognized as well.
 func parsePullRequestURL(prURL string) (*prInfo, error) {
 	u, err := url.Parse(prURL)
 	if err != nil {
 		return nil, err
 	}
 	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
-	if len(parts) < 4 || parts[2] != "pull" {
-		return nil, fmt.Errorf("pr info missing in the URL")
-	}
-
-	prNum, err := strconv.ParseInt(parts[3], 10, 64)
-	if err != nil {
-		return nil, err
-	}
-
-	return &prInfo{
-		host: u.Hostname(),
-		org:  parts[0],
-		repo: parts[1],
-		pr:   prNum,
-	}, nil
+	return parseProviderPath(u.Hostname(), parts)
 }
 
 // helper function to generate subdomain for the prinfo.
 func (pr *prInfo) subdomain() string {
-	return fmt.Sprintf("%s-%s-pr-%d", pr.org, pr.repo, pr.pr)
+	// GitLab orgs may be nested groups.
+	org := strings.Replace(pr.org, "/", "-", -1)
+	return fmt.Sprintf("%s-%s-pr-%d", org, pr.repo, pr.pr)
+}
+
+// name returns a valid K8s object name for the PullRequest object tracking
+// the PR.
+func (pr *prInfo) name() string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, strings.ToLower(pr.subdomain()))
+}
+
+// godocLinks returns the links to the godoc of the documented import paths of
+// the repo served at baseURL, under the given GOPATH prefix.
+func (pr *prInfo) godocLinks(baseURL, prefix string) []v1alpha1.ModuleLink {
+	var links []v1alpha1.ModuleLink
+	for _, importPath := range pr.documentedPaths() {
+		links = append(links, v1alpha1.ModuleLink{
+			ImportPath: importPath,
+			Link:       fmt.Sprintf("%s/pkg/%s", baseURL, path.Join(prefix, importPath)),
+		})
+	}
+	return links
 }
 
-func (pr *prInfo) godocContainerArgs() []string {
-	return []string{"fetch_serve.sh", pr.host, pr.org, pr.repo, strconv.FormatInt(pr.pr, 10), pr.commitID}
+// documentedPaths returns the documented import paths of the repo, its root
+// import path if they are not known.
+func (pr *prInfo) documentedPaths() []string {
+	if len(pr.importPaths) > 0 {
+		return pr.importPaths
+	}
+	return []string{path.Join(pr.host, pr.org, pr.repo)}
+}
+
+// fetchContainerArgs returns the arguments of godoc-fetch checking out the
+// commitID of the PR in the GOPATH whose src directory is srcDir, which is
+// left to the caller if empty. Repos without a go.mod file are checked out at
+// host/org/repo. The git credentials of private repos are read from the
+// credentials directory.
+func (pr *prInfo) fetchContainerArgs(srcDir, credentials string) []string {
+	args := []string{
+		"-clone-url", pr.cloneURL(),
+		"-refspec", pr.fetchRefspec(),
+		"-commit", pr.commitID,
+	}
+	if srcDir != "" {
+		args = append(args, "-src", srcDir)
+	}
+	args = append(args, "-import-path", path.Join(pr.host, pr.org, pr.repo))
+	if len(pr.modules) > 0 {
+		args = append(args, "-modules", strings.Join(pr.modules, ","))
+	}
+	if f := pr.fetch; f != nil {
+		if f.SecretName != "" {
+			args = append(args, "-credentials", credentials)
+		}
+		if f.GoPrivate != "" {
+			args = append(args, "-goprivate", f.GoPrivate)
+		}
+		if f.GoNoSumDB != "" {
+			args = append(args, "-gonosumdb", f.GoNoSumDB)
+		}
+	}
+	return args
 }
@@ -0,0 +1,352 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// gopathSrcVolume is the volume shared by the fetch and godoc
+	// containers, mounted at the src directory of their GOPATH.
+	gopathSrcVolume = "src"
+	gopathSrcDir    = "/go/src"
+
+	// fetchContainerName is the name of the fetch init container of the
+	// godoc pods and render jobs of a single PR.
+	fetchContainerName = "fetch"
+
+	// fetchFailedReason is the reason of the DeploymentAvailable condition
+	// of PRs whose commit could not be fetched.
+	fetchFailedReason = "FetchFailed"
+
+	// credentialsDir is the directory the Secret holding the git
+	// credentials of private repos is mounted at in the fetch containers.
+	credentialsDir = "/etc/godoc-fetch/credentials"
+
+	// secretNotFoundReason is the reason of the DeploymentAvailable
+	// condition of PRs whose git credentials Secret does not exist.
+	secretNotFoundReason = "SecretNotFound"
+)
+
+// fetchContainer returns the init container checking out the commitID of the
+// PR in the GOPATH shared with the godoc container, the src directory of the
+// GOPATH is srcDir. The commit is placed at its module path, which the
+// container writes to its termination message, or to its failure if the
+// commit cannot be fetched. The git credentials of private repos are mounted
+// from the volume returned by credentialsVolumes for the same name.
+func (r *pullRequestReconciler) fetchContainer(name string, prinfo *prInfo, srcDir string) v1.Container {
+	args := r.fetchArgs(prinfo, srcDir, credentialsDir)
+	mounts := []v1.VolumeMount{
+		{Name: gopathSrcVolume, MountPath: gopathSrcDir},
+	}
+	if prinfo.fetch != nil && prinfo.fetch.SecretName != "" {
+		mounts = append(mounts, v1.VolumeMount{Name: credentialsVolumeName(name), MountPath: credentialsDir, ReadOnly: true})
+	}
+	return v1.Container{
+		Image:           r.fetchImage,
+		Name:            name,
+		ImagePullPolicy: "Always",
+		Args:            args,
+		VolumeMounts:    mounts,
+	}
+}
+
+// fetchArgs returns the arguments of godoc-fetch checking out the commitID of
+// the PR, see prInfo.fetchContainerArgs, downloading the dependencies from the
+// GOPROXY of the PR or the default one.
+func (r *pullRequestReconciler) fetchArgs(prinfo *prInfo, srcDir, credentials string) []string {
+	args := prinfo.fetchContainerArgs(srcDir, credentials)
+	goproxy := r.goproxy
+	if prinfo.fetch != nil && prinfo.fetch.GoProxy != "" {
+		goproxy = prinfo.fetch.GoProxy
+	}
+	if goproxy != "" {
+		args = append(args, "-goproxy", goproxy)
+	}
+	return args
+}
+
+// credentialsVolumes returns the volume of the Secret holding the git
+// credentials of the PR mounted by the named fetch container, none for public
+// repos.
+func credentialsVolumes(name string, prinfo *prInfo) []v1.Volume {
+	if prinfo.fetch == nil || prinfo.fetch.SecretName == "" {
+		return nil
+	}
+	// the mode defaulted by the API server is set, so that the volumes of
+	// existing pods can be compared with the desired ones.
+	mode := int32(0400)
+	return []v1.Volume{{
+		Name: credentialsVolumeName(name),
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{SecretName: prinfo.fetch.SecretName, DefaultMode: &mode},
+		},
+	}}
+}
+
+// credentialsVolumeName returns the name of the volume of the git credentials
+// mounted by the named fetch container.
+func credentialsVolumeName(container string) string {
+	return container + "-credentials"
+}
+
+// fetchSecretFound returns true if the Secret holding the git credentials of
+// the PR exists, or if it has none.
+func (r *pullRequestReconciler) fetchSecretFound(ctx context.Context, pr *v1alpha1.PullRequest) (bool, error) {
+	if pr.Spec.Fetch == nil || pr.Spec.Fetch.SecretName == "" {
+		return true, nil
+	}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Spec.Fetch.SecretName}, &v1.Secret{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// fetchSecretField is the field the PullRequests are indexed by the name of
+// their git credentials Secret on.
+const fetchSecretField = "spec.fetch.secret_name"
+
+// indexFetchSecret indexes the PullRequests by the name of their git
+// credentials Secret, so that Secrets are mapped to their PullRequests
+// without listing all the PullRequests of the namespace.
+func indexFetchSecret(indexer client.FieldIndexer) error {
+	return indexer.IndexField(&v1alpha1.PullRequest{}, fetchSecretField, func(obj runtime.Object) []string {
+		pr := obj.(*v1alpha1.PullRequest)
+		if pr.Spec.Fetch == nil || pr.Spec.Fetch.SecretName == "" {
+			return nil
+		}
+		return []string{pr.Spec.Fetch.SecretName}
+	})
+}
+
+// pullRequestsForSecret maps a Secret to the PullRequests of its namespace
+// whose git credentials it holds.
+func (r *pullRequestReconciler) pullRequestsForSecret(obj handler.MapObject) []reconcile.Request {
+	list := &v1alpha1.PullRequestList{}
+	opts := client.InNamespace(obj.Meta.GetNamespace()).MatchingField(fetchSecretField, obj.Meta.GetName())
+	if err := r.Client.List(context.Background(), opts, list); err != nil {
+		log.Printf("error listing the PullRequests of secret %s/%s: %v", obj.Meta.GetNamespace(), obj.Meta.GetName(), err)
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, pr := range list.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name},
+		})
+	}
+	return requests
+}
+
+// godocContainer returns the container running the godoc server for the PR.
+// godoc keeps answering with errors until the packages of the repo are
+// indexed, so the readiness probe requests the package page of the first
+// documented module, see prInfo.documentedPaths.
+func (r *pullRequestReconciler) godocContainer(prinfo *prInfo) v1.Container {
+	return v1.Container{
+		Image:           "gcr.io/sunilarora-sandbox/godoc:0.0.1",
+		Name:            "godoc",
+		ImagePullPolicy: "Always",
+		Command:         []string{"godoc"},
+		Args:            []string{"-goroot", "/usr/local/go", fmt.Sprintf("-http=:%d", godocPort)},
+		Ports: []v1.ContainerPort{
+			{Name: "http", ContainerPort: godocPort},
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: gopathSrcVolume, MountPath: gopathSrcDir},
+		},
+		ReadinessProbe: httpProbe(fmt.Sprintf("/pkg/%s/", prinfo.documentedPaths()[0]), 10*time.Second),
+		// indexing large repos takes a while, godoc is only restarted if
+		// it stops answering after the initial delay.
+		LivenessProbe: httpProbe("/", r.livenessInitialDelay),
+		Resources:     r.resources,
+	}
+}
+
+// godocPodSpec returns the spec of the godoc pods of the PR, which serve the
+// HTML page of its API changes as well.
+func (r *pullRequestReconciler) godocPodSpec(pr *v1alpha1.PullRequest, prinfo *prInfo) v1.PodSpec {
+	spec := v1.PodSpec{
+		InitContainers: []v1.Container{r.fetchContainer(fetchContainerName, prinfo, gopathSrcDir)},
+		Containers:     append([]v1.Container{r.godocContainer(prinfo)}, r.exposer.Sidecars(prinfo)...),
+		Volumes: append([]v1.Volume{{
+			Name:         gopathSrcVolume,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		}}, credentialsVolumes(fetchContainerName, prinfo)...),
+	}
+	r.addAPIDiffPages(&spec, apiDiffPagesName(pr.Name))
+	return spec
+}
+
+// httpProbe returns a probe requesting path from the godoc server. All the
+// fields defaulted by the API server are set, so that the probes of existing
+// deployments can be compared with the desired ones.
+func httpProbe(path string, initialDelay time.Duration) *v1.Probe {
+	return &v1.Probe{
+		Handler: v1.Handler{
+			HTTPGet: &v1.HTTPGetAction{
+				Path:   path,
+				Port:   intstr.FromInt(godocPort),
+				Scheme: v1.URISchemeHTTP,
+			},
+		},
+		InitialDelaySeconds: int32(initialDelay / time.Second),
+		TimeoutSeconds:      5,
+		PeriodSeconds:       10,
+		SuccessThreshold:    1,
+		FailureThreshold:    3,
+	}
+}
+
+// godocPodChanged returns true if the godoc pod spec of an existing
+// deployment differs from the desired one in the fields managed by the
+// reconciler. Deployments created before the fetch init container was
+// introduced lack it along with the shared volume.
+func godocPodChanged(existing, desired *v1.PodSpec) bool {
+	if len(existing.InitContainers) != len(desired.InitContainers) || !reflect.DeepEqual(existing.Volumes, desired.Volumes) {
+		return true
+	}
+	for i := range desired.InitContainers {
+		fetch, desiredFetch := &existing.InitContainers[i], &desired.InitContainers[i]
+		if fetch.Name != desiredFetch.Name || fetch.Image != desiredFetch.Image || !reflect.DeepEqual(fetch.Args, desiredFetch.Args) {
+			return true
+		}
+	}
+	if sidecarsChanged(existing.Containers[1:], desired.Containers[1:]) {
+		return true
+	}
+	godoc, desiredGodoc := &existing.Containers[0], &desired.Containers[0]
+	return !reflect.DeepEqual(godoc.Command, desiredGodoc.Command) ||
+		!reflect.DeepEqual(godoc.Args, desiredGodoc.Args) ||
+		!reflect.DeepEqual(godoc.VolumeMounts, desiredGodoc.VolumeMounts) ||
+		!reflect.DeepEqual(godoc.ReadinessProbe, desiredGodoc.ReadinessProbe) ||
+		!reflect.DeepEqual(godoc.LivenessProbe, desiredGodoc.LivenessProbe) ||
+		!resourceListEqual(godoc.Resources.Requests, desiredGodoc.Resources.Requests) ||
+		!resourceListEqual(godoc.Resources.Limits, desiredGodoc.Resources.Limits)
+}
+
+// updateGodocPod copies the fields managed by the reconciler from the desired
+// godoc pod spec to an existing one.
+func updateGodocPod(existing, desired *v1.PodSpec) {
+	existing.InitContainers = desired.InitContainers
+	existing.Volumes = desired.Volumes
+	godoc, desiredGodoc := &existing.Containers[0], &desired.Containers[0]
+	godoc.Command = desiredGodoc.Command
+	godoc.Args = desiredGodoc.Args
+	godoc.VolumeMounts = desiredGodoc.VolumeMounts
+	godoc.ReadinessProbe = desiredGodoc.ReadinessProbe
+	godoc.LivenessProbe = desiredGodoc.LivenessProbe
+	godoc.Resources = desiredGodoc.Resources
+	existing.Containers = append(existing.Containers[:1], desired.Containers[1:]...)
+}
+
+// sidecarsChanged returns true if the exposer sidecars of an existing godoc
+// pod differ from the desired ones. Only the fields set by the exposers are
+// compared, the others are defaulted by the API server.
+func sidecarsChanged(existing, desired []v1.Container) bool {
+	if len(existing) != len(desired) {
+		return true
+	}
+	for i := range desired {
+		c, d := &existing[i], &desired[i]
+		if c.Name != d.Name || c.Image != d.Image ||
+			(d.ImagePullPolicy != "" && c.ImagePullPolicy != d.ImagePullPolicy) ||
+			!reflect.DeepEqual(c.Command, d.Command) ||
+			!reflect.DeepEqual(c.Args, d.Args) ||
+			!reflect.DeepEqual(c.Env, d.Env) ||
+			!reflect.DeepEqual(c.VolumeMounts, d.VolumeMounts) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchResult returns the import paths documented by the named fetch init
+// container of a pod matching the labels for the commitID of the PR, or the
+// failure of the container if none succeeded. Both are empty while the commit
+// is being fetched.
+func (r *pullRequestReconciler) fetchResult(ctx context.Context, pr *v1alpha1.PullRequest, labels map[string]string, container string) (paths []string, failure string, err error) {
+	pods := &v1.PodList{}
+	opts := client.InNamespace(pr.Namespace).MatchingLabels(labels)
+	if err := r.Client.List(ctx, opts, pods); err != nil {
+		return nil, "", err
+	}
+	for _, pod := range pods.Items {
+		if c := initContainer(pod.Spec.InitContainers, container); c == nil || fetchCommitID(c) != pr.Spec.CommitID {
+			// pods of previous commits may still be around.
+			continue
+		}
+		for _, cs := range pod.Status.InitContainerStatuses {
+			if cs.Name != container {
+				continue
+			}
+			t := cs.State.Terminated
+			if t == nil {
+				// the container is restarted after a failure.
+				t = cs.LastTerminationState.Terminated
+			}
+			switch {
+			case t == nil:
+			case t.ExitCode == 0:
+				return strings.Fields(t.Message), "", nil
+			case failure == "":
+				msg := strings.TrimSpace(t.Message)
+				if msg == "" {
+					msg = t.Reason
+				}
+				failure = fmt.Sprintf("fetching commit %s failed: %s", pr.Spec.CommitID, msg)
+			}
+		}
+	}
+	return nil, failure, nil
+}
+
+// initContainer returns the named init container, or nil if there is none.
+func initContainer(containers []v1.Container, name string) *v1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// fetchCommitID returns the commitID checked out by the fetch container.
+func fetchCommitID(c *v1.Container) string {
+	for i, arg := range c.Args {
+		if arg == "-commit" && i+1 < len(c.Args) {
+			return c.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// resourceListEqual compares resource lists by value, quantities read from
+// the API server are not necessarily represented the same way as the parsed
+// ones.
+func resourceListEqual(a, b v1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, qa := range a {
+		qb, found := b[name]
+		if !found || qa.Cmp(qb) != 0 {
+			return false
+		}
+	}
+	return true
+}
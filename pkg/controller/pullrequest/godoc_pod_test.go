@@ -0,0 +1,96 @@
+package pullrequest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGodocPodSidecars(t *testing.T) {
+	godoc := v1.Container{Name: "godoc", Image: "golang"}
+	tunnel := v1.Container{Name: "ssh", Image: "tunnel:1", ImagePullPolicy: "Always", Command: []string{"ssh"}, Args: []string{"-R", "pr-1:80:localhost:6060", "tunnel.example.com"}}
+	// the API server defaults the fields not set by the exposers.
+	defaulted := tunnel
+	defaulted.TerminationMessagePath = "/dev/termination-log"
+	newHost := tunnel
+	newHost.Args = []string{"-R", "pr-1:80:localhost:6060", "tunnel2.example.com"}
+
+	tests := []struct {
+		name     string
+		existing []v1.Container
+		desired  []v1.Container
+		want     bool
+	}{
+		{"same", []v1.Container{godoc, defaulted}, []v1.Container{godoc, tunnel}, false},
+		{"args", []v1.Container{godoc, defaulted}, []v1.Container{godoc, newHost}, true},
+		{"added", []v1.Container{godoc}, []v1.Container{godoc, tunnel}, true},
+		{"removed", []v1.Container{godoc, defaulted}, []v1.Container{godoc}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing := &v1.PodSpec{Containers: test.existing}
+			desired := &v1.PodSpec{Containers: test.desired}
+			if got := godocPodChanged(existing, desired); got != test.want {
+				t.Fatalf("got changed %v, want %v", got, test.want)
+			}
+			updateGodocPod(existing, desired)
+			if godocPodChanged(existing, desired) {
+				t.Errorf("pod still differs once updated: %+v", existing.Containers)
+			}
+		})
+	}
+}
+
+func TestPullRequestsForSecret(t *testing.T) {
+	private := trackedPullRequest(1, sha(1))
+	private.Spec.Fetch = &v1alpha1.FetchSpec{SecretName: "git-credentials"}
+	other := trackedPullRequest(2, sha(2))
+	other.Spec.Fetch = &v1alpha1.FetchSpec{SecretName: "other-credentials"}
+	otherNamespace := trackedPullRequest(3, sha(3))
+	otherNamespace.Namespace = "other"
+	otherNamespace.Spec.Fetch = private.Spec.Fetch
+	c := newFakeClient(private, other, otherNamespace, trackedPullRequest(4, sha(4)))
+	if err := indexFetchSecret(c); err != nil {
+		t.Fatal(err)
+	}
+	r := &pullRequestReconciler{Client: c}
+
+	secret := &v1.Secret{ObjectMeta: metaFor(syncerTestNamespace, "git-credentials")}
+	got := r.pullRequestsForSecret(handler.MapObject{Meta: secret, Object: secret})
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: syncerTestNamespace, Name: private.Name}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %v, want %v", got, want)
+	}
+}
+
+func TestGodocReadinessProbe(t *testing.T) {
+	r := &pullRequestReconciler{}
+	prinfo, err := parsePullRequestURL("https://github.com/kubernetes-sigs/kubebuilder/pull/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := func() *v1.HTTPGetAction {
+		c := r.godocContainer(prinfo)
+		if c.ReadinessProbe == nil || c.ReadinessProbe.HTTPGet == nil {
+			t.Fatalf("got readiness probe %+v, want an HTTP probe", c.ReadinessProbe)
+		}
+		return c.ReadinessProbe.HTTPGet
+	}
+	if got, want := probe().Path, "/pkg/github.com/kubernetes-sigs/kubebuilder/"; got != want {
+		t.Errorf("got path %s, want the import path of the repo %s", got, want)
+	}
+	// the module path of the repo is requested once it is known.
+	prinfo.importPaths = []string{"sigs.k8s.io/kubebuilder", "sigs.k8s.io/kubebuilder/tools"}
+	got := probe()
+	if want := "/pkg/sigs.k8s.io/kubebuilder/"; got.Path != want {
+		t.Errorf("got path %s, want the first module path %s", got.Path, want)
+	}
+	if got.Port.IntValue() != godocPort {
+		t.Errorf("got port %s, want %d", got.Port.String(), godocPort)
+	}
+}
@@ -0,0 +1,509 @@
+package pullrequest
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/droot/godocbot/pkg/godocsync"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// repositoryLabel is set on shared godoc pods to the name of their
+	// deployment.
+	repositoryLabel = "godocs.io/repository"
+
+	// syncContainerName is the name of the sidecar of the shared godoc pods
+	// checking out the commits of the PRs, syncPort the port it serves the
+	// status of the worktrees on.
+	syncContainerName = "sync"
+	syncPort          = 6061
+	// syncConfigVolume is the volume of the ConfigMap listing the PRs to
+	// the sync container, mounted at syncConfigDir.
+	syncConfigVolume = "sync-config"
+	syncConfigDir    = "/etc/godoc-sync"
+	// syncPollInterval is the interval the worktree of a PR is checked at
+	// until it is served, the sync container does not notify its progress.
+	syncPollInterval = 10 * time.Second
+)
+
+// reconcileShared ensures the godoc deployment shared by the PRs of the repo
+// of the PullRequest checks out its commitID next to the commits of the other
+// open PRs of the repo, and publishes its link once it is served. Each PR is
+// served at its own GOPATH prefix, e.g. /pkg/pr-123/github.com/org/repo.
+//
+// The PRs are listed in a ConfigMap mounted in the godoc pods, whose sync
+// container adds, updates and removes their worktrees as the list changes,
+// so that the pods are not restarted when PRs are opened, pushed to or
+// closed. They only are when the set of git credentials Secrets of the PRs
+// changes, since the Secrets are mounted in the pods.
+//
+// The deployment and ConfigMap are owned by all the PullRequest objects of
+// the repo, so that they are garbage collected along with the last of them,
+// and are updated by any of them whenever the set of open PRs or their
+// commits change.
+func (r *pullRequestReconciler) reconcileShared(ctx context.Context, pr, prCopy *v1alpha1.PullRequest) error {
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil {
+		log.Printf("error parsing the URL of PullRequest %s/%s: %v", pr.Namespace, pr.Name, err)
+		return nil
+	}
+	prinfo.commitID = pr.Spec.CommitID
+	prinfo.modules = pr.Spec.Modules
+	prinfo.fetch = pr.Spec.Fetch
+
+	prs, err := r.repositoryPullRequests(ctx, pr.Namespace, prinfo)
+	if err != nil {
+		return err
+	}
+	// the PRs whose git credentials are missing would keep the pods from
+	// starting.
+	missingSecret := map[string]bool{}
+	for _, other := range prs {
+		found, err := r.fetchSecretFound(ctx, other)
+		if err != nil {
+			return err
+		}
+		missingSecret[other.Name] = !found
+	}
+	served := servedPullRequests(prs, missingSecret)
+	if err := r.reconcileSharedConfigMap(ctx, r.sharedConfigMap(pr.Namespace, prinfo, prs, served)); err != nil {
+		return err
+	}
+	desired := r.sharedDeployment(pr.Namespace, prinfo, prs, served)
+
+	dp := &appsv1.Deployment{}
+	err = r.Client.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, dp)
+	if errors.IsNotFound(err) && pr.Status.Archived {
+		// godoc is not served for archived PRs
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "Archived", "godoc is not served for closed PRs")
+		setReadyCondition(&prCopy.Status)
+		return r.writeStatus(ctx, pr, prCopy)
+	}
+	// a deployment which has just been created or updated is not available
+	// until it has rolled out.
+	updated := false
+	if errors.IsNotFound(err) {
+		if err = r.Client.Create(ctx, desired); err != nil {
+			log.Printf("error creating the shared deployment %s/%s: %v", desired.Namespace, desired.Name, err)
+			return err
+		}
+		log.Printf("created shared deployment %s/%s successfully", desired.Namespace, desired.Name)
+		dp, updated = desired, true
+	} else if err != nil {
+		return err
+	} else if sharedDeploymentChanged(dp, desired) {
+		dpCopy := dp.DeepCopy()
+		updateGodocPod(&dpCopy.Spec.Template.Spec, &desired.Spec.Template.Spec)
+		dpCopy.Spec.Replicas = desired.Spec.Replicas
+		dpCopy.OwnerReferences = desired.OwnerReferences
+		if err = r.Client.Update(ctx, dpCopy); err != nil {
+			log.Printf("error updating the shared deployment %s/%s: %v", dp.Namespace, dp.Name, err)
+			return err
+		}
+		updated = true
+	}
+
+	selector := map[string]string{repositoryLabel: desired.Name}
+	if err = r.exposer.Expose(ctx, r.Client, pr, prinfo, selector); err != nil {
+		log.Printf("error exposing the shared deployment for pr %s/%s: %v", pr.Namespace, pr.Name, err)
+		return err
+	}
+	baseURL, err := r.exposer.BaseURL(ctx, r.Client, pr, prinfo)
+	if err != nil {
+		return err
+	}
+
+	available, fetchFailure := false, ""
+	if !pr.Status.Archived && !updated && dp.Status.AvailableReplicas > 0 && dp.Status.UnavailableReplicas == 0 {
+		var synced bool
+		if prinfo.importPaths, synced, fetchFailure, err = r.worktreeResult(ctx, pr, selector, prinfo.sharedPrefix()); err != nil {
+			return err
+		}
+		available = synced
+	}
+	switch {
+	case pr.Status.Archived:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "Archived", "godoc is not served for closed PRs")
+	case available:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionTrue, "Available", fmt.Sprintf("shared deployment %s serves commit %s", dp.Name, pr.Spec.CommitID))
+	case fetchFailure != "":
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, fetchFailedReason, fetchFailure)
+	default:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "RollingOut", fmt.Sprintf("shared deployment %s is checking out commit %s", dp.Name, pr.Spec.CommitID))
+		r.requeuer.after(pr, syncPollInterval)
+	}
+
+	// the pages of the API changes of the PRs of the repo share a
+	// ConfigMap owned by all of them as well, which keeps the pages of
+	// their PRs only.
+	var keys []string
+	for _, other := range prs {
+		if info, err := parsePullRequestURL(other.Spec.URL); err == nil {
+			keys = append(keys, apiDiffPageKey(info))
+		}
+	}
+	pages := apiDiffPages(pr.Namespace, desired.Name, sharedOwnerRefs(prs))
+	if err = r.reconcileAPIDiff(ctx, pr, prCopy, prinfo, baseURL, pages, keys); err != nil {
+		log.Printf("error publishing the API changes for pr %s/%s: %v", pr.Namespace, pr.Name, err)
+		return err
+	}
+
+	var links []v1alpha1.ModuleLink
+	if baseURL != "" {
+		links = prinfo.godocLinks(baseURL, prinfo.sharedPrefix())
+	}
+	return r.publishLink(ctx, pr, prCopy, prinfo, available, links)
+}
+
+// repositoryPullRequests returns the PullRequest objects of the namespace
+// whose PRs belong to the repo of prinfo, sorted by PR number.
+func (r *pullRequestReconciler) repositoryPullRequests(ctx context.Context, namespace string, prinfo *prInfo) ([]*v1alpha1.PullRequest, error) {
+	list := &v1alpha1.PullRequestList{}
+	if err := r.Client.List(ctx, client.InNamespace(namespace), list); err != nil {
+		return nil, err
+	}
+	var prs []*v1alpha1.PullRequest
+	numbers := map[*v1alpha1.PullRequest]int64{}
+	for i := range list.Items {
+		pr := &list.Items[i]
+		if pr.DeletionTimestamp != nil {
+			continue
+		}
+		info, err := parsePullRequestURL(pr.Spec.URL)
+		if err != nil || info.host != prinfo.host || info.org != prinfo.org || info.repo != prinfo.repo {
+			continue
+		}
+		prs = append(prs, pr)
+		numbers[pr] = info.pr
+	}
+	sort.Slice(prs, func(i, j int) bool { return numbers[prs[i]] < numbers[prs[j]] })
+	return prs, nil
+}
+
+// servedPullRequests returns the PRs of the repo whose commits are checked
+// out in the shared godoc deployment: the open PRs with a commitID, except
+// for the ones whose git credentials Secret is missing.
+func servedPullRequests(prs []*v1alpha1.PullRequest, missingSecret map[string]bool) []*prInfo {
+	var served []*prInfo
+	for _, pr := range prs {
+		if pr.Spec.CommitID == "" || pr.Status.Archived || missingSecret[pr.Name] {
+			continue
+		}
+		info, _ := parsePullRequestURL(pr.Spec.URL)
+		info.commitID = pr.Spec.CommitID
+		info.modules = pr.Spec.Modules
+		info.fetch = pr.Spec.Fetch
+		served = append(served, info)
+	}
+	return served
+}
+
+// sharedOwnerRefs returns the owner references of the objects shared by the
+// PRs, which have no controller.
+func sharedOwnerRefs(prs []*v1alpha1.PullRequest) []metav1.OwnerReference {
+	var ownerRefs []metav1.OwnerReference
+	for _, pr := range prs {
+		ref := pullRequestOwnerRef(pr)
+		ref.Controller = nil
+		ownerRefs = append(ownerRefs, ref)
+	}
+	return ownerRefs
+}
+
+// sharedConfigMap returns the ConfigMap listing the served PRs to the sync
+// container of the shared godoc deployment, see pkg/godocsync. It holds the
+// godoc-fetch arguments of each PR, one per line, keyed by its GOPATH prefix.
+func (r *pullRequestReconciler) sharedConfigMap(namespace string, prinfo *prInfo, prs []*v1alpha1.PullRequest, served []*prInfo) *v1.ConfigMap {
+	data := map[string]string{}
+	for _, info := range served {
+		credentials := ""
+		if info.fetch != nil && info.fetch.SecretName != "" {
+			credentials = path.Join(credentialsDir, info.fetch.SecretName)
+		}
+		data[info.sharedPrefix()] = strings.Join(r.fetchArgs(info, "", credentials), "\n")
+	}
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            prinfo.sharedName(),
+			Namespace:       namespace,
+			OwnerReferences: sharedOwnerRefs(prs),
+		},
+		Data: data,
+	}
+}
+
+// reconcileSharedConfigMap creates the desired ConfigMap, or updates the
+// existing one if the PRs it lists changed.
+func (r *pullRequestReconciler) reconcileSharedConfigMap(ctx context.Context, desired *v1.ConfigMap) error {
+	cm := &v1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, cm)
+	if errors.IsNotFound(err) {
+		log.Printf("creating the shared ConfigMap %s/%s", desired.Namespace, desired.Name)
+		return r.Client.Create(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(cm.Data, desired.Data) && ownerRefsEqual(cm.OwnerReferences, desired.OwnerReferences) {
+		return nil
+	}
+	cmCopy := cm.DeepCopy()
+	cmCopy.Data = desired.Data
+	cmCopy.OwnerReferences = desired.OwnerReferences
+	if err := r.Client.Update(ctx, cmCopy); err != nil {
+		log.Printf("error updating the shared ConfigMap %s/%s: %v", cm.Namespace, cm.Name, err)
+		return err
+	}
+	return nil
+}
+
+// sharedDeployment returns the godoc deployment shared by the given PRs of the
+// repo of prinfo. The served PRs are checked out by its sync container from
+// the ConfigMap of the same name, with the git credentials of the Secrets
+// mounted in it. The deployment is scaled down to zero when no PR is served.
+func (r *pullRequestReconciler) sharedDeployment(namespace string, prinfo *prInfo, prs []*v1alpha1.PullRequest, served []*prInfo) *appsv1.Deployment {
+	name := prinfo.sharedName()
+	labels := map[string]string{repositoryLabel: name}
+
+	configMode := int32(0644)
+	podSpec := v1.PodSpec{
+		Volumes: []v1.Volume{
+			{
+				Name:         gopathSrcVolume,
+				VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+			},
+			{
+				Name: syncConfigVolume,
+				VolumeSource: v1.VolumeSource{
+					ConfigMap: &v1.ConfigMapVolumeSource{
+						LocalObjectReference: v1.LocalObjectReference{Name: name},
+						DefaultMode:          &configMode,
+					},
+				},
+			},
+		},
+	}
+	sync := r.syncContainer()
+	for _, secret := range sharedSecrets(served) {
+		volume := sharedCredentialsVolumeName(secret)
+		// the mode defaulted by the API server is set, so that the
+		// volumes of existing pods can be compared with the desired ones.
+		mode := int32(0400)
+		podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+			Name: volume,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{SecretName: secret, DefaultMode: &mode},
+			},
+		})
+		sync.VolumeMounts = append(sync.VolumeMounts, v1.VolumeMount{Name: volume, MountPath: path.Join(credentialsDir, secret), ReadOnly: true})
+	}
+	// the readiness probe does not depend on the served PRs, so that they do
+	// not restart the pods. Their worktrees are checked through the sync
+	// container instead.
+	godoc := r.godocContainer(prinfo)
+	godoc.ReadinessProbe = httpProbe("/", 10*time.Second)
+	podSpec.Containers = append([]v1.Container{godoc, sync}, r.exposer.Sidecars(prinfo)...)
+	r.addAPIDiffPages(&podSpec, apiDiffPagesName(name))
+
+	replicas := int32(1)
+	if len(served) == 0 {
+		replicas = 0
+	}
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: sharedOwnerRefs(prs),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
+
+// syncContainer returns the sidecar of the shared godoc pods running
+// cmd/godoc-sync, which checks out the PRs listed in the ConfigMap mounted at
+// syncConfigDir.
+func (r *pullRequestReconciler) syncContainer() v1.Container {
+	return v1.Container{
+		Image:           r.fetchImage,
+		Name:            syncContainerName,
+		ImagePullPolicy: "Always",
+		Command:         []string{"godoc-sync"},
+		Args:            []string{"-config", syncConfigDir, "-src", gopathSrcDir, "-addr", fmt.Sprintf(":%d", syncPort)},
+		Ports: []v1.ContainerPort{
+			{Name: "sync", ContainerPort: syncPort, Protocol: v1.ProtocolTCP},
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: gopathSrcVolume, MountPath: gopathSrcDir},
+			{Name: syncConfigVolume, MountPath: syncConfigDir, ReadOnly: true},
+		},
+	}
+}
+
+// sharedSecrets returns the sorted names of the git credentials Secrets of
+// the served PRs.
+func sharedSecrets(served []*prInfo) []string {
+	var secrets []string
+	found := map[string]bool{}
+	for _, info := range served {
+		if info.fetch == nil || info.fetch.SecretName == "" || found[info.fetch.SecretName] {
+			continue
+		}
+		found[info.fetch.SecretName] = true
+		secrets = append(secrets, info.fetch.SecretName)
+	}
+	sort.Strings(secrets)
+	return secrets
+}
+
+// sharedCredentialsVolumeName returns the name of the volume of the git
+// credentials Secret in the shared godoc pods. Secret names may be longer
+// than volume names, so they are hashed.
+func sharedCredentialsVolumeName(secret string) string {
+	h := fnv.New32a()
+	h.Write([]byte(secret))
+	return fmt.Sprintf("credentials-%08x", h.Sum32())
+}
+
+// worktreeResult returns the import paths documented by the worktree of the
+// prefix in a running pod matching the labels, and whether it is synced with
+// the commitID of the PR, or the failure to check out the commit. All are
+// empty while the commit is being checked out.
+func (r *pullRequestReconciler) worktreeResult(ctx context.Context, pr *v1alpha1.PullRequest, labels map[string]string, prefix string) (paths []string, synced bool, failure string, err error) {
+	pods := &v1.PodList{}
+	opts := client.InNamespace(pr.Namespace).MatchingLabels(labels)
+	if err := r.Client.List(ctx, opts, pods); err != nil {
+		return nil, false, "", err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		statuses, err := r.worktreeStatuses(ctx, pod)
+		if err != nil {
+			// the sync container may be restarting.
+			log.Printf("error reading the worktrees of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		status, found := statuses[prefix]
+		if !found || status.CommitID != pr.Spec.CommitID {
+			continue
+		}
+		if status.Failure != "" {
+			failure = fmt.Sprintf("fetching commit %s failed: %s", pr.Spec.CommitID, status.Failure)
+			continue
+		}
+		return status.ImportPaths, true, "", nil
+	}
+	return nil, false, failure, nil
+}
+
+// httpWorktreeStatuses returns the statuses of the worktrees of a shared godoc
+// pod served by its sync container.
+func httpWorktreeStatuses(ctx context.Context, pod *v1.Pod) (map[string]godocsync.Status, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/", pod.Status.PodIP, syncPort), nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Client{Timeout: 5 * time.Second}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	statuses := map[string]godocsync.Status{}
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// sharedDeploymentChanged returns true if an existing shared deployment
+// differs from the desired one in the fields managed by the reconciler.
+func sharedDeploymentChanged(existing, desired *appsv1.Deployment) bool {
+	return godocPodChanged(&existing.Spec.Template.Spec, &desired.Spec.Template.Spec) ||
+		existing.Spec.Replicas == nil || *existing.Spec.Replicas != *desired.Spec.Replicas ||
+		!ownerRefsEqual(existing.OwnerReferences, desired.OwnerReferences)
+}
+
+// ownerRefsEqual compares the UIDs of the owners.
+func ownerRefsEqual(a, b []metav1.OwnerReference) bool {
+	uids := func(refs []metav1.OwnerReference) []string {
+		var s []string
+		for _, ref := range refs {
+			s = append(s, string(ref.UID))
+		}
+		sort.Strings(s)
+		return s
+	}
+	return reflect.DeepEqual(uids(a), uids(b))
+}
+
+// sharedName returns the name of the godoc deployment and ConfigMap shared by
+// the PRs of the repo, which is used as a label value as well. It includes
+// the host, for the repos of the same org and name on several hosts. Names
+// too long for a label are shortened with a hash of the repo, as
+// serviceName does, so that the repos sharing a prefix get distinct names.
+func (pr *prInfo) sharedName() string {
+	repo := strings.ToLower(path.Join(pr.host, pr.org, pr.repo))
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, "godoc-"+repo)
+	if len(name) > validation.DNS1035LabelMaxLength {
+		sum := sha1.Sum([]byte(repo))
+		name = strings.TrimRight(name[:validation.DNS1035LabelMaxLength-9], "-") + "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return strings.TrimSuffix(name, "-")
+}
+
+// sharedPrefix returns the GOPATH prefix the PR is checked out at in the
+// shared godoc deployment.
+func (pr *prInfo) sharedPrefix() string {
+	return fmt.Sprintf("pr-%d", pr.pr)
+}
@@ -0,0 +1,166 @@
+package pullrequest
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/droot/godocbot/pkg/godocsync"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const sharedTestName = "godoc-github-com-kubernetes-sigs-kubebuilder"
+
+func TestReconcileShared(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(trackedPullRequest(1, sha(1)), trackedPullRequest(2, sha(2)))
+	statuses := map[string]godocsync.Status{}
+	r := &pullRequestReconciler{
+		Client:       c,
+		statusWriter: c,
+		exposer:      &nodePortExposer{nodeHost: "nodes.example.com"},
+		shared:       true,
+		fetchImage:   "godoc-fetch",
+		requeuer:     newRequeuer(),
+		worktreeStatuses: func(ctx context.Context, pod *v1.Pod) (map[string]godocsync.Status, error) {
+			return statuses, nil
+		},
+	}
+	reconcileOnce := func(number int) *v1alpha1.PullRequest {
+		pr := trackedPullRequest(number, "")
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}}
+		if _, err := r.Reconcile(request); err != nil {
+			t.Fatal(err)
+		}
+		return c.pullRequest(pr.Namespace, pr.Name)
+	}
+	key := types.NamespacedName{Namespace: syncerTestNamespace, Name: sharedTestName}
+	shared := func() (*appsv1.Deployment, *v1.ConfigMap) {
+		dp, cm := &appsv1.Deployment{}, &v1.ConfigMap{}
+		if err := c.Get(ctx, key, dp); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, key, cm); err != nil {
+			t.Fatal(err)
+		}
+		return dp, cm
+	}
+	prefixes := func(cm *v1.ConfigMap) []string {
+		var keys []string
+		for _, prefix := range []string{"pr-1", "pr-2", "pr-3"} {
+			if _, found := cm.Data[prefix]; found {
+				keys = append(keys, prefix)
+			}
+		}
+		return keys
+	}
+
+	reconcileOnce(1)
+	dp, cm := shared()
+	if got, want := prefixes(cm), []string{"pr-1", "pr-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got PRs %v in the ConfigMap, want %v", got, want)
+	}
+	if n := len(dp.Spec.Template.Spec.InitContainers); n != 0 {
+		t.Errorf("got %d init containers, want the PRs to be synced by a sidecar", n)
+	}
+	template := dp.Spec.Template.DeepCopy()
+
+	// opening and closing PRs updates the ConfigMap alone, the pods keep
+	// running.
+	if err := c.Create(ctx, trackedPullRequest(3, sha(3))); err != nil {
+		t.Fatal(err)
+	}
+	closed := c.pullRequest(syncerTestNamespace, "kubebuilder-pr-2")
+	closed.Status.Archived = true
+	if err := c.UpdateStatus(ctx, closed); err != nil {
+		t.Fatal(err)
+	}
+	reconcileOnce(3)
+	dp, cm = shared()
+	if got, want := prefixes(cm), []string{"pr-1", "pr-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got PRs %v in the ConfigMap, want %v", got, want)
+	}
+	if !reflect.DeepEqual(&dp.Spec.Template, template) {
+		t.Errorf("pod template changed along with the PRs:\n%+v\nwant\n%+v", dp.Spec.Template, template)
+	}
+
+	// a PR is available once the sync container checked out its commit.
+	dp.Status.AvailableReplicas = 1
+	if err := c.Update(ctx, dp); err != nil {
+		t.Fatal(err)
+	}
+	pod := &v1.Pod{ObjectMeta: metaFor(syncerTestNamespace, sharedTestName+"-abcde")}
+	pod.Labels = map[string]string{repositoryLabel: sharedTestName}
+	pod.Status = v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.0.0.1"}
+	if err := c.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	svc := &v1.Service{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: syncerTestNamespace, Name: serviceName(trackedPullRequest(1, ""))}, svc); err != nil {
+		t.Fatal(err)
+	}
+	svc.Spec.Ports[0].NodePort = 30001
+	if err := c.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	statuses["pr-1"] = godocsync.Status{CommitID: sha(0)}
+	if cond := reconcileOnce(1).Status.GetCondition(v1alpha1.DeploymentAvailable); cond == nil || cond.Status != v1.ConditionFalse {
+		t.Errorf("got condition %+v while the previous commit is served, want it false", cond)
+	}
+	statuses["pr-1"] = godocsync.Status{CommitID: sha(1), ImportPaths: []string{"sigs.k8s.io/kubebuilder"}}
+	pr := reconcileOnce(1)
+	if !pr.Status.IsConditionTrue(v1alpha1.DeploymentAvailable) {
+		t.Errorf("got condition %+v once the commit is served, want it true", pr.Status.GetCondition(v1alpha1.DeploymentAvailable))
+	}
+	if want := "http://nodes.example.com:30001/pkg/pr-1/sigs.k8s.io/kubebuilder"; pr.Status.GoDocLink != want {
+		t.Errorf("got link %s, want %s", pr.Status.GoDocLink, want)
+	}
+
+	statuses["pr-3"] = godocsync.Status{CommitID: sha(3), Failure: "repository not found"}
+	if cond := reconcileOnce(3).Status.GetCondition(v1alpha1.DeploymentAvailable); cond == nil || cond.Reason != fetchFailedReason {
+		t.Errorf("got condition %+v for a failed checkout, want reason %s", cond, fetchFailedReason)
+	}
+}
+
+func TestSharedName(t *testing.T) {
+	github, err := parsePullRequestURL("https://github.com/kubernetes-sigs/kubebuilder/pull/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enterprise, err := parsePullRequestURL("https://github.example.com/kubernetes-sigs/kubebuilder/pull/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if github.sharedName() != sharedTestName {
+		t.Errorf("got name %s, want %s", github.sharedName(), sharedTestName)
+	}
+	if github.sharedName() == enterprise.sharedName() {
+		t.Errorf("repos of different hosts share the name %s", github.sharedName())
+	}
+
+	// repos whose names only differ past the length of a label get
+	// distinct names.
+	long := strings.Repeat("a", 70)
+	first, err := parsePullRequestURL("https://github.com/org/" + long + "-first/pull/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := parsePullRequestURL("https://github.com/org/" + long + "-second/pull/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{first.sharedName(), second.sharedName()} {
+		if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+			t.Errorf("got invalid name %s: %v", name, errs)
+		}
+	}
+	if first.sharedName() == second.sharedName() {
+		t.Errorf("repos sharing a prefix share the name %s", first.sharedName())
+	}
+}
@@ -0,0 +1,467 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// staticOutDir is where the render container writes the HTML for the
+	// upload container.
+	staticOutDir = "/out"
+	// staticMountDir is where the PVC is mounted in the upload container.
+	staticMountDir = "/static"
+
+	// renderFailedReason is the reason of the DeploymentAvailable condition
+	// of PRs whose render job failed.
+	renderFailedReason = "RenderFailed"
+
+	// staticCleanupFinalizer keeps deleted PullRequests around until a
+	// cleanup job has removed their HTML from the PVC or bucket.
+	staticCleanupFinalizer = "code.godocs.io/static-cleanup"
+)
+
+// StaticConfig configures rendering godoc to static HTML. The HTML of the
+// latest commit is stored under <namespace>/<name>/<commitID>/ of the
+// PullRequest in either a PersistentVolumeClaim or an S3 compatible bucket,
+// from which a single static server serves all the previews. The HTML of the
+// previous commits is removed once the latest one is stored, and the HTML of
+// deleted PullRequests by a cleanup job.
+type StaticConfig struct {
+	// BaseURL the static server serves the stored HTML at, e.g.
+	// https://docs.example.com.
+	BaseURL string
+	// RenderImage is the godoc image rendering the HTML with
+	// render_static.sh.
+	RenderImage string
+	// PVC is the name of the PersistentVolumeClaim the HTML is copied to, it
+	// must exist in the namespaces of the PullRequests and be mountable by
+	// many pods.
+	PVC string
+	// S3Endpoint and S3Bucket locate the bucket the HTML is uploaded to when
+	// PVC is empty, e.g. http://minio.minio:9000 and godoc.
+	S3Endpoint string
+	S3Bucket   string
+	// S3CredentialsSecret is the name of the Secret holding the access-key
+	// and secret-key of the bucket, in the namespaces of the PullRequests.
+	S3CredentialsSecret string
+}
+
+// Validate returns an error if the configuration is incomplete.
+func (c *StaticConfig) Validate() error {
+	switch {
+	case c.BaseURL == "":
+		return fmt.Errorf("static godoc needs a base URL")
+	case c.RenderImage == "":
+		return fmt.Errorf("static godoc needs a render image")
+	case c.PVC == "" && (c.S3Endpoint == "" || c.S3Bucket == "" || c.S3CredentialsSecret == ""):
+		return fmt.Errorf("static godoc needs either a PVC or an S3 endpoint, bucket and credentials secret")
+	case c.PVC != "" && c.S3Endpoint != "":
+		return fmt.Errorf("static godoc is stored either in a PVC or in S3, not both")
+	}
+	return nil
+}
+
+// prefix returns where the HTML of the commitID of the PR is stored.
+func (c *StaticConfig) prefix(pr *v1alpha1.PullRequest) string {
+	return path.Join(c.prPrefix(pr), pr.Spec.CommitID)
+}
+
+// prPrefix returns where the HTML of all the commits of the PR is stored.
+func (c *StaticConfig) prPrefix(pr *v1alpha1.PullRequest) string {
+	return path.Join(pr.Namespace, pr.Name)
+}
+
+// url returns the URL the static server serves the HTML of the commitID of
+// the PR at.
+func (c *StaticConfig) url(pr *v1alpha1.PullRequest) string {
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + c.prefix(pr)
+}
+
+// links returns the godoc links of the documented modules of the HTML of the
+// commitID of the PR.
+func (c *StaticConfig) links(pr *v1alpha1.PullRequest, prinfo *prInfo) []v1alpha1.ModuleLink {
+	links := prinfo.godocLinks(c.url(pr), "")
+	for i := range links {
+		links[i].Link += "/"
+	}
+	return links
+}
+
+// reconcileStatic ensures a job has rendered the HTML of the commitID of the
+// PullRequest, and publishes its link once it has. Jobs of previous commits
+// are deleted, the HTML they rendered is removed by the job of the new
+// commit.
+func (r *pullRequestReconciler) reconcileStatic(ctx context.Context, pr, prCopy *v1alpha1.PullRequest) error {
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil {
+		log.Printf("error parsing the URL of PullRequest %s/%s: %v", pr.Namespace, pr.Name, err)
+		return nil
+	}
+	prinfo.commitID = pr.Spec.CommitID
+	prinfo.modules = pr.Spec.Modules
+	prinfo.fetch = pr.Spec.Fetch
+
+	name := staticJobName(pr)
+	if err = r.deleteStaleJobs(ctx, pr, map[string]string{pullRequestLabel: pr.Name}, name); err != nil {
+		return err
+	}
+
+	job := &batchv1.Job{}
+	err = r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: name}, job)
+	if errors.IsNotFound(err) && pr.Status.Archived {
+		// godoc is not rendered for archived PRs
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "Archived", "godoc is not rendered for closed PRs")
+		setReadyCondition(&prCopy.Status)
+		return r.writeStatus(ctx, pr, prCopy)
+	}
+	if errors.IsNotFound(err) {
+		job = r.staticJob(pr, prinfo, name)
+		if err = r.Client.Create(ctx, job); err != nil {
+			log.Printf("error creating the render job for PullRequest %s/%s: %v", pr.Namespace, pr.Name, err)
+			return err
+		}
+		log.Printf("created render job %s for %s/%s successfully", name, pr.Namespace, pr.Name)
+	} else if err != nil {
+		return err
+	}
+
+	available := job.Status.Succeeded > 0
+	if available && r.apiDiffEnabled(pr) {
+		if err = r.readAPIDiff(ctx, pr, prCopy, job); err != nil {
+			return err
+		}
+	}
+	fetchFailure := ""
+	prinfo.importPaths, fetchFailure, err = r.fetchResult(ctx, pr, map[string]string{pullRequestLabel: pr.Name}, fetchContainerName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case available:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionTrue, "Available", fmt.Sprintf("job %s rendered commit %s", job.Name, pr.Spec.CommitID))
+	case fetchFailure != "":
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, fetchFailedReason, fetchFailure)
+	case jobFailed(job):
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, renderFailedReason, fmt.Sprintf("job %s failed to render commit %s", job.Name, pr.Spec.CommitID))
+	default:
+		prCopy.Status.SetCondition(v1alpha1.DeploymentAvailable, v1.ConditionFalse, "Rendering", fmt.Sprintf("job %s is rendering commit %s", job.Name, pr.Spec.CommitID))
+	}
+
+	if err = r.publishLink(ctx, pr, prCopy, prinfo, available, r.static.links(pr, prinfo)); err != nil {
+		log.Printf("error publishing the godoc link for pr %s/%s: %v", pr.Namespace, pr.Name, err)
+		return err
+	}
+	return nil
+}
+
+// deleteStaleJobs deletes the jobs of the PR matching the labels other than
+// the named one, along with their pods.
+func (r *pullRequestReconciler) deleteStaleJobs(ctx context.Context, pr *v1alpha1.PullRequest, labels map[string]string, name string) error {
+	opts := client.InNamespace(pr.Namespace).MatchingLabels(labels)
+	jobs := &batchv1.JobList{}
+	if err := r.Client.List(ctx, opts, jobs); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Name == name || !metav1.IsControlledBy(job, pr) {
+			continue
+		}
+		log.Printf("deleting job %s of a previous commit of %s/%s", job.Name, pr.Namespace, pr.Name)
+		if err := r.jobDeleter.DeleteJob(ctx, job); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalizeStatic adds staticCleanupFinalizer to the PullRequests whose HTML
+// is stored, and removes the HTML of the deleted ones with a cleanup job
+// before letting them go. It returns true if the PullRequest is being
+// deleted.
+func (r *pullRequestReconciler) finalizeStatic(ctx context.Context, pr *v1alpha1.PullRequest) (bool, error) {
+	finalized := containsString(pr.Finalizers, staticCleanupFinalizer)
+	if pr.DeletionTimestamp == nil {
+		if r.static == nil || finalized {
+			return false, nil
+		}
+		pr.Finalizers = append(pr.Finalizers, staticCleanupFinalizer)
+		return false, r.Client.Update(ctx, pr)
+	}
+	if !finalized {
+		return true, nil
+	}
+	if r.static == nil {
+		// godoc is no longer rendered to static HTML, there is nothing
+		// to clean up with.
+		log.Printf("leaving the static HTML of deleted PullRequest %s/%s behind, godoc is not static anymore", pr.Namespace, pr.Name)
+		return true, r.removeFinalizer(ctx, pr)
+	}
+
+	// no render job may store HTML once it has been removed.
+	if err := r.deleteStaleJobs(ctx, pr, map[string]string{pullRequestLabel: pr.Name}, ""); err != nil {
+		return true, err
+	}
+	name := staticCleanupJobName(pr)
+	job := &batchv1.Job{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: name}, job)
+	if errors.IsNotFound(err) {
+		log.Printf("creating cleanup job %s for deleted PullRequest %s/%s", name, pr.Namespace, pr.Name)
+		return true, r.Client.Create(ctx, r.staticCleanupJob(pr, name))
+	}
+	if err != nil {
+		return true, err
+	}
+	switch {
+	case job.Status.Succeeded > 0:
+	case jobFailed(job):
+		log.Printf("cleanup job %s failed, leaving the static HTML of deleted PullRequest %s/%s behind", name, pr.Namespace, pr.Name)
+	default:
+		// the job is still running, it is watched.
+		return true, nil
+	}
+	// the job is owned by the PullRequest and goes away with it.
+	return true, r.removeFinalizer(ctx, pr)
+}
+
+// removeFinalizer removes staticCleanupFinalizer from the PullRequest.
+func (r *pullRequestReconciler) removeFinalizer(ctx context.Context, pr *v1alpha1.PullRequest) error {
+	var finalizers []string
+	for _, f := range pr.Finalizers {
+		if f != staticCleanupFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	pr.Finalizers = finalizers
+	return r.Client.Update(ctx, pr)
+}
+
+// jobFailed returns true if the job has given up.
+func jobFailed(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// staticJobName returns the name of the job rendering the commitID of the PR,
+// compared with its base commit if it is known.
+func staticJobName(pr *v1alpha1.PullRequest) string {
+	return commitJobName(pr, "")
+}
+
+// commitJobName returns the name of a job of the commitID of the PR and of
+// its base commit if it is known, the kind of job telling apart the jobs of
+// the same commits.
+func commitJobName(pr *v1alpha1.PullRequest, kind string) string {
+	short := func(commit string, n int) string {
+		if len(commit) > n {
+			commit = commit[:n]
+		}
+		return strings.ToLower(commit)
+	}
+	suffix := kind + "-" + short(pr.Spec.CommitID, 10)
+	if pr.Spec.BaseCommitID != "" {
+		suffix += "-" + short(pr.Spec.BaseCommitID, 7)
+	}
+	// job names end up in the labels of their pods, which are limited to 63
+	// characters.
+	name := pr.Name
+	if max := 63 - len(suffix); len(name) > max {
+		name = strings.TrimSuffix(name[:max], "-")
+	}
+	return name + suffix
+}
+
+// staticCleanupJobName returns the name of the job removing the HTML of the
+// deleted PR.
+func staticCleanupJobName(pr *v1alpha1.PullRequest) string {
+	const suffix = "-cleanup"
+	name := pr.Name
+	if max := 63 - len(suffix); len(name) > max {
+		name = strings.TrimSuffix(name[:max], "-")
+	}
+	return name + suffix
+}
+
+// staticCleanupJob returns the job removing the HTML of all the commits of
+// the deleted PR. It carries none of the labels of the render jobs, so it is
+// never taken for one of them.
+func (r *pullRequestReconciler) staticCleanupJob(pr *v1alpha1.PullRequest, name string) *batchv1.Job {
+	backoffLimit := int32(2)
+	prPrefix := r.static.prPrefix(pr)
+	container, volumes := r.static.storageContainer("cleanup",
+		fmt.Sprintf("rm -rf %s", path.Join(staticMountDir, prPrefix)),
+		fmt.Sprintf(`mc rm -r --force "store/$S3_BUCKET/%s/"`, prPrefix))
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pr.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers:    []v1.Container{container},
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+	addOwnerRefToObject(job, pullRequestOwnerRef(pr))
+	return job
+}
+
+// storageContainer returns the container running a script against the stored
+// HTML, pvcScript with the PVC mounted at staticMountDir or s3Script with the
+// bucket aliased as store, along with the volumes it needs besides the given
+// mounts.
+func (c *StaticConfig) storageContainer(name, pvcScript, s3Script string, mounts ...v1.VolumeMount) (v1.Container, []v1.Volume) {
+	if c.PVC != "" {
+		container := v1.Container{
+			Name:         name,
+			Image:        "busybox",
+			Command:      []string{"/bin/sh", "-c"},
+			Args:         []string{pvcScript},
+			VolumeMounts: append(mounts, v1.VolumeMount{Name: "static", MountPath: staticMountDir}),
+		}
+		return container, []v1.Volume{{
+			Name: "static",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: c.PVC},
+			},
+		}}
+	}
+	secretEnv := func(name, key string) v1.EnvVar {
+		return v1.EnvVar{
+			Name: name,
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: c.S3CredentialsSecret},
+					Key:                  key,
+				},
+			},
+		}
+	}
+	return v1.Container{
+		Name:    name,
+		Image:   "minio/mc",
+		Command: []string{"/bin/sh", "-c"},
+		Args:    []string{`mc alias set store "$S3_ENDPOINT" "$S3_ACCESS_KEY" "$S3_SECRET_KEY" && ` + s3Script},
+		Env: []v1.EnvVar{
+			{Name: "S3_ENDPOINT", Value: c.S3Endpoint},
+			{Name: "S3_BUCKET", Value: c.S3Bucket},
+			secretEnv("S3_ACCESS_KEY", "access-key"),
+			secretEnv("S3_SECRET_KEY", "secret-key"),
+		},
+		VolumeMounts: mounts,
+	}, nil
+}
+
+// staticJob returns the job checking out the commitID of the PR and rendering
+// it to HTML in init containers, and storing it from the main container, which
+// then removes the HTML of the previous commits.
+func (r *pullRequestReconciler) staticJob(pr *v1alpha1.PullRequest, prinfo *prInfo, name string) *batchv1.Job {
+	backoffLimit := int32(2)
+	labels := map[string]string{
+		pullRequestLabel: pr.Name,
+	}
+	out := v1.VolumeMount{Name: "out", MountPath: staticOutDir}
+	src := v1.VolumeMount{Name: gopathSrcVolume, MountPath: gopathSrcDir}
+
+	podSpec := v1.PodSpec{
+		RestartPolicy: v1.RestartPolicyNever,
+		InitContainers: []v1.Container{
+			r.fetchContainer(fetchContainerName, prinfo, gopathSrcDir),
+			{
+				Name:         "render",
+				Image:        r.static.RenderImage,
+				Command:      []string{"/bin/bash"},
+				Args:         []string{"render_static.sh", staticOutDir},
+				Resources:    r.resources,
+				VolumeMounts: []v1.VolumeMount{src, out},
+			},
+		},
+		Volumes: []v1.Volume{
+			{
+				Name:         gopathSrcVolume,
+				VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+			},
+			{
+				Name:         "out",
+				VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+			},
+		},
+	}
+	podSpec.Volumes = append(podSpec.Volumes, credentialsVolumes(fetchContainerName, prinfo)...)
+	if r.apiDiffEnabled(pr) {
+		podSpec.InitContainers = append(podSpec.InitContainers, r.apiDiffContainer(pr, prinfo, out))
+	}
+
+	prefix, prPrefix := r.static.prefix(pr), r.static.prPrefix(pr)
+	dest := path.Join(staticMountDir, prefix)
+	upload, volumes := r.static.storageContainer("upload",
+		fmt.Sprintf(`mkdir -p %s && cp -r %s/. %s/ && cd %s && for d in *; do [ "$d" = %q ] || rm -rf "$d"; done`,
+			dest, staticOutDir, dest, path.Join(staticMountDir, prPrefix), pr.Spec.CommitID),
+		fmt.Sprintf(`mc cp -r %s/ "store/$S3_BUCKET/%s/" && mc ls "store/$S3_BUCKET/%s/" | awk '{print $NF}' | grep -vx %q | while read -r d; do mc rm -r --force "store/$S3_BUCKET/%s/$d"; done`,
+			staticOutDir, prefix, prPrefix, pr.Spec.CommitID+"/", prPrefix),
+		out)
+	podSpec.Containers = []v1.Container{upload}
+	podSpec.Volumes = append(podSpec.Volumes, volumes...)
+
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pr.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	addOwnerRefToObject(job, pullRequestOwnerRef(pr))
+	return job
+}
+
+// readAPIDiff records in the status the API changes compared by the apidiff
+// container of the succeeded job, which are published next to the HTML of
+// the commit.
+func (r *pullRequestReconciler) readAPIDiff(ctx context.Context, pr, prCopy *v1alpha1.PullRequest, job *batchv1.Job) error {
+	if apiDiffRecorded(pr) {
+		return nil
+	}
+	_, summary, err := r.apiDiffSummary(ctx, pr, job)
+	if summary != nil {
+		setAPIDiff(pr, prCopy, summary, r.static.url(pr)+"/apidiff.html")
+	}
+	return err
+}
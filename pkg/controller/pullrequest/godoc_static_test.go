@@ -0,0 +1,183 @@
+package pullrequest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestStaticCleanup(t *testing.T) {
+	ctx := context.Background()
+	// render jobs are named after the first characters of the commit IDs.
+	first, second := strings.Repeat("1", 40), strings.Repeat("2", 40)
+	pr := trackedPullRequest(7, first)
+	c := newFakeClient(pr)
+	r := &pullRequestReconciler{
+		Client:       c,
+		statusWriter: c,
+		jobDeleter:   c,
+		static: &StaticConfig{
+			BaseURL:     "https://docs.example.com",
+			RenderImage: "godoc",
+			PVC:         "godoc-static",
+		},
+		requeuer: newRequeuer(),
+	}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}}
+	job := func(name string) *batchv1.Job {
+		job := &batchv1.Job{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: name}, job); err != nil {
+			return nil
+		}
+		return job
+	}
+	reconcileOnce := func() {
+		if _, err := r.Reconcile(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcileOnce()
+	if got := c.pullRequest(pr.Namespace, pr.Name); !containsString(got.Finalizers, staticCleanupFinalizer) {
+		t.Fatalf("got finalizers %v, want %s", got.Finalizers, staticCleanupFinalizer)
+	}
+	firstJob := staticJobName(c.pullRequest(pr.Namespace, pr.Name))
+	if job(firstJob) == nil {
+		t.Fatalf("render job %s was not created", firstJob)
+	}
+	pod := &v1.Pod{ObjectMeta: metaFor(pr.Namespace, firstJob+"-abcde")}
+	pod.Labels = map[string]string{"job-name": firstJob}
+	if err := c.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job of a new commit replaces the one of the previous commit along
+	// with its pods, and removes the HTML of the previous commits once it
+	// has stored its own.
+	updated := c.pullRequest(pr.Namespace, pr.Name)
+	updated.Spec.CommitID = second
+	if err := c.Update(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+	reconcileOnce()
+	if job(firstJob) != nil {
+		t.Errorf("render job %s of the previous commit was not deleted", firstJob)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, &v1.Pod{}); err == nil {
+		t.Errorf("pod of render job %s was not deleted", firstJob)
+	}
+	secondJob := job(staticJobName(updated))
+	if secondJob == nil {
+		t.Fatal("render job of the new commit was not created")
+	}
+	upload := secondJob.Spec.Template.Spec.Containers[0].Args[0]
+	if want := `[ "$d" = "` + second + `" ] || rm -rf "$d"`; !strings.Contains(upload, want) {
+		t.Errorf("upload script %q does not remove the previous commits", upload)
+	}
+
+	// the HTML of a deleted PullRequest is removed by a cleanup job before
+	// it goes away.
+	if err := c.Delete(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+	reconcileOnce()
+	if c.pullRequest(pr.Namespace, pr.Name) == nil {
+		t.Fatal("PullRequest was deleted before its HTML")
+	}
+	if job(secondJob.Name) != nil {
+		t.Errorf("render job %s of the deleted PullRequest was not deleted", secondJob.Name)
+	}
+	cleanup := job(staticCleanupJobName(updated))
+	if cleanup == nil {
+		t.Fatal("cleanup job was not created")
+	}
+	if got, want := cleanup.Spec.Template.Spec.Containers[0].Args[0], "rm -rf /static/docs/"+pr.Name; got != want {
+		t.Errorf("got cleanup script %q, want %q", got, want)
+	}
+
+	reconcileOnce()
+	if c.pullRequest(pr.Namespace, pr.Name) == nil {
+		t.Fatal("PullRequest was deleted while its cleanup job is running")
+	}
+	cleanup.Status.Succeeded = 1
+	if err := c.Update(ctx, cleanup); err != nil {
+		t.Fatal(err)
+	}
+	reconcileOnce()
+	if got := c.pullRequest(pr.Namespace, pr.Name); got != nil {
+		t.Errorf("PullRequest was not deleted once its HTML was removed, finalizers %v", got.Finalizers)
+	}
+}
+
+func TestStaticCleanupNotStatic(t *testing.T) {
+	pr := trackedPullRequest(7, sha(1))
+	pr.Finalizers = []string{staticCleanupFinalizer}
+	c := newFakeClient(pr)
+	if err := c.Delete(context.Background(), pr); err != nil {
+		t.Fatal(err)
+	}
+	r := &pullRequestReconciler{Client: c, statusWriter: c, jobDeleter: c}
+	deleting, err := r.finalizeStatic(context.Background(), c.pullRequest(pr.Namespace, pr.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleting || c.pullRequest(pr.Namespace, pr.Name) != nil {
+		t.Error("PullRequest was not let go once godoc is not static anymore")
+	}
+}
+
+func TestStaticJobCredentials(t *testing.T) {
+	r := &pullRequestReconciler{
+		static: &StaticConfig{
+			RenderImage: "godoc",
+			PVC:         "godoc-static",
+		},
+		apiDiffImage: "apidiff",
+	}
+	pr := trackedPullRequest(7, sha(7))
+	pr.Spec.Fetch = &v1alpha1.FetchSpec{SecretName: "git-credentials"}
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prinfo.commitID = pr.Spec.CommitID
+	prinfo.fetch = pr.Spec.Fetch
+
+	spec := r.staticJob(pr, prinfo, "render").Spec.Template.Spec
+	var apidiff *v1.Container
+	for i := range spec.InitContainers {
+		if spec.InitContainers[i].Name == "apidiff" {
+			apidiff = &spec.InitContainers[i]
+		}
+	}
+	if apidiff == nil {
+		t.Fatal("got no apidiff container for a private repo")
+	}
+	mounted := false
+	for _, m := range apidiff.VolumeMounts {
+		mounted = mounted || m.Name == credentialsVolumeName(fetchContainerName) && m.MountPath == credentialsDir
+	}
+	if !mounted {
+		t.Errorf("got mounts %+v, want the git credentials mounted at %s", apidiff.VolumeMounts, credentialsDir)
+	}
+	env := map[string]string{}
+	for _, e := range apidiff.Env {
+		env[e.Name] = e.Value
+	}
+	if env["CREDENTIALS"] != credentialsDir || env["GIT_HOST"] != "github.com" {
+		t.Errorf("got env %v, want the credentials of github.com", env)
+	}
+	found := false
+	for _, v := range spec.Volumes {
+		found = found || v.Name == credentialsVolumeName(fetchContainerName)
+	}
+	if !found {
+		t.Error("got no volume of the git credentials")
+	}
+}
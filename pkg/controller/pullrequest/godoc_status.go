@@ -0,0 +1,284 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/event"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/predicate"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// pullRequestLabel is set on godoc pods to the name of the PullRequest
+	// object they serve.
+	pullRequestLabel = "godocs.io/pullrequest"
+
+	// commitStatusContext identifies the commit statuses reported by the bot.
+	commitStatusContext = "godoc/preview"
+
+	// apiCompatibilityContext identifies the commit statuses reporting the
+	// compatibility of the API changes.
+	apiCompatibilityContext = "godoc/api-compatibility"
+)
+
+// Commit status states understood by Github.
+const (
+	commitStatusPending = "pending"
+	commitStatusSuccess = "success"
+	commitStatusFailure = "failure"
+)
+
+// sharedNameField is the field the PullRequests are indexed by the name of
+// the shared godoc deployment of their repo on.
+const sharedNameField = "spec.url.shared_name"
+
+// indexSharedName indexes the PullRequests by the name of the shared godoc
+// deployment of their repo, so that shared godoc pods are mapped to their
+// PullRequests without listing all the PullRequests of the namespace.
+func indexSharedName(indexer client.FieldIndexer) error {
+	return indexer.IndexField(&v1alpha1.PullRequest{}, sharedNameField, func(obj runtime.Object) []string {
+		prinfo, err := parsePullRequestURL(obj.(*v1alpha1.PullRequest).Spec.URL)
+		if err != nil {
+			return nil
+		}
+		return []string{prinfo.sharedName()}
+	})
+}
+
+// godocPodPredicate passes the events of the godoc pods which change the
+// availability of their PullRequests: their creation, their deletion and the
+// changes of their readiness or restart counts.
+var godocPodPredicate = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool { return isGodocPod(e.Meta) },
+	DeleteFunc: func(e event.DeleteEvent) bool { return isGodocPod(e.Meta) },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		old, ok := e.ObjectOld.(*v1.Pod)
+		if !ok || !isGodocPod(e.MetaNew) {
+			return false
+		}
+		pod, ok := e.ObjectNew.(*v1.Pod)
+		return ok && !reflect.DeepEqual(podHealth(old), podHealth(pod))
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
+
+// isGodocPod returns true if the pod serves the godoc of a PR, or of the PRs
+// of a repo.
+func isGodocPod(meta metav1.Object) bool {
+	labels := meta.GetLabels()
+	_, pr := labels[pullRequestLabel]
+	_, repo := labels[repositoryLabel]
+	return pr || repo
+}
+
+// podHealth returns the readiness of the pod, along with the readiness and
+// restart count of its containers keyed by name, init containers included.
+func podHealth(pod *v1.Pod) map[string]string {
+	health := map[string]string{}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			health[""] = string(cond.Status)
+		}
+	}
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			health[status.Name] = fmt.Sprintf("%t/%d", status.Ready, status.RestartCount)
+		}
+	}
+	return health
+}
+
+// pullRequestsForPod maps a godoc pod to the PullRequest objects it serves.
+func (r *pullRequestReconciler) pullRequestsForPod(obj handler.MapObject) []reconcile.Request {
+	if name, found := obj.Meta.GetLabels()[pullRequestLabel]; found {
+		return []reconcile.Request{
+			{NamespacedName: types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: name}},
+		}
+	}
+	// shared godoc pods serve the PullRequests of their repo.
+	name, found := obj.Meta.GetLabels()[repositoryLabel]
+	if !found {
+		return nil
+	}
+	list := &v1alpha1.PullRequestList{}
+	opts := client.InNamespace(obj.Meta.GetNamespace()).MatchingField(sharedNameField, name)
+	if err := r.Client.List(context.Background(), opts, list); err != nil {
+		log.Printf("error listing the PullRequests of pod %s/%s: %v", obj.Meta.GetNamespace(), obj.Meta.GetName(), err)
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, pr := range list.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name},
+		})
+	}
+	return requests
+}
+
+// reportCommitStatus reports the state of the godoc deployment as a commit
+// status on the commitID of the PR. The status is only sent to Github when its
+// state or commitID changes, and the reported state is recorded in pr status.
+func (r *pullRequestReconciler) reportCommitStatus(ctx context.Context, ghClient GithubClient, prinfo *prInfo, pr *v1alpha1.PullRequest) error {
+	state, description, err := r.commitState(ctx, pr)
+	if err != nil {
+		return err
+	}
+	if pr.Status.CommitStatusState == state && pr.Status.CommitStatusCommitID == pr.Spec.CommitID {
+		return nil
+	}
+
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(commitStatusContext),
+	}
+	if state == commitStatusSuccess {
+		status.TargetURL = github.String(pr.Status.GoDocLink)
+	}
+	log.Printf("reporting commit status %q for %s/%s commitID: %s", state, prinfo.org, prinfo.repo, pr.Spec.CommitID)
+	if _, _, err := ghClient.CreateStatus(ctx, prinfo.org, prinfo.repo, pr.Spec.CommitID, status); err != nil {
+		return err
+	}
+	pr.Status.CommitStatusState = state
+	pr.Status.CommitStatusCommitID = pr.Spec.CommitID
+	return nil
+}
+
+// commitState determines the commit status state of the godoc deployment along
+// with a short description of it. The deployment is successful once its godoc
+// link has been published for the commitID, and failed if its pods crash, its
+// commit could not be fetched or its static HTML could not be rendered.
+func (r *pullRequestReconciler) commitState(ctx context.Context, pr *v1alpha1.PullRequest) (string, string, error) {
+	if pr.Status.CommitID == pr.Spec.CommitID && pr.Status.GoDocLink != "" {
+		return commitStatusSuccess, "Godoc preview is available", nil
+	}
+	if c := pr.Status.GetCondition(v1alpha1.DeploymentAvailable); c != nil {
+		switch c.Reason {
+		case fetchFailedReason:
+			return commitStatusFailure, "Commit could not be fetched", nil
+		case secretNotFoundReason:
+			return commitStatusFailure, "Git credentials are not found", nil
+		case renderFailedReason:
+			return commitStatusFailure, "Godoc could not be rendered", nil
+		}
+	}
+
+	pods := &v1.PodList{}
+	opts := client.InNamespace(pr.Namespace).MatchingLabels(map[string]string{pullRequestLabel: pr.Name})
+	if err := r.Client.List(ctx, opts, pods); err != nil {
+		return "", "", err
+	}
+	for _, pod := range pods.Items {
+		if podCrashLooping(&pod) {
+			return commitStatusFailure, "Godoc server is crash looping", nil
+		}
+	}
+	return commitStatusPending, "Godoc preview is being deployed", nil
+}
+
+// podCrashLooping returns true if any container of the pod is being restarted
+// after crashing.
+func podCrashLooping(pod *v1.Pod) bool {
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, cs := range statuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reportAPICompatibility reports the compatibility of the API changes of the
+// PR as a commit status, once it is known for the commitID of the PR. The
+// status fails if incompatible changes are made without the PR carrying the
+// breaking change label, the PR is then reconciled again after the labels
+// refresh interval to notice the label being added. The reported state is
+// recorded in pr status, along with the labels of the PR if they had to be
+// fetched.
+func (r *pullRequestReconciler) reportAPICompatibility(ctx context.Context, ghClient GithubClient, prinfo *prInfo, pr *v1alpha1.PullRequest) error {
+	compat := pr.Status.APICompatibility
+	if compat == nil || compat.CommitID != pr.Spec.CommitID {
+		return nil
+	}
+
+	state, description := commitStatusSuccess, "The exported API changes are compatible"
+	if !compat.Compatible {
+		labels, err := r.prLabels(ctx, ghClient, prinfo, pr)
+		if err != nil {
+			return err
+		}
+		if containsString(labels, r.breakingChangeLabel) {
+			description = fmt.Sprintf("%d incompatible API changes, labeled %s", compat.Incompatible, r.breakingChangeLabel)
+		} else {
+			state = commitStatusFailure
+			description = fmt.Sprintf("%d incompatible API changes, label the PR %s if intended", compat.Incompatible, r.breakingChangeLabel)
+			r.requeuer.after(pr, r.labelsRefreshInterval)
+		}
+	}
+	if compat.CommitStatusState == state {
+		return nil
+	}
+
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(apiCompatibilityContext),
+	}
+	if pr.Status.APIDiff != nil && pr.Status.APIDiff.Link != "" {
+		status.TargetURL = github.String(pr.Status.APIDiff.Link)
+	}
+	log.Printf("reporting API compatibility %q for %s/%s commitID: %s", state, prinfo.org, prinfo.repo, pr.Spec.CommitID)
+	if _, _, err := ghClient.CreateStatus(ctx, prinfo.org, prinfo.repo, pr.Spec.CommitID, status); err != nil {
+		return err
+	}
+	compat.CommitStatusState = state
+	return nil
+}
+
+// prLabels returns the labels of the PR. The webhook records them in the
+// status whenever they change. They are fetched if none were recorded since
+// the last push to the PR, or if they were last fetched over the labels
+// refresh interval ago, which the ETag cache of the client answers without
+// using up the rate limit while they do not change.
+func (r *pullRequestReconciler) prLabels(ctx context.Context, ghClient GithubClient, prinfo *prInfo, pr *v1alpha1.PullRequest) ([]string, error) {
+	key := types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}
+	if l := pr.Status.Labels; l != nil && l.CommitID == pr.Spec.CommitID {
+		if fetched, found := r.labelsFetched[key]; found && time.Since(fetched) < r.labelsRefreshInterval {
+			return l.Names, nil
+		}
+	}
+	var names []string
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		labels, resp, err := ghClient.ListLabelsByIssue(ctx, prinfo.org, prinfo.repo, int(prinfo.pr), opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range labels {
+			names = append(names, l.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	if r.labelsFetched == nil {
+		r.labelsFetched = map[types.NamespacedName]time.Time{}
+	}
+	r.labelsFetched[key] = time.Now()
+	pr.Status.Labels = &v1alpha1.PullRequestLabels{CommitID: pr.Spec.CommitID, Names: names}
+	return names, nil
+}
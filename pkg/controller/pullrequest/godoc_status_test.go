@@ -0,0 +1,154 @@
+package pullrequest
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/event"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// labelCountingGithubClient is a fakeGithubClient counting the calls listing
+// the labels of a PR.
+type labelCountingGithubClient struct {
+	*fakeGithubClient
+	calls int
+}
+
+func (c *labelCountingGithubClient) ListLabelsByIssue(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
+	c.calls++
+	return c.fakeGithubClient.ListLabelsByIssue(ctx, owner, repo, number, opt)
+}
+
+func TestReportAPICompatibilityLabels(t *testing.T) {
+	ctx := context.Background()
+	ghClient := &labelCountingGithubClient{fakeGithubClient: newFakeGithubClient()}
+	ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", ghPullRequest(7, "open", false, sha(1), sha(0)))
+	ghClient.SetLabels("kubernetes-sigs", "kubebuilder", 7, "breaking-change")
+	r := &pullRequestReconciler{breakingChangeLabel: "breaking-change", labelsRefreshInterval: time.Hour, requeuer: newRequeuer()}
+
+	pr := trackedPullRequest(7, sha(1))
+	pr.Status.APICompatibility = &v1alpha1.APICompatibility{CommitID: sha(1), Incompatible: 1}
+	prinfo, err := parsePullRequestURL(pr.Spec.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := func() string {
+		pr.Status.APICompatibility.CommitStatusState = ""
+		if err := r.reportAPICompatibility(ctx, ghClient, prinfo, pr); err != nil {
+			t.Fatal(err)
+		}
+		return pr.Status.APICompatibility.CommitStatusState
+	}
+
+	// without labels from the webhook, they are fetched once per commit.
+	if got := report(); got != commitStatusSuccess {
+		t.Errorf("got state %q for a labeled PR, want %q", got, commitStatusSuccess)
+	}
+	report()
+	if ghClient.calls != 1 {
+		t.Errorf("got %d calls listing labels, want 1", ghClient.calls)
+	}
+
+	// labels recorded by the webhook are used as they are.
+	pr.Status.Labels = &v1alpha1.PullRequestLabels{CommitID: sha(1)}
+	if got := report(); got != commitStatusFailure {
+		t.Errorf("got state %q for an unlabeled PR, want %q", got, commitStatusFailure)
+	}
+	if ghClient.calls != 1 {
+		t.Errorf("got %d calls listing labels, want 1", ghClient.calls)
+	}
+
+	// without the webhook, the failing PR is labeled after the push, which
+	// is noticed once the labels are fetched again.
+	key := types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}
+	if _, due := r.requeuer.due[key]; !due {
+		t.Error("got the failing PR not requeued to fetch its labels again")
+	}
+	r.labelsFetched[key] = time.Now().Add(-2 * time.Hour)
+	if got := report(); got != commitStatusSuccess {
+		t.Errorf("got state %q once the labels are fetched again, want %q", got, commitStatusSuccess)
+	}
+	if ghClient.calls != 2 {
+		t.Errorf("got %d calls listing labels, want 2", ghClient.calls)
+	}
+}
+
+func TestPullRequestsForPod(t *testing.T) {
+	other := trackedPullRequest(3, sha(3))
+	other.Spec.URL = "https://github.com/kubernetes-sigs/controller-runtime/pull/3"
+	c := newFakeClient(trackedPullRequest(1, sha(1)), trackedPullRequest(2, sha(2)), other)
+	if err := indexSharedName(c); err != nil {
+		t.Fatal(err)
+	}
+	r := &pullRequestReconciler{Client: c}
+	request := func(name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: syncerTestNamespace, Name: name}}
+	}
+
+	pod := &v1.Pod{ObjectMeta: metaFor(syncerTestNamespace, "kubebuilder-pr-1-abcde")}
+	pod.Labels = map[string]string{pullRequestLabel: "kubebuilder-pr-1"}
+	if got, want := r.pullRequestsForPod(handler.MapObject{Meta: pod, Object: pod}), []reconcile.Request{request("kubebuilder-pr-1")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %v, want %v", got, want)
+	}
+
+	pod.Labels = map[string]string{repositoryLabel: sharedTestName}
+	got := r.pullRequestsForPod(handler.MapObject{Meta: pod, Object: pod})
+	sort.Slice(got, func(i, j int) bool { return got[i].Name < got[j].Name })
+	if want := []reconcile.Request{request("kubebuilder-pr-1"), request("kubebuilder-pr-2")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %v, want the PRs of the repo %v", got, want)
+	}
+}
+
+func TestGodocPodPredicate(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metaFor(syncerTestNamespace, "kubebuilder-pr-1-abcde")}
+	pod.Labels = map[string]string{pullRequestLabel: "kubebuilder-pr-1"}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "godoc"}}
+	other := &v1.Pod{ObjectMeta: metaFor(syncerTestNamespace, "other")}
+
+	if !godocPodPredicate.Create(event.CreateEvent{Meta: pod, Object: pod}) || godocPodPredicate.Create(event.CreateEvent{Meta: other, Object: other}) {
+		t.Error("got the creations of pods filtered on their labels wrong")
+	}
+	if !godocPodPredicate.Delete(event.DeleteEvent{Meta: pod, Object: pod}) {
+		t.Error("got the deletion of a godoc pod filtered out")
+	}
+	update := func(change func(pod *v1.Pod)) bool {
+		updated := pod.DeepCopy()
+		change(updated)
+		return godocPodPredicate.Update(event.UpdateEvent{MetaOld: pod, ObjectOld: pod, MetaNew: updated, ObjectNew: updated})
+	}
+	tests := []struct {
+		name   string
+		change func(pod *v1.Pod)
+		want   bool
+	}{
+		{"IP", func(pod *v1.Pod) { pod.Status.PodIP = "10.0.0.1" }, false},
+		{"annotations", func(pod *v1.Pod) { pod.Annotations = map[string]string{"a": "b"} }, false},
+		{"ready", func(pod *v1.Pod) {
+			pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+		}, true},
+		{"container ready", func(pod *v1.Pod) { pod.Status.ContainerStatuses[0].Ready = true }, true},
+		{"restarted", func(pod *v1.Pod) { pod.Status.ContainerStatuses[0].RestartCount = 1 }, true},
+		{"fetch restarted", func(pod *v1.Pod) {
+			pod.Status.InitContainerStatuses = []v1.ContainerStatus{{Name: fetchContainerName, RestartCount: 1}}
+		}, true},
+	}
+	for _, test := range tests {
+		if got := update(test.change); got != test.want {
+			t.Errorf("%s: got update passed %v, want %v", test.name, got, test.want)
+		}
+	}
+	updated := other.DeepCopy()
+	updated.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+	if godocPodPredicate.Update(event.UpdateEvent{MetaOld: other, ObjectOld: other, MetaNew: updated, ObjectNew: updated}) {
+		t.Error("got the update of a pod which is not a godoc pod passed")
+	}
+}
@@ -0,0 +1,53 @@
+package pullrequest
+
+import (
+	"context"
+
+	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// JobDeleter deletes Jobs along with their pods. The controller-runtime client
+// cannot set the propagation policy of deletes, and the pods of Jobs deleted
+// with the default policy are orphaned.
+type JobDeleter interface {
+	// DeleteJob deletes the job, its pods are deleted in the background.
+	DeleteJob(ctx context.Context, job *batchv1.Job) error
+}
+
+// newJobDeleter returns a JobDeleter calling the batch/v1 API.
+func newJobDeleter(mgr manager.Manager) (JobDeleter, error) {
+	config := *mgr.GetConfig()
+	config.GroupVersion = &batchv1.SchemeGroupVersion
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	rc, err := rest.RESTClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &restJobDeleter{rc: rc}, nil
+}
+
+// restJobDeleter implements JobDeleter with a REST client of the batch/v1
+// API.
+type restJobDeleter struct {
+	rc rest.Interface
+}
+
+func (d *restJobDeleter) DeleteJob(ctx context.Context, job *batchv1.Job) error {
+	background := metav1.DeletePropagationBackground
+	return d.rc.Delete().
+		Namespace(job.Namespace).
+		Resource("jobs").
+		Name(job.Name).
+		Body(&metav1.DeleteOptions{PropagationPolicy: &background}).
+		Do().
+		Error()
+}
@@ -0,0 +1,52 @@
+package pullrequest
+
+import (
+	"context"
+
+	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// PodLogReader reads the logs of the containers of pods, which the
+// controller-runtime client cannot read.
+type PodLogReader interface {
+	// ReadLogs returns the log of the named container of the pod.
+	ReadLogs(ctx context.Context, pod *v1.Pod, container string) ([]byte, error)
+}
+
+// newPodLogReader returns a PodLogReader calling the core v1 API.
+func newPodLogReader(mgr manager.Manager) (PodLogReader, error) {
+	config := *mgr.GetConfig()
+	config.GroupVersion = &v1.SchemeGroupVersion
+	config.APIPath = "/api"
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	rc, err := rest.RESTClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &restPodLogReader{rc: rc}, nil
+}
+
+// restPodLogReader implements PodLogReader with a REST client of the core v1
+// API.
+type restPodLogReader struct {
+	rc rest.Interface
+}
+
+func (l *restPodLogReader) ReadLogs(ctx context.Context, pod *v1.Pod, container string) ([]byte, error) {
+	return l.rc.Get().
+		Context(ctx).
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("log").
+		Param("container", container).
+		Do().
+		Raw()
+}
@@ -0,0 +1,241 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Code hosting providers PRs can be served from.
+const (
+	ProviderGithub          = "github"
+	ProviderGitlab          = "gitlab"
+	ProviderGitea           = "gitea"
+	ProviderBitbucketServer = "bitbucket-server"
+)
+
+// Provider resolves the commits of the PRs hosted by a code hosting service.
+// Providers are looked up by the host of the PR URL, whose layout is the one
+// of the provider type registered for the host with RegisterProviderHost.
+type Provider interface {
+	// Commits returns the SHAs of the head commit of the PR and of the
+	// commit of the base branch it is compared with.
+	Commits(ctx context.Context, prinfo *prInfo) (head, base string, err error)
+}
+
+// Providers maps the hosts of PR URLs to the Provider serving them.
+type Providers map[string]Provider
+
+// lookup returns the Provider serving the host of the PR.
+func (p Providers) lookup(prinfo *prInfo) (Provider, error) {
+	provider, found := p[prinfo.host]
+	if !found {
+		return nil, fmt.Errorf("no %s provider configured for host %s", prinfo.provider, prinfo.host)
+	}
+	return provider, nil
+}
+
+// githubProvider resolves the commits of Github PRs.
+type githubProvider struct {
+	ghClient GithubClient
+}
+
+// NewGithubProvider returns a Provider for Github PRs using the given client.
+func NewGithubProvider(ghClient GithubClient) Provider {
+	return &githubProvider{ghClient: ghClient}
+}
+
+func (p *githubProvider) Commits(ctx context.Context, prinfo *prInfo) (string, string, error) {
+	ghPR, _, err := p.ghClient.GetPullRequest(ctx, prinfo.org, prinfo.repo, int(prinfo.pr))
+	if err != nil {
+		return "", "", err
+	}
+	return ghPR.GetHead().GetSHA(), ghPR.GetBase().GetSHA(), nil
+}
+
+// providerLayout describes how a provider lays out the URLs and git refs of
+// its PRs.
+type providerLayout struct {
+	provider string
+	// parsePath parses the segments of the path of a PR URL, ok is false
+	// if the path is not a PR of the provider.
+	parsePath func(parts []string) (org, repo, pr string, ok bool)
+	// prPath returns the path of the canonical URL of the PR.
+	prPath func(prinfo *prInfo) string
+	// cloneURL returns the URL the repo of the PR is cloned from.
+	cloneURL func(prinfo *prInfo) string
+	// fetchRefspec returns the refspec fetching the head of the PR.
+	fetchRefspec func(prinfo *prInfo) string
+}
+
+// providerLayouts are the layouts of the providers, Github first.
+var providerLayouts = []providerLayout{
+	{
+		// https://github.com/<org>/<repo>/pull/<n>
+		provider: ProviderGithub,
+		parsePath: func(parts []string) (string, string, string, bool) {
+			if len(parts) < 4 || parts[2] != "pull" {
+				return "", "", "", false
+			}
+			return parts[0], parts[1], parts[3], true
+		},
+		prPath: func(prinfo *prInfo) string {
+			return fmt.Sprintf("/%s/%s/pull/%d", prinfo.org, prinfo.repo, prinfo.pr)
+		},
+		cloneURL: func(prinfo *prInfo) string {
+			return fmt.Sprintf("https://%s/%s/%s", prinfo.host, prinfo.org, prinfo.repo)
+		},
+		fetchRefspec: func(prinfo *prInfo) string {
+			return fmt.Sprintf("pull/%d/head", prinfo.pr)
+		},
+	},
+	{
+		// https://gitlab.com/<group>[/<subgroup>...]/<repo>/-/merge_requests/<n>
+		provider: ProviderGitlab,
+		parsePath: func(parts []string) (string, string, string, bool) {
+			for i := 2; i < len(parts)-1; i++ {
+				if parts[i] != "merge_requests" {
+					continue
+				}
+				// older GitLab versions have no "-" segment.
+				end := i
+				if parts[i-1] == "-" {
+					end = i - 1
+				}
+				if end < 2 {
+					return "", "", "", false
+				}
+				return strings.Join(parts[:end-1], "/"), parts[end-1], parts[i+1], true
+			}
+			return "", "", "", false
+		},
+		prPath: func(prinfo *prInfo) string {
+			return fmt.Sprintf("/%s/%s/-/merge_requests/%d", prinfo.org, prinfo.repo, prinfo.pr)
+		},
+		cloneURL: func(prinfo *prInfo) string {
+			return fmt.Sprintf("https://%s/%s/%s.git", prinfo.host, prinfo.org, prinfo.repo)
+		},
+		fetchRefspec: func(prinfo *prInfo) string {
+			return fmt.Sprintf("merge-requests/%d/head", prinfo.pr)
+		},
+	},
+	{
+		// https://gitea.com/<org>/<repo>/pulls/<n>
+		provider: ProviderGitea,
+		parsePath: func(parts []string) (string, string, string, bool) {
+			if len(parts) < 4 || parts[2] != "pulls" {
+				return "", "", "", false
+			}
+			return parts[0], parts[1], parts[3], true
+		},
+		prPath: func(prinfo *prInfo) string {
+			return fmt.Sprintf("/%s/%s/pulls/%d", prinfo.org, prinfo.repo, prinfo.pr)
+		},
+		cloneURL: func(prinfo *prInfo) string {
+			return fmt.Sprintf("https://%s/%s/%s.git", prinfo.host, prinfo.org, prinfo.repo)
+		},
+		fetchRefspec: func(prinfo *prInfo) string {
+			return fmt.Sprintf("pull/%d/head", prinfo.pr)
+		},
+	},
+	{
+		// https://bitbucket.example.com/projects/<key>/repos/<repo>/pull-requests/<n>
+		provider: ProviderBitbucketServer,
+		parsePath: func(parts []string) (string, string, string, bool) {
+			if len(parts) < 6 || parts[0] != "projects" || parts[2] != "repos" || parts[4] != "pull-requests" {
+				return "", "", "", false
+			}
+			return parts[1], parts[3], parts[5], true
+		},
+		prPath: func(prinfo *prInfo) string {
+			return fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d", prinfo.org, prinfo.repo, prinfo.pr)
+		},
+		cloneURL: func(prinfo *prInfo) string {
+			return fmt.Sprintf("https://%s/scm/%s/%s.git", prinfo.host, strings.ToLower(prinfo.org), prinfo.repo)
+		},
+		fetchRefspec: func(prinfo *prInfo) string {
+			return fmt.Sprintf("refs/pull-requests/%d/from", prinfo.pr)
+		},
+	},
+}
+
+// providerHosts maps the hosts of the providers other than Github to their
+// type. The PR URLs of the other hosts are Github PRs.
+var providerHosts = struct {
+	sync.RWMutex
+	types map[string]string
+}{types: map[string]string{}}
+
+// RegisterProviderHost makes the PR URLs of host be parsed with the layout of
+// the given provider type. The URLs of unregistered hosts are parsed as Github
+// PRs.
+func RegisterProviderHost(host, providerType string) error {
+	if _, found := layoutOf(providerType); !found || providerType == ProviderGithub {
+		return fmt.Errorf("unknown provider %q, must be one of %q, %q or %q", providerType, ProviderGitlab, ProviderGitea, ProviderBitbucketServer)
+	}
+	providerHosts.Lock()
+	defer providerHosts.Unlock()
+	providerHosts.types[strings.ToLower(host)] = providerType
+	return nil
+}
+
+// providerOf returns the type of the provider serving the PRs of host.
+func providerOf(host string) string {
+	providerHosts.RLock()
+	defer providerHosts.RUnlock()
+	if providerType, found := providerHosts.types[strings.ToLower(host)]; found {
+		return providerType
+	}
+	return ProviderGithub
+}
+
+// layoutOf returns the layout of the given provider type.
+func layoutOf(providerType string) (providerLayout, bool) {
+	for _, layout := range providerLayouts {
+		if layout.provider == providerType {
+			return layout, true
+		}
+	}
+	return providerLayout{}, false
+}
+
+// parseProviderPath parses the segments of the path of a PR URL with the
+// layout of the provider serving host.
+func parseProviderPath(host string, parts []string) (*prInfo, error) {
+	layout, _ := layoutOf(providerOf(host))
+	org, repo, num, ok := layout.parsePath(parts)
+	if !ok {
+		return nil, fmt.Errorf("pr info missing in the URL of a %s PR", layout.provider)
+	}
+	prNum, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &prInfo{
+		provider: layout.provider,
+		host:     host,
+		org:      org,
+		repo:     repo,
+		pr:       prNum,
+	}, nil
+}
+
+// layout returns the providerLayout of the provider of the PR.
+func (pr *prInfo) layout() providerLayout {
+	if layout, found := layoutOf(pr.provider); found {
+		return layout
+	}
+	return providerLayouts[0]
+}
+
+// cloneURL returns the URL the repo of the PR is cloned from.
+func (pr *prInfo) cloneURL() string {
+	return pr.layout().cloneURL(pr)
+}
+
+// fetchRefspec returns the refspec fetching the head of the PR.
+func (pr *prInfo) fetchRefspec() string {
+	return pr.layout().fetchRefspec(pr)
+}
@@ -0,0 +1,148 @@
+package pullrequest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProviderConfig configures the Provider serving the PRs of a host.
+type ProviderConfig struct {
+	// Type is one of ProviderGitlab, ProviderGitea or ProviderBitbucketServer.
+	// Github PRs are served by NewGithubProvider.
+	Type string
+	// BaseURL of the provider, e.g. https://gitlab.example.com. The API
+	// paths of the provider are appended to it.
+	BaseURL string
+	// Token authenticates the API requests, they are anonymous if empty.
+	Token string
+	// HTTPClient makes the API requests, http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+}
+
+// NewProvider returns the Provider described by cfg.
+func NewProvider(cfg ProviderConfig) (Provider, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	c := &apiClient{baseURL: baseURL, token: cfg.Token, httpClient: cfg.HTTPClient}
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	switch cfg.Type {
+	case ProviderGitlab:
+		c.authHeader = "PRIVATE-TOKEN"
+		return &gitlabProvider{c}, nil
+	case ProviderGitea:
+		c.authHeader, c.authPrefix = "Authorization", "token "
+		return &giteaProvider{c}, nil
+	case ProviderBitbucketServer:
+		c.authHeader, c.authPrefix = "Authorization", "Bearer "
+		return &bitbucketServerProvider{c}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q, must be one of %q, %q or %q", cfg.Type, ProviderGitlab, ProviderGitea, ProviderBitbucketServer)
+	}
+}
+
+// apiClient makes authenticated requests to the JSON API of a provider.
+type apiClient struct {
+	baseURL    *url.URL
+	token      string
+	authHeader string
+	authPrefix string
+	httpClient *http.Client
+}
+
+// get requests the API path, whose segments must already be escaped, and
+// decodes the JSON response into v.
+func (c *apiClient) get(ctx context.Context, path string, v interface{}) error {
+	u := *c.baseURL
+	u.RawPath = u.Path + path
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set(c.authHeader, c.authPrefix+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u.String(), resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// gitlabProvider resolves the commits of GitLab merge requests.
+type gitlabProvider struct {
+	c *apiClient
+}
+
+func (p *gitlabProvider) Commits(ctx context.Context, prinfo *prInfo) (string, string, error) {
+	var mr struct {
+		SHA      string `json:"sha"`
+		DiffRefs struct {
+			BaseSHA string `json:"base_sha"`
+		} `json:"diff_refs"`
+	}
+	// the project is identified by its URL encoded path.
+	path := fmt.Sprintf("/api/v4/projects/%s/merge_requests/%d", url.PathEscape(prinfo.org+"/"+prinfo.repo), prinfo.pr)
+	if err := p.c.get(ctx, path, &mr); err != nil {
+		return "", "", err
+	}
+	return mr.SHA, mr.DiffRefs.BaseSHA, nil
+}
+
+// giteaProvider resolves the commits of Gitea pull requests.
+type giteaProvider struct {
+	c *apiClient
+}
+
+func (p *giteaProvider) Commits(ctx context.Context, prinfo *prInfo) (string, string, error) {
+	var pull struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			SHA string `json:"sha"`
+		} `json:"base"`
+	}
+	path := fmt.Sprintf("/api/v1/repos/%s/%s/pulls/%d", url.PathEscape(prinfo.org), url.PathEscape(prinfo.repo), prinfo.pr)
+	if err := p.c.get(ctx, path, &pull); err != nil {
+		return "", "", err
+	}
+	return pull.Head.SHA, pull.Base.SHA, nil
+}
+
+// bitbucketServerProvider resolves the commits of Bitbucket Server pull
+// requests.
+type bitbucketServerProvider struct {
+	c *apiClient
+}
+
+func (p *bitbucketServerProvider) Commits(ctx context.Context, prinfo *prInfo) (string, string, error) {
+	var pull struct {
+		FromRef struct {
+			LatestCommit string `json:"latestCommit"`
+		} `json:"fromRef"`
+		ToRef struct {
+			LatestCommit string `json:"latestCommit"`
+		} `json:"toRef"`
+	}
+	path := fmt.Sprintf("/rest/api/1.0/projects/%s/repos/%s/pull-requests/%d", url.PathEscape(prinfo.org), url.PathEscape(prinfo.repo), prinfo.pr)
+	if err := p.c.get(ctx, path, &pull); err != nil {
+		return "", "", err
+	}
+	return pull.FromRef.LatestCommit, pull.ToRef.LatestCommit, nil
+}
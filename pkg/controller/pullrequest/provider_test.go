@@ -0,0 +1,150 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func init() {
+	for host, providerType := range map[string]string{
+		"gitlab.example.com":    ProviderGitlab,
+		"gitea.example.com":     ProviderGitea,
+		"bitbucket.example.com": ProviderBitbucketServer,
+	} {
+		if err := RegisterProviderHost(host, providerType); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestParsePullRequestURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want *prInfo
+	}{
+		{
+			url:  "https://github.com/kubernetes-sigs/controller-runtime/pull/15",
+			want: &prInfo{provider: ProviderGithub, host: "github.com", org: "kubernetes-sigs", repo: "controller-runtime", pr: 15},
+		},
+		{
+			url:  "https://gitlab.example.com/group/subgroup/repo/-/merge_requests/7",
+			want: &prInfo{provider: ProviderGitlab, host: "gitlab.example.com", org: "group/subgroup", repo: "repo", pr: 7},
+		},
+		{
+			url:  "https://gitea.example.com/org/repo/pulls/3",
+			want: &prInfo{provider: ProviderGitea, host: "gitea.example.com", org: "org", repo: "repo", pr: 3},
+		},
+		{
+			url:  "https://bitbucket.example.com/projects/KEY/repos/repo/pull-requests/9",
+			want: &prInfo{provider: ProviderBitbucketServer, host: "bitbucket.example.com", org: "KEY", repo: "repo", pr: 9},
+		},
+		// the layout is the one of the provider of the host, whatever the
+		// path looks like.
+		{url: "https://github.com/org/repo/pulls/3"},
+		{url: "https://gitea.example.com/org/repo/pull/3"},
+		{url: "https://gitlab.example.com/org/repo/pull/3"},
+		{url: "https://github.com/org/repo/pull/abc"},
+	}
+	for _, test := range tests {
+		got, err := parsePullRequestURL(test.url)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", test.url, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.url, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestRegisterProviderHost(t *testing.T) {
+	for _, providerType := range []string{ProviderGithub, "gerrit", ""} {
+		if err := RegisterProviderHost("git.example.com", providerType); err == nil {
+			t.Errorf("registered a host of provider %q", providerType)
+		}
+	}
+}
+
+func TestProviderClients(t *testing.T) {
+	tests := []struct {
+		providerType string
+		url          string
+		// path, authorization header and response of the API request.
+		path     string
+		header   string
+		auth     string
+		response string
+	}{
+		{
+			providerType: ProviderGitlab,
+			url:          "https://gitlab.example.com/group/subgroup/repo/-/merge_requests/7",
+			path:         "/api/v4/projects/group%2Fsubgroup%2Frepo/merge_requests/7",
+			header:       "PRIVATE-TOKEN",
+			auth:         "s3cr3t",
+			response:     `{"sha": "%s", "diff_refs": {"base_sha": "%s"}}`,
+		},
+		{
+			providerType: ProviderGitea,
+			url:          "https://gitea.example.com/org/repo/pulls/3",
+			path:         "/api/v1/repos/org/repo/pulls/3",
+			header:       "Authorization",
+			auth:         "token s3cr3t",
+			response:     `{"head": {"sha": "%s"}, "base": {"sha": "%s"}}`,
+		},
+		{
+			providerType: ProviderBitbucketServer,
+			url:          "https://bitbucket.example.com/projects/KEY/repos/repo/pull-requests/9",
+			path:         "/rest/api/1.0/projects/KEY/repos/repo/pull-requests/9",
+			header:       "Authorization",
+			auth:         "Bearer s3cr3t",
+			response:     `{"fromRef": {"latestCommit": "%s"}, "toRef": {"latestCommit": "%s"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.providerType, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.EscapedPath() != test.path {
+					http.NotFound(w, r)
+					return
+				}
+				if got := r.Header.Get(test.header); got != test.auth {
+					t.Errorf("got %s header %q, want %q", test.header, got, test.auth)
+				}
+				fmt.Fprintf(w, test.response, sha(2), sha(1))
+			}))
+			defer srv.Close()
+
+			p, err := NewProvider(ProviderConfig{Type: test.providerType, BaseURL: srv.URL + "/", Token: "s3cr3t"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			prinfo, err := parsePullRequestURL(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head, base, err := p.Commits(context.Background(), prinfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head != sha(2) || base != sha(1) {
+				t.Errorf("got commits %s and %s, want %s and %s", head, base, sha(2), sha(1))
+			}
+
+			// errors of the API are returned.
+			prinfo.pr++
+			if _, _, err := p.Commits(context.Background(), prinfo); err == nil {
+				t.Error("got no error for a missing PR")
+			}
+		})
+	}
+}
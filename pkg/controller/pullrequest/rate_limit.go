@@ -0,0 +1,212 @@
+package pullrequest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// defaultRateLimitBackoff is how long calls to Github are suspended after a
+// rate limit error which does not tell when to retry.
+const defaultRateLimitBackoff = time.Minute
+
+// rateLimitBackoff returns how long to wait before calling Github again after
+// err, and false if err is not caused by rate limiting. Both the primary rate
+// limit, whose reset time is given by X-RateLimit-Reset, and the secondary
+// (abuse) rate limits, which may come with Retry-After, are recognized.
+func rateLimitBackoff(err error) (time.Duration, bool) {
+	var d time.Duration
+	switch e := err.(type) {
+	case *github.RateLimitError:
+		d = time.Until(e.Rate.Reset.Time)
+	case *github.AbuseRateLimitError:
+		d = defaultRateLimitBackoff
+		if e.RetryAfter != nil {
+			d = *e.RetryAfter
+		}
+	case *github.ErrorResponse:
+		resp := e.Response
+		if resp == nil || (resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests) {
+			return 0, false
+		}
+		if secs, err := strconv.ParseInt(resp.Header.Get("Retry-After"), 10, 64); err == nil {
+			d = time.Duration(secs) * time.Second
+		} else if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			d = time.Until(time.Unix(reset, 0))
+		} else if resp.StatusCode == http.StatusTooManyRequests {
+			d = defaultRateLimitBackoff
+		} else {
+			// forbidden for another reason.
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return d, true
+}
+
+// backoff suspends the calls to a Github host while it rate limits us.
+type backoff struct {
+	host string
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// observe suspends the calls to the host if err is a rate limit error. It
+// returns true if it is.
+func (b *backoff) observe(err error) bool {
+	d, limited := rateLimitBackoff(err)
+	if !limited {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := time.Now().Add(d); until.After(b.until) {
+		log.Printf("github host %s is rate limiting, backing off for %v", b.host, d)
+		b.until = until
+	}
+	return true
+}
+
+// active returns true while calls to the host are suspended.
+func (b *backoff) active() bool {
+	return b.suspended() != nil
+}
+
+// suspendedError is returned in place of calling a Github host which rate
+// limits us.
+type suspendedError struct {
+	host  string
+	until time.Time
+}
+
+func (e *suspendedError) Error() string {
+	return fmt.Sprintf("calls to github host %s are suspended until %v because of rate limiting", e.host, e.until.Format(time.RFC3339))
+}
+
+// suspended returns an error while calls to the host are suspended.
+func (b *backoff) suspended() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Now().Before(b.until) {
+		return &suspendedError{host: b.host, until: b.until}
+	}
+	return nil
+}
+
+// rateLimitedClient is a GithubClient whose calls are suspended while its
+// Github host rate limits us. The reconcilers, the syncer, the commenter and
+// the Repository controller share the client, and so the backoff, of a host.
+type rateLimitedClient struct {
+	c  GithubClient
+	bo *backoff
+}
+
+// rateLimited returns a GithubClient calling c until the host rate limits us.
+func rateLimited(host string, c GithubClient) GithubClient {
+	return &rateLimitedClient{c: c, bo: &backoff{host: host}}
+}
+
+// backoffOf returns the backoff of the Github host of the client, a new one
+// if the client is not rate limited.
+func backoffOf(host string, c GithubClient) *backoff {
+	if rl, ok := c.(*rateLimitedClient); ok {
+		return rl.bo
+	}
+	return &backoff{host: host}
+}
+
+func (rl *rateLimitedClient) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	pr, resp, err := rl.c.GetPullRequest(ctx, owner, repo, number)
+	rl.bo.observe(err)
+	return pr, resp, err
+}
+
+func (rl *rateLimitedClient) ListPullRequests(ctx context.Context, owner, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	prs, resp, err := rl.c.ListPullRequests(ctx, owner, repo, opt)
+	rl.bo.observe(err)
+	return prs, resp, err
+}
+
+func (rl *rateLimitedClient) ListFiles(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	files, resp, err := rl.c.ListFiles(ctx, owner, repo, number, opt)
+	rl.bo.observe(err)
+	return files, resp, err
+}
+
+func (rl *rateLimitedClient) ListLabelsByIssue(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	labels, resp, err := rl.c.ListLabelsByIssue(ctx, owner, repo, number, opt)
+	rl.bo.observe(err)
+	return labels, resp, err
+}
+
+func (rl *rateLimitedClient) ListComments(ctx context.Context, owner, repo string, number int, opt *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	comments, resp, err := rl.c.ListComments(ctx, owner, repo, number, opt)
+	rl.bo.observe(err)
+	return comments, resp, err
+}
+
+func (rl *rateLimitedClient) CreateComment(ctx context.Context, owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	comment, resp, err := rl.c.CreateComment(ctx, owner, repo, number, comment)
+	rl.bo.observe(err)
+	return comment, resp, err
+}
+
+func (rl *rateLimitedClient) EditComment(ctx context.Context, owner, repo string, id int64, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	comment, resp, err := rl.c.EditComment(ctx, owner, repo, id, comment)
+	rl.bo.observe(err)
+	return comment, resp, err
+}
+
+func (rl *rateLimitedClient) CreateReview(ctx context.Context, owner, repo string, number int, review *github.PullRequestReviewRequest) (*github.PullRequestReview, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	created, resp, err := rl.c.CreateReview(ctx, owner, repo, number, review)
+	rl.bo.observe(err)
+	return created, resp, err
+}
+
+func (rl *rateLimitedClient) CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error) {
+	if err := rl.bo.suspended(); err != nil {
+		return nil, nil, err
+	}
+	status, resp, err := rl.c.CreateStatus(ctx, owner, repo, ref, status)
+	rl.bo.observe(err)
+	return status, resp, err
+}
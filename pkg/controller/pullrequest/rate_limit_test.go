@@ -0,0 +1,65 @@
+package pullrequest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// limitingGithubClient is a fakeGithubClient whose host rate limits every
+// call fetching a PR.
+type limitingGithubClient struct {
+	*fakeGithubClient
+	calls int
+}
+
+func (c *limitingGithubClient) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	c.calls++
+	return nil, nil, &github.RateLimitError{
+		Rate:     github.Rate{Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}},
+		Response: &http.Response{StatusCode: http.StatusForbidden},
+	}
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	ctx := context.Background()
+	limiting := &limitingGithubClient{fakeGithubClient: newFakeGithubClient()}
+	ghClient := rateLimited("api.github.com", limiting)
+
+	if _, _, err := ghClient.GetPullRequest(ctx, "kubernetes-sigs", "kubebuilder", 7); err == nil {
+		t.Fatal("got no error from a rate limited call")
+	}
+	if !backoffOf("github.com", ghClient).active() {
+		t.Fatal("calls to the host are not suspended after a rate limit error")
+	}
+
+	// every other call to the host, whatever its caller, is suspended
+	// without reaching Github.
+	_, _, err := ghClient.ListComments(ctx, "kubernetes-sigs", "kubebuilder", 7, nil)
+	if _, ok := err.(*suspendedError); !ok {
+		t.Errorf("got error %v, want the calls to be suspended", err)
+	}
+	if _, _, err := ghClient.GetPullRequest(ctx, "kubernetes-sigs", "kubebuilder", 7); err == nil {
+		t.Error("got no error from a suspended call")
+	}
+	if limiting.calls != 1 {
+		t.Errorf("got %d calls to Github, want 1", limiting.calls)
+	}
+
+	// the syncer skips the host too.
+	gs := &GithubSyncer{
+		Client:       newFakeClient(trackedPullRequest(7, sha(1))),
+		ghClients:    GithubClients{"github.com": ghClient},
+		backoffs:     map[string]*backoff{"github.com": backoffOf("github.com", ghClient)},
+		concurrency:  1,
+		statusWriter: newFakeClient(),
+		enablePRSync: true,
+	}
+	gs.syncPullRequests()
+	if limiting.calls != 1 {
+		t.Errorf("got %d calls to Github, want 1", limiting.calls)
+	}
+}
@@ -0,0 +1,446 @@
+package pullrequest
+
+import (
+	"context"
+	"log"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/controller"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/event"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/handler"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/predicate"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/source"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RepositoryController watches Repository objects and creates a PullRequest
+// object, owned by the Repository, for each open PR of the repository which is
+// selected by its filters. The PullRequest objects of open PRs which are no
+// longer selected are deleted, the ones of closed PRs are left to the closed
+// PR policy of the GithubSyncer.
+type RepositoryController struct {
+	controller.Controller
+}
+
+// NewRepositoryController returns a RepositoryController which discovers the
+// open PRs of the repositories from the listings of the periodic syncs of the
+// GithubSyncer.
+func NewRepositoryController(mgr manager.Manager, syncer *GithubSyncer) (*RepositoryController, error) {
+	statusWriter, err := newStatusWriter(mgr)
+	if err != nil {
+		return nil, err
+	}
+	r := &repositoryReconciler{
+		Client:       mgr.GetClient(),
+		statusWriter: statusWriter,
+		ghClients:    syncer.ghClients,
+		listings:     syncer.listings,
+		selections:   map[types.NamespacedName]map[int64]prSelection{},
+	}
+	c, err := controller.New("repository-controller", mgr, controller.Options{Reconcile: r})
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch Repository objects. Only the updates of their spec are
+	// reconciled, not the ones of their status or metadata.
+	if err := c.Watch(
+		&source.Kind{Type: &v1alpha1.Repository{}},
+		&handler.Enqueue{},
+		predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration()
+			},
+		}); err != nil {
+		return nil, err
+	}
+
+	// Watch PullRequest objects created for Repository objects. Only their
+	// creation and deletion change the status of the Repository, their
+	// updates, e.g. by every sync, are ignored.
+	if err := c.Watch(
+		&source.Kind{Type: &v1alpha1.PullRequest{}},
+		&handler.EnqueueOwner{
+			OwnerType:    &v1alpha1.Repository{},
+			IsController: true,
+		},
+		predicate.Funcs{
+			UpdateFunc:  func(event.UpdateEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		}); err != nil {
+		return nil, err
+	}
+
+	// Reconcile the Repository objects whenever the periodic syncs have
+	// listed the open PRs of their repo, to discover new PRs.
+	if err := c.Watch(syncer.repositorySource(), &handler.Enqueue{}); err != nil {
+		return nil, err
+	}
+	return &RepositoryController{Controller: c}, nil
+}
+
+// repositoryReconciler creates and deletes the PullRequest objects of a
+// Repository.
+type repositoryReconciler struct {
+	Client       client.Client
+	statusWriter StatusWriter
+	ghClients    GithubClients
+	// listings are the open PRs listed by the GithubSyncer.
+	listings *openPullRequestListings
+	// selections are the results of the filters of the Repository objects
+	// by PR number, only accessed by Reconcile which runs in one worker.
+	selections map[types.NamespacedName]map[int64]prSelection
+}
+
+// prSelection records whether a PR passes the filters of a Repository. Labeling
+// a PR updates it, so the labels and files of the PR are only fetched again
+// once it is pushed or updated, or the Repository changes.
+type prSelection struct {
+	uid        types.UID
+	generation int64
+	headSHA    string
+	updatedAt  time.Time
+	selected   bool
+}
+
+// repositoryHost returns the Github host of the Repository.
+func repositoryHost(repo *v1alpha1.Repository) string {
+	if repo.Spec.Host == "" {
+		return "github.com"
+	}
+	return repo.Spec.Host
+}
+
+func (r *repositoryReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+
+	repo := &v1alpha1.Repository{}
+	err := r.Client.Get(ctx, request.NamespacedName, repo)
+	if errors.IsNotFound(err) {
+		delete(r.selections, request.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		log.Printf("Could not fetch Repository %v for %+v\n", err, request)
+		return reconcile.Result{}, err
+	}
+
+	host := repositoryHost(repo)
+	ghClient, found := r.ghClients[host]
+	if !found {
+		log.Printf("ignoring repository %v: no Github API configured for host %s", request.NamespacedName, host)
+		return reconcile.Result{}, nil
+	}
+
+	ghPRs := r.listings.get(host, repo.Spec.Org, repo.Spec.Repo)
+	if ghPRs == nil {
+		// the repo of a new Repository is listed right away, the periodic
+		// syncs list it afterwards.
+		ghPRs, err = listOpenPullRequests(ghClient, repo.Spec.Org, repo.Spec.Repo, nil)
+		if err != nil {
+			log.Printf("cannont get PR list from GH for repository %v: %v", request.NamespacedName, err)
+			return reconcile.Result{}, err
+		}
+		r.listings.set(host, repo.Spec.Org, repo.Spec.Repo, ghPRs)
+	}
+
+	owned, err := r.ownedPullRequests(ctx, repo)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	selected, err := r.selectPullRequests(ctx, ghClient, repo, ghPRs, owned)
+	if err != nil {
+		log.Printf("error selecting the PRs of repository %v: %v", request.NamespacedName, err)
+		return reconcile.Result{}, err
+	}
+
+	var names []string
+	for _, ghPR := range selected {
+		name, err := r.ensurePullRequest(ctx, repo, ghPR, owned)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for num, pr := range owned {
+		if _, open := ghPRs[num]; !open || containsString(names, pr.Name) {
+			continue
+		}
+		log.Printf("deleting PullRequest %s/%s, PR is no longer selected by its repository", pr.Namespace, pr.Name)
+		if err := r.Client.Delete(ctx, pr); err != nil && !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+	}
+
+	status := repo.Status
+	if reflect.DeepEqual(status.PullRequests, names) && status.ObservedGeneration == repo.Generation {
+		return reconcile.Result{}, nil
+	}
+	repoCopy := repo.DeepCopy()
+	repoCopy.Status.PullRequests = names
+	repoCopy.Status.ObservedGeneration = repo.Generation
+	if err := r.statusWriter.UpdateRepositoryStatus(ctx, repoCopy); err != nil {
+		log.Printf("error updating the status of repository %v: %v", request.NamespacedName, err)
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// ownedPullRequests returns the PullRequest objects created for the
+// Repository by PR number.
+func (r *repositoryReconciler) ownedPullRequests(ctx context.Context, repo *v1alpha1.Repository) (map[int64]*v1alpha1.PullRequest, error) {
+	prList := &v1alpha1.PullRequestList{}
+	if err := r.Client.List(ctx, &client.ListOptions{Namespace: repo.Namespace}, prList); err != nil {
+		return nil, err
+	}
+	owned := map[int64]*v1alpha1.PullRequest{}
+	for i := range prList.Items {
+		pr := &prList.Items[i]
+		ref := metav1.GetControllerOf(pr)
+		if ref == nil || ref.UID != repo.UID {
+			continue
+		}
+		prinfo, err := parsePullRequestURL(pr.Spec.URL)
+		if err != nil {
+			continue
+		}
+		owned[prinfo.pr] = pr
+	}
+	return owned, nil
+}
+
+// selectPullRequests returns the open PRs selected by the filters of the
+// Repository, at most MaxPreviews of them. PRs which already have a PullRequest
+// object keep their preview, the oldest of the other PRs come next.
+func (r *repositoryReconciler) selectPullRequests(ctx context.Context, ghClient GithubClient, repo *v1alpha1.Repository, ghPRs map[int64]*github.PullRequest, owned map[int64]*v1alpha1.PullRequest) ([]*github.PullRequest, error) {
+	var nums []int64
+	for num := range ghPRs {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool {
+		_, ownedI := owned[nums[i]]
+		_, ownedJ := owned[nums[j]]
+		if ownedI != ownedJ {
+			return ownedI
+		}
+		return nums[i] < nums[j]
+	})
+
+	var selected []*github.PullRequest
+	for _, num := range nums {
+		if repo.Spec.MaxPreviews > 0 && len(selected) >= int(repo.Spec.MaxPreviews) {
+			break
+		}
+		ok, err := r.selects(ctx, ghClient, repo, ghPRs[num])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, ghPRs[num])
+		}
+	}
+
+	// forget the selection of the PRs which are no longer open.
+	name := types.NamespacedName{Namespace: repo.Namespace, Name: repo.Name}
+	for num := range r.selections[name] {
+		if _, open := ghPRs[num]; !open {
+			delete(r.selections[name], num)
+		}
+	}
+	return selected, nil
+}
+
+// selects returns true if the PR passes the filters of the Repository. The
+// selection is recorded, and decided again only once the PR or the Repository
+// changed.
+func (r *repositoryReconciler) selects(ctx context.Context, ghClient GithubClient, repo *v1alpha1.Repository, ghPR *github.PullRequest) (bool, error) {
+	name := types.NamespacedName{Namespace: repo.Namespace, Name: repo.Name}
+	num := int64(ghPR.GetNumber())
+	s, found := r.selections[name][num]
+	if found && s.uid == repo.UID && s.generation == repo.Generation &&
+		s.headSHA == ghPR.GetHead().GetSHA() && s.updatedAt.Equal(ghPR.GetUpdatedAt()) {
+		return s.selected, nil
+	}
+	ok, err := selectsPullRequest(ctx, ghClient, &repo.Spec, ghPR)
+	if err != nil {
+		return false, err
+	}
+	if r.selections[name] == nil {
+		r.selections[name] = map[int64]prSelection{}
+	}
+	r.selections[name][num] = prSelection{
+		uid:        repo.UID,
+		generation: repo.Generation,
+		headSHA:    ghPR.GetHead().GetSHA(),
+		updatedAt:  ghPR.GetUpdatedAt(),
+		selected:   ok,
+	}
+	return ok, nil
+}
+
+// selectsPullRequest returns true if the PR passes the filters of the spec.
+// The author and base branch filters must all match, while matching either the
+// label or the path filter is enough.
+func selectsPullRequest(ctx context.Context, ghClient GithubClient, spec *v1alpha1.RepositorySpec, ghPR *github.PullRequest) (bool, error) {
+	if len(spec.Authors) > 0 && !containsString(spec.Authors, ghPR.GetUser().GetLogin()) {
+		return false, nil
+	}
+	if len(spec.BaseBranches) > 0 && !containsString(spec.BaseBranches, ghPR.GetBase().GetRef()) {
+		return false, nil
+	}
+	if len(spec.Labels) == 0 && len(spec.Paths) == 0 {
+		return true, nil
+	}
+	if len(spec.Labels) > 0 {
+		labeled, err := hasLabel(ctx, ghClient, spec.Org, spec.Repo, ghPR.GetNumber(), spec.Labels)
+		if err != nil || labeled {
+			return labeled, err
+		}
+	}
+	if len(spec.Paths) == 0 {
+		return false, nil
+	}
+	return touchesPaths(ctx, ghClient, spec, ghPR)
+}
+
+// hasLabel returns true if the PR carries one of the labels.
+func hasLabel(ctx context.Context, ghClient GithubClient, org, repo string, number int, labels []string) (bool, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		prLabels, resp, err := ghClient.ListLabelsByIssue(ctx, org, repo, number, opt)
+		if err != nil {
+			return false, err
+		}
+		for _, l := range prLabels {
+			if containsString(labels, l.GetName()) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// touchesPaths returns true if the PR changes a file matching one of the
+// path patterns of the spec.
+func touchesPaths(ctx context.Context, ghClient GithubClient, spec *v1alpha1.RepositorySpec, ghPR *github.PullRequest) (bool, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := ghClient.ListFiles(ctx, spec.Org, spec.Repo, ghPR.GetNumber(), opt)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range files {
+			if matchesPath(spec.Paths, f.GetFilename()) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// matchesPath returns true if the file matches one of the glob patterns.
+// Patterns without a slash are matched against the base name of the file.
+func matchesPath(patterns []string, filename string) bool {
+	for _, pattern := range patterns {
+		name := filename
+		if !strings.ContainsRune(pattern, '/') {
+			name = path.Base(filename)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ensurePullRequest creates the PullRequest object of a selected PR if it does
+// not exist yet, and keeps the modules it documents and how they are fetched
+// in sync with the Repository. It returns the name of the PullRequest object, or an empty
+// string if the object exists but is not owned by the Repository.
+func (r *repositoryReconciler) ensurePullRequest(ctx context.Context, repo *v1alpha1.Repository, ghPR *github.PullRequest, owned map[int64]*v1alpha1.PullRequest) (string, error) {
+	if pr, found := owned[int64(ghPR.GetNumber())]; found {
+		if !reflect.DeepEqual(pr.Spec.Modules, repo.Spec.Modules) || !reflect.DeepEqual(pr.Spec.Fetch, repo.Spec.Fetch) {
+			prCopy := pr.DeepCopy()
+			prCopy.Spec.Modules = repo.Spec.Modules
+			prCopy.Spec.Fetch = repo.Spec.Fetch
+			if err := r.Client.Update(ctx, prCopy); err != nil {
+				return "", err
+			}
+		}
+		return pr.Name, nil
+	}
+	prinfo, err := parsePullRequestURL(ghPR.GetHTMLURL())
+	if err != nil {
+		log.Printf("ignoring PR %q: %v", ghPR.GetHTMLURL(), err)
+		return "", nil
+	}
+
+	name := types.NamespacedName{Namespace: repo.Namespace, Name: prinfo.name()}
+	err = r.Client.Get(ctx, name, &v1alpha1.PullRequest{})
+	if err == nil {
+		// registered by other means.
+		return "", nil
+	}
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	pr := &v1alpha1.PullRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+		},
+		Spec: v1alpha1.PullRequestSpec{
+			URL:          ghPR.GetHTMLURL(),
+			CommitID:     ghPR.GetHead().GetSHA(),
+			BaseCommitID: ghPR.GetBase().GetSHA(),
+			Modules:      repo.Spec.Modules,
+			Fetch:        repo.Spec.Fetch,
+		},
+	}
+	addOwnerRefToObject(pr, *metav1.NewControllerRef(repo, schema.GroupVersionKind{
+		Group:   v1alpha1.SchemeGroupVersion.Group,
+		Version: v1alpha1.SchemeGroupVersion.Version,
+		Kind:    "Repository",
+	}))
+	log.Printf("creating PullRequest %s for %s", name, ghPR.GetHTMLURL())
+	if err := r.Client.Create(ctx, pr); err != nil {
+		if errors.IsAlreadyExists(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return name.Name, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
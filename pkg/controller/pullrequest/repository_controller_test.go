@@ -0,0 +1,205 @@
+package pullrequest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/event"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/reconcile"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// countingGithubClient is a fakeGithubClient counting the calls listing the
+// PRs of a repo, and the labels of a PR.
+type countingGithubClient struct {
+	*fakeGithubClient
+	lists  int
+	labels int
+}
+
+func (c *countingGithubClient) ListPullRequests(ctx context.Context, owner, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	c.lists++
+	return c.fakeGithubClient.ListPullRequests(ctx, owner, repo, opt)
+}
+
+func (c *countingGithubClient) ListLabelsByIssue(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
+	c.labels++
+	return c.fakeGithubClient.ListLabelsByIssue(ctx, owner, repo, number, opt)
+}
+
+func newTestRepositoryReconciler(c *fakeClient, ghClient GithubClient) *repositoryReconciler {
+	return &repositoryReconciler{
+		Client:       c,
+		statusWriter: c,
+		ghClients:    GithubClients{"github.com": ghClient},
+		listings:     newOpenPullRequestListings(),
+		selections:   map[types.NamespacedName]map[int64]prSelection{},
+	}
+}
+
+// openRepositoryPullRequest returns an open PR of kubernetes-sigs/kubebuilder.
+func openRepositoryPullRequest(n int, headSHA string) *github.PullRequest {
+	ghPR := ghPullRequest(n, "open", false, headSHA, sha(0))
+	ghPR.HTMLURL = github.String(trackedPullRequest(n, "").Spec.URL)
+	return ghPR
+}
+
+func TestRepositoryReconcileStatus(t *testing.T) {
+	repo := &v1alpha1.Repository{
+		ObjectMeta: metaFor(syncerTestNamespace, "kubebuilder"),
+		Spec:       v1alpha1.RepositorySpec{Org: "kubernetes-sigs", Repo: "kubebuilder"},
+	}
+	c := newFakeClient(repo)
+	ghClient := newFakeGithubClient()
+	for n := 1; n <= 2; n++ {
+		ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", openRepositoryPullRequest(n, sha(n)))
+	}
+	r := newTestRepositoryReconciler(c, ghClient)
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: repo.Namespace, Name: repo.Name}}
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+
+	// the status is written through the status subresource.
+	got := &v1alpha1.Repository{}
+	if err := c.Get(context.Background(), request.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"kubernetes-sigs-kubebuilder-pr-1", "kubernetes-sigs-kubebuilder-pr-2"}
+	if !reflect.DeepEqual(got.Status.PullRequests, want) {
+		t.Errorf("got PullRequests %v in the status, want %v", got.Status.PullRequests, want)
+	}
+	if c.statusUpdates != 1 || c.updates != 0 {
+		t.Errorf("got %d status updates and %d updates, want 1 status update", c.statusUpdates, c.updates)
+	}
+
+	// updates of the Repository keep its status.
+	got.Spec.MaxPreviews = 1
+	got.Status.PullRequests = nil
+	if err := c.Update(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(context.Background(), request.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Status.PullRequests, want) {
+		t.Errorf("got PullRequests %v in the status after an update, want %v", got.Status.PullRequests, want)
+	}
+
+	// an unchanged status is not written again.
+	got.Spec.MaxPreviews = 0
+	if err := c.Update(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	c.statusUpdates = 0
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	if c.statusUpdates != 1 {
+		t.Errorf("got %d status updates, want 1 for the new generation", c.statusUpdates)
+	}
+}
+
+func TestRepositoryReconcileGithubCalls(t *testing.T) {
+	repo := &v1alpha1.Repository{
+		ObjectMeta: metaFor(syncerTestNamespace, "kubebuilder"),
+		Spec:       v1alpha1.RepositorySpec{Org: "kubernetes-sigs", Repo: "kubebuilder", Labels: []string{"docs"}},
+	}
+	c := newFakeClient(repo)
+	ghClient := &countingGithubClient{fakeGithubClient: newFakeGithubClient()}
+	ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", openRepositoryPullRequest(1, sha(1)))
+	ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", openRepositoryPullRequest(2, sha(2)))
+	ghClient.SetLabels("kubernetes-sigs", "kubebuilder", 1, "docs")
+	r := newTestRepositoryReconciler(c, ghClient)
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: repo.Namespace, Name: repo.Name}}
+	assertPullRequests := func(want ...string) {
+		t.Helper()
+		got := &v1alpha1.Repository{}
+		if err := c.Get(context.Background(), request.NamespacedName, got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Status.PullRequests, want) {
+			t.Errorf("got PullRequests %v in the status, want %v", got.Status.PullRequests, want)
+		}
+	}
+
+	// the repo of a new Repository is listed, and the labels of its PRs
+	// are fetched.
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	assertPullRequests("kubernetes-sigs-kubebuilder-pr-1")
+	if ghClient.lists != 1 || ghClient.labels != 2 {
+		t.Fatalf("got %d listings and %d label fetches, want 1 and 2", ghClient.lists, ghClient.labels)
+	}
+
+	// reconciling it again calls Github no more.
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	if ghClient.lists != 1 || ghClient.labels != 2 {
+		t.Fatalf("got %d listings and %d label fetches after a reconcile, want none", ghClient.lists-1, ghClient.labels-2)
+	}
+
+	// the PR pushed and labeled since is selected once the syncer listed
+	// it, only its labels are fetched again.
+	ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", openRepositoryPullRequest(2, sha(3)))
+	ghClient.SetLabels("kubernetes-sigs", "kubebuilder", 2, "docs")
+	ghPRs, err := listOpenPullRequests(ghClient.fakeGithubClient, "kubernetes-sigs", "kubebuilder", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.listings.set("github.com", "kubernetes-sigs", "kubebuilder", ghPRs)
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	assertPullRequests("kubernetes-sigs-kubebuilder-pr-1", "kubernetes-sigs-kubebuilder-pr-2")
+	if ghClient.lists != 1 || ghClient.labels != 3 {
+		t.Fatalf("got %d listings and %d label fetches after a push, want 0 and 1", ghClient.lists-1, ghClient.labels-2)
+	}
+}
+
+func TestSyncPullRequestsListsRepositories(t *testing.T) {
+	repo := &v1alpha1.Repository{
+		ObjectMeta: metaFor(syncerTestNamespace, "kubebuilder"),
+		Spec:       v1alpha1.RepositorySpec{Org: "kubernetes-sigs", Repo: "kubebuilder"},
+	}
+	c := newFakeClient(repo)
+	ghClient := newFakeGithubClient()
+	for n := 1; n <= 5; n++ {
+		ghClient.AddPullRequest("kubernetes-sigs", "kubebuilder", openRepositoryPullRequest(n, sha(n)))
+	}
+	gs := &GithubSyncer{
+		Client:       c,
+		statusWriter: c,
+		ghClients:    GithubClients{"github.com": ghClient},
+		backoffs:     map[string]*backoff{"github.com": {host: "github.com"}},
+		concurrency:  1,
+		listings:     newOpenPullRequestListings(),
+		repositories: make(chan event.GenericEvent, 1),
+	}
+	// the Repository of a deleted repo was listed before.
+	gs.listings.set("github.com", "kubernetes-sigs", "deleted", map[int64]*github.PullRequest{})
+
+	gs.syncPullRequests()
+	if got := gs.listings.get("github.com", "kubernetes-sigs", "kubebuilder"); len(got) != 5 {
+		t.Errorf("got %d open PRs listed, want 5", len(got))
+	}
+	if got := gs.listings.get("github.com", "kubernetes-sigs", "deleted"); got != nil {
+		t.Errorf("got the listing %v of a repo without Repository, want it dropped", got)
+	}
+	select {
+	case e := <-gs.repositories:
+		if e.Meta.GetName() != repo.Name {
+			t.Errorf("got Repository %s enqueued, want %s", e.Meta.GetName(), repo.Name)
+		}
+	default:
+		t.Error("the listed Repository was not enqueued")
+	}
+}
@@ -0,0 +1,58 @@
+package pullrequest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/event"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/source"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// requeuer makes PullRequests be reconciled again after a delay, as the
+// vendored controller-runtime has no reconcile.Result.RequeueAfter. The
+// controller watches its source with handler.Enqueue.
+type requeuer struct {
+	events chan event.GenericEvent
+
+	mu sync.Mutex
+	// due is when the PullRequests are next requeued by their key.
+	due map[types.NamespacedName]time.Time
+}
+
+func newRequeuer() *requeuer {
+	return &requeuer{
+		events: make(chan event.GenericEvent),
+		due:    map[types.NamespacedName]time.Time{},
+	}
+}
+
+// source returns the source of the requeued PullRequests.
+func (q *requeuer) source() source.Source {
+	return &source.Channel{Source: q.events}
+}
+
+// after requeues pr once d has passed, unless it is already due to be
+// requeued before that.
+func (q *requeuer) after(pr *v1alpha1.PullRequest, d time.Duration) {
+	key := types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}
+	due := time.Now().Add(d)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t, found := q.due[key]; found && !t.After(due) {
+		return
+	}
+	q.due[key] = due
+	time.AfterFunc(d, func() {
+		q.mu.Lock()
+		if q.due[key] == due {
+			delete(q.due, key)
+		}
+		q.mu.Unlock()
+		obj := &v1alpha1.PullRequest{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+		q.events <- event.GenericEvent{Meta: obj, Object: obj}
+	})
+}
@@ -0,0 +1,53 @@
+package pullrequest
+
+import (
+	"context"
+
+	"github.com/droot/godocbot/pkg/apis/code/v1alpha1"
+	"github.com/droot/godocbot/pkg/client/clientset/versioned"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/manager"
+)
+
+// StatusWriter updates the status subresource of PullRequest and Repository
+// objects. The controller-runtime client can only update whole objects, and
+// those updates ignore the status once the status subresource is enabled.
+type StatusWriter interface {
+	// UpdateStatus writes the status of pr and updates pr with the object
+	// returned by the server.
+	UpdateStatus(ctx context.Context, pr *v1alpha1.PullRequest) error
+	// UpdateRepositoryStatus writes the status of repo and updates repo with
+	// the object returned by the server.
+	UpdateRepositoryStatus(ctx context.Context, repo *v1alpha1.Repository) error
+}
+
+// newStatusWriter returns a StatusWriter using the generated clientset.
+func newStatusWriter(mgr manager.Manager) (StatusWriter, error) {
+	cs, err := versioned.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &clientsetStatusWriter{cs: cs}, nil
+}
+
+// clientsetStatusWriter implements StatusWriter with the generated clientset.
+type clientsetStatusWriter struct {
+	cs versioned.Interface
+}
+
+func (w *clientsetStatusWriter) UpdateStatus(ctx context.Context, pr *v1alpha1.PullRequest) error {
+	updated, err := w.cs.CodeV1alpha1().PullRequests(pr.Namespace).UpdateStatus(pr)
+	if err != nil {
+		return err
+	}
+	*pr = *updated
+	return nil
+}
+
+func (w *clientsetStatusWriter) UpdateRepositoryStatus(ctx context.Context, repo *v1alpha1.Repository) error {
+	updated, err := w.cs.CodeV1alpha1().Repositories(repo.Namespace).UpdateStatus(repo)
+	if err != nil {
+		return err
+	}
+	*repo = *updated
+	return nil
+}
@@ -0,0 +1,179 @@
+package githubauth
+
+import (
+	"bytes"
+	"container/list"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+var (
+	// rateLimitRemaining records the number of requests left in the current
+	// rate limit window of each Github API host.
+	rateLimitRemaining = expvar.NewMap("github_rate_limit_remaining")
+	// rateLimit records the number of requests allowed per rate limit window
+	// of each Github API host.
+	rateLimit = expvar.NewMap("github_rate_limit")
+	// rateLimitReset records when the current rate limit window of each
+	// Github API host ends, in seconds since the epoch.
+	rateLimitReset = expvar.NewMap("github_rate_limit_reset")
+	// notModifiedResponses counts the conditional requests answered from the
+	// cache for each Github API host.
+	notModifiedResponses = expvar.NewMap("github_not_modified_responses")
+)
+
+// maxCachedResponses is the number of responses kept by a cachingTransport,
+// the least recently used ones are evicted past it.
+const maxCachedResponses = 1000
+
+// cachedResponse is a response to a GET request along with its ETag.
+type cachedResponse struct {
+	url    string
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// cachingTransport makes conditional requests with the ETag of the previous
+// response to the same URL. Github does not count requests answered with 304
+// Not Modified against the rate limit; the cached response is returned in
+// their place. It also records the rate limit headers of every response.
+type cachingTransport struct {
+	base http.RoundTripper
+	// size is the maximum number of cached responses.
+	size int
+
+	mu sync.Mutex
+	// responses indexes the elements of lru by URL.
+	responses map[string]*list.Element
+	// lru holds the cached responses, the most recently used first.
+	lru *list.List
+}
+
+func newCachingTransport(base http.RoundTripper) *cachingTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &cachingTransport{
+		base:      base,
+		size:      maxCachedResponses,
+		responses: map[string]*list.Element{},
+		lru:       list.New(),
+	}
+}
+
+// get returns the cached response to the URL, nil if there is none.
+func (t *cachingTransport) get(url string) *cachedResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, found := t.responses[url]
+	if !found {
+		return nil
+	}
+	t.lru.MoveToFront(e)
+	return e.Value.(*cachedResponse)
+}
+
+// add caches the response, evicting the least recently used response if the
+// cache is full.
+func (t *cachingTransport) add(r *cachedResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, found := t.responses[r.url]; found {
+		e.Value = r
+		t.lru.MoveToFront(e)
+		return
+	}
+	t.responses[r.url] = t.lru.PushFront(r)
+	for t.lru.Len() > t.size {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.responses, oldest.Value.(*cachedResponse).url)
+	}
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return t.roundTrip(req)
+	}
+
+	key := req.URL.String()
+	cached := t.get(key)
+
+	if cached != nil {
+		// RoundTrippers must not modify the request they are given.
+		r := new(http.Request)
+		*r = *req
+		r.Header = make(http.Header, len(req.Header)+1)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		r.Header.Set("If-None-Match", cached.etag)
+		req = r
+	}
+
+	resp, err := t.roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		notModifiedResponses.Add(req.URL.Host, 1)
+		header := make(http.Header, len(cached.header))
+		for k, v := range cached.header {
+			header[k] = v
+		}
+		// the rate limit headers of the fresh response are the current
+		// ones.
+		for _, k := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+			if v := resp.Header.Get(k); v != "" {
+				header.Set(k, v)
+			}
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = header
+		resp.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+		resp.ContentLength = int64(len(cached.body))
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.add(&cachedResponse{
+			url:    key,
+			etag:   resp.Header.Get("ETag"),
+			header: resp.Header,
+			body:   body,
+		})
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return resp, nil
+}
+
+// roundTrip sends the request and records the rate limit headers of the
+// response.
+func (t *cachingTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	for m, k := range map[*expvar.Map]string{
+		rateLimit:          "X-RateLimit-Limit",
+		rateLimitRemaining: "X-RateLimit-Remaining",
+		rateLimitReset:     "X-RateLimit-Reset",
+	} {
+		if v, err := strconv.ParseInt(resp.Header.Get(k), 10, 64); err == nil {
+			i := new(expvar.Int)
+			i.Set(v)
+			m.Set(req.URL.Host, i)
+		}
+	}
+	return resp, nil
+}
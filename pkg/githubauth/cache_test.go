@@ -0,0 +1,64 @@
+package githubauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCachingTransport(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		etag := `"` + r.URL.Path + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	transport := newCachingTransport(nil)
+	transport.size = 2
+	client := &http.Client{Transport: transport}
+	get := func(path string) {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != path {
+			t.Errorf("GET %s: got %d %q, want 200 %q", path, resp.StatusCode, body, path)
+		}
+	}
+
+	// /a is used more recently than /b, which is evicted by /c.
+	for _, path := range []string{"/a", "/b", "/a", "/c"} {
+		get(path)
+	}
+	if transport.lru.Len() != 2 || len(transport.responses) != 2 {
+		t.Fatalf("got %d cached responses, want 2", transport.lru.Len())
+	}
+	for _, path := range []string{"/a", "/c"} {
+		if transport.get(srv.URL+path) == nil {
+			t.Errorf("response to %s was evicted", path)
+		}
+	}
+	if transport.get(srv.URL+"/b") != nil {
+		t.Error("response to /b was not evicted")
+	}
+
+	// the cached responses are returned in place of 304 Not Modified.
+	get("/a")
+	if requests["/a"] != 3 {
+		t.Errorf("got %d requests to /a, want 3", requests["/a"])
+	}
+}
@@ -0,0 +1,185 @@
+// Package githubauth builds authenticated HTTP clients for the Github API. It
+// supports personal access tokens and Github App installations, for which
+// installation tokens are minted and refreshed as they expire. The clients make
+// conditional requests to spare the rate limit, whose headroom is published
+// with expvar.
+package githubauth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/go-github/github"
+	"github.com/kubernetes-sigs/controller-runtime/pkg/client"
+	"golang.org/x/oauth2"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Credentials to authenticate with Github. Either Token or the Github App
+// fields must be set; with none of them set requests are made anonymously.
+type Credentials struct {
+	// Token is a personal access token.
+	Token string
+
+	// AppID is the ID of the Github App.
+	AppID int64
+	// InstallationID is the ID of the installation of the Github App whose
+	// repositories are accessed.
+	InstallationID int64
+	// PrivateKey is the PEM encoded private key of the Github App.
+	PrivateKey []byte
+}
+
+// IsApp returns true if the credentials are for a Github App.
+func (c *Credentials) IsApp() bool {
+	return c.AppID != 0
+}
+
+// NewHTTPClient returns an HTTP client which authenticates its requests with
+// the given credentials. baseURL is the Github API endpoint installation
+// tokens are minted from, it defaults to https://api.github.com/ if empty.
+func NewHTTPClient(creds Credentials, baseURL string) (*http.Client, error) {
+	c, err := newAuthenticatedClient(creds, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: newCachingTransport(c.Transport)}, nil
+}
+
+// Validate checks that the credentials of a Github App are complete and that
+// its private key is a valid PEM encoded RSA key, so that misconfigured
+// credentials are reported at startup rather than when the first installation
+// token is minted.
+func (c *Credentials) Validate() error {
+	if !c.IsApp() {
+		return nil
+	}
+	if c.InstallationID == 0 {
+		return fmt.Errorf("installation ID is required for Github App %d", c.AppID)
+	}
+	if len(c.PrivateKey) == 0 {
+		return fmt.Errorf("private key is required for Github App %d", c.AppID)
+	}
+	if _, err := jwt.ParseRSAPrivateKeyFromPEM(c.PrivateKey); err != nil {
+		return fmt.Errorf("error parsing the private key of Github App %d: %v", c.AppID, err)
+	}
+	return nil
+}
+
+// newAuthenticatedClient returns an HTTP client which authenticates its
+// requests with the given credentials.
+func newAuthenticatedClient(creds Credentials, baseURL string) (*http.Client, error) {
+	switch {
+	case creds.IsApp():
+		if err := creds.Validate(); err != nil {
+			return nil, err
+		}
+		key, _ := jwt.ParseRSAPrivateKeyFromPEM(creds.PrivateKey)
+		appClient := &http.Client{Transport: &appTransport{appID: creds.AppID, key: key}}
+		ghClient := github.NewClient(appClient)
+		if baseURL != "" {
+			u, err := url.Parse(baseURL)
+			if err != nil {
+				return nil, err
+			}
+			ghClient.BaseURL = u
+		}
+		ts := &installationTokenSource{
+			installationID: creds.InstallationID,
+			apps:           ghClient.Apps,
+		}
+		return oauth2.NewClient(context.Background(), oauth2.ReuseTokenSource(nil, ts)), nil
+	case creds.Token != "":
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: creds.Token})
+		return oauth2.NewClient(context.Background(), ts), nil
+	default:
+		return http.DefaultClient, nil
+	}
+}
+
+// appTransport authenticates requests as the Github App itself using a short
+// lived JWT signed with the private key of the App. It is only used to mint
+// installation tokens.
+type appTransport struct {
+	appID int64
+	key   interface{}
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+		// backdate to allow for clock drift between us and Github
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(9 * time.Minute).Unix(),
+		Issuer:    fmt.Sprintf("%d", t.appID),
+	})
+	signed, err := token.SignedString(t.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the request they are given.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+signed)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// installationTokenSource mints installation tokens for a Github App
+// installation. It is wrapped in oauth2.ReuseTokenSource, so a new token is
+// only minted once the current one expires.
+type installationTokenSource struct {
+	installationID int64
+	apps           *github.AppsService
+}
+
+func (ts *installationTokenSource) Token() (*oauth2.Token, error) {
+	t, _, err := ts.apps.CreateInstallationToken(context.Background(), ts.installationID)
+	if err != nil {
+		return nil, fmt.Errorf("error minting token for installation %d: %v", ts.installationID, err)
+	}
+	return &oauth2.Token{
+		AccessToken: t.GetToken(),
+		TokenType:   "token",
+		Expiry:      t.GetExpiresAt(),
+	}, nil
+}
+
+// ReadTokenFile reads a token from the given file, ignoring surrounding
+// whitespace.
+func ReadTokenFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// ReadSecretKey reads the value of key in the Secret referred to by
+// "namespace/name".
+func ReadSecretKey(ctx context.Context, c client.Client, secretRef, key string) ([]byte, error) {
+	parts := strings.SplitN(secretRef, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("secret %q must be of the form namespace/name", secretRef)
+	}
+	secret := &v1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: parts[0], Name: parts[1]}, secret); err != nil {
+		return nil, err
+	}
+	value, found := secret.Data[key]
+	if !found {
+		return nil, fmt.Errorf("secret %q has no key %q", secretRef, key)
+	}
+	return value, nil
+}
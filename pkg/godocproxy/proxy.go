@@ -0,0 +1,115 @@
+// Package godocproxy serves a godoc server under path prefixes. godoc links
+// its pages and assets with absolute paths such as /pkg/ and /lib/godoc/, which
+// break when an Ingress routes a path prefix of a shared host to it. The proxy
+// strips the prefix from the requests and adds it back to the absolute links
+// and redirects of the responses.
+package godocproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// prefixHeader carries the stripped prefix from the request to the rewriting
+// of its response.
+const prefixHeader = "X-Forwarded-Prefix"
+
+// placeholders of the path patterns and the path segments they match. Orgs
+// may be GitLab groups with subgroups, which span several segments.
+var placeholders = map[string]string{
+	"{name}": `[^/]+`,
+	"{org}":  `.+?`,
+	"{repo}": `[^/]+`,
+	"{pr}":   `[0-9]+`,
+}
+
+// absoluteLink matches the attributes of HTML pages linking to absolute paths
+// of the godoc server, but not to other hosts with //host/path.
+var absoluteLink = regexp.MustCompile(`\b((?:href|src|action)=["'])/([^/])`)
+
+// Proxy serves the godoc server at target under the path prefixes matching a
+// pattern.
+type Proxy struct {
+	prefix *regexp.Regexp
+	rp     *httputil.ReverseProxy
+}
+
+// New returns a Proxy to target serving the prefixes matching pattern, in
+// which the placeholders {name}, {org}, {repo} and {pr} match the PullRequest
+// object name, the org, the repo and the PR number, e.g. /pr/{org}/{repo}/{pr}.
+func New(target *url.URL, pattern string) (*Proxy, error) {
+	prefix, err := compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{prefix: prefix}
+	p.rp = &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = target.Scheme
+			r.URL.Host = target.Host
+			// the pages are rewritten, so they are not compressed.
+			r.Header.Del("Accept-Encoding")
+		},
+		ModifyResponse: rewrite,
+	}
+	return p, nil
+}
+
+// compile returns the regexp matching the prefixes of pattern at the start of
+// a path.
+func compile(pattern string) (*regexp.Regexp, error) {
+	pattern = "/" + strings.Trim(pattern, "/")
+	if pattern == "/" {
+		return nil, fmt.Errorf("path pattern is empty")
+	}
+	expr := regexp.QuoteMeta(pattern)
+	for placeholder, segment := range placeholders {
+		expr = strings.Replace(expr, regexp.QuoteMeta(placeholder), segment, -1)
+	}
+	return regexp.Compile(`^(` + expr + `)(?:/|$)`)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := p.prefix.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	prefix := m[1]
+	r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	r.URL.RawPath = ""
+	r.Header.Set(prefixHeader, prefix)
+	p.rp.ServeHTTP(w, r)
+}
+
+// rewrite adds the prefix of the request back to the redirects and to the
+// absolute links of the HTML pages of the response.
+func rewrite(resp *http.Response) error {
+	prefix := resp.Request.Header.Get(prefixHeader)
+	if loc := resp.Header.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		resp.Header.Set("Location", prefix+loc)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	body = absoluteLink.ReplaceAllFunc(body, func(link []byte) []byte {
+		m := absoluteLink.FindSubmatch(link)
+		return bytes.Join([][]byte{m[1], []byte(prefix), []byte("/"), m[2]}, nil)
+	})
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
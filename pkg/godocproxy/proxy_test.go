@@ -0,0 +1,94 @@
+package godocproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// godoc is a fake godoc server linking its pages with absolute paths.
+func godoc(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/pkg/github.com/org/repo":
+		http.Redirect(w, r, "/pkg/github.com/org/repo/", http.StatusMovedPermanently)
+	case "/pkg/github.com/org/repo/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<link href="/lib/godoc/style.css"><a href="/pkg/">Packages</a> <a href="//golang.org/doc/">Docs</a> <form action="/search">`)
+	case "/lib/godoc/style.css":
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		fmt.Fprint(w, `body { background: url("/lib/godoc/bg.png"); }`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(godoc))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	p, err := New(target, "/pr/{org}/{repo}/{pr}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:     "page",
+			path:     "/pr/org/repo/15/pkg/github.com/org/repo/",
+			wantCode: http.StatusOK,
+			wantBody: `<link href="/pr/org/repo/15/lib/godoc/style.css"><a href="/pr/org/repo/15/pkg/">Packages</a> <a href="//golang.org/doc/">Docs</a> <form action="/pr/org/repo/15/search">`,
+		},
+		{
+			name:     "subgroups",
+			path:     "/pr/group/subgroup/repo/15/pkg/github.com/org/repo/",
+			wantCode: http.StatusOK,
+			wantBody: `<link href="/pr/group/subgroup/repo/15/lib/godoc/style.css"><a href="/pr/group/subgroup/repo/15/pkg/">Packages</a> <a href="//golang.org/doc/">Docs</a> <form action="/pr/group/subgroup/repo/15/search">`,
+		},
+		{
+			name:         "redirect",
+			path:         "/pr/org/repo/15/pkg/github.com/org/repo",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/pr/org/repo/15/pkg/github.com/org/repo/",
+		},
+		{
+			name:     "not html",
+			path:     "/pr/org/repo/15/lib/godoc/style.css",
+			wantCode: http.StatusOK,
+			wantBody: `body { background: url("/lib/godoc/bg.png"); }`,
+		},
+		{
+			name:     "other prefix",
+			path:     "/pkg/github.com/org/repo/",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+			resp := rec.Result()
+			if resp.StatusCode != test.wantCode {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, test.wantCode)
+			}
+			if loc := resp.Header.Get("Location"); loc != test.wantLocation {
+				t.Errorf("got location %q, want %q", loc, test.wantLocation)
+			}
+			if test.wantBody == "" {
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) != test.wantBody {
+				t.Errorf("got body\n%s\nwant\n%s", body, test.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// Package godocsync keeps the worktrees of the PRs served by a shared godoc
+// pod in sync with the list of PRs, without restarting the pod. The list is a
+// ConfigMap mounted as a volume, which the kubelet updates in running pods:
+// each file is named after the GOPATH prefix of a PR, e.g. pr-123, and holds
+// the godoc-fetch arguments checking out its commit, one per line.
+//
+// The commits are checked out in a staging directory of the GOPATH src
+// directory and moved to their prefix once fetched, so that godoc keeps
+// serving the previous commit meanwhile. The prefixes of PRs which are no
+// longer listed are removed.
+package godocsync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stagingDir is the directory of the GOPATH src directory the commits are
+// checked out in. godoc skips the directories starting with a dot.
+const stagingDir = ".sync"
+
+// Status is the state of the worktree of a PR.
+type Status struct {
+	// CommitID of the last checkout, whether it succeeded or not.
+	CommitID string `json:"commit_id"`
+	// ImportPaths documented by the worktree.
+	ImportPaths []string `json:"import_paths,omitempty"`
+	// Failure of the last checkout, the worktree of the previous commit is
+	// still served if there is one.
+	Failure string `json:"failure,omitempty"`
+}
+
+// FetchFunc checks out the commit given by the godoc-fetch arguments in the
+// src directory, and returns the documented import paths.
+type FetchFunc func(args []string, src string) ([]string, error)
+
+// Syncer syncs the worktrees of the src directory with the PRs listed in the
+// config directory, and serves their Status as JSON keyed by prefix.
+type Syncer struct {
+	configDir string
+	srcDir    string
+	fetch     FetchFunc
+	// retryInterval is the delay before failed checkouts are retried.
+	retryInterval time.Duration
+
+	mu       sync.Mutex
+	statuses map[string]*Status
+	// args and failed are the arguments of the last checkout of the
+	// prefixes, and the time it failed at.
+	args   map[string][]string
+	failed map[string]time.Time
+}
+
+// NewSyncer returns a Syncer of the PRs listed in configDir, checked out in
+// srcDir by fetch.
+func NewSyncer(configDir, srcDir string, fetch FetchFunc, retryInterval time.Duration) *Syncer {
+	return &Syncer{
+		configDir:     configDir,
+		srcDir:        srcDir,
+		fetch:         fetch,
+		retryInterval: retryInterval,
+		statuses:      map[string]*Status{},
+		args:          map[string][]string{},
+		failed:        map[string]time.Time{},
+	}
+}
+
+// Run syncs the worktrees every interval until stop is closed.
+func (s *Syncer) Run(interval time.Duration, stop <-chan struct{}) {
+	for {
+		if err := s.Sync(); err != nil {
+			log.Printf("error syncing the worktrees: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Sync checks out the commits of the listed PRs whose arguments changed
+// since their last checkout, retrying the failed ones, and removes the
+// worktrees of the PRs which are no longer listed.
+func (s *Syncer) Sync() error {
+	listed, err := s.readConfig()
+	if err != nil {
+		return err
+	}
+	for prefix, args := range listed {
+		if !s.due(prefix, args) {
+			continue
+		}
+		s.checkout(prefix, args)
+	}
+
+	entries, err := ioutil.ReadDir(s.srcDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		prefix := e.Name()
+		if _, found := listed[prefix]; found || strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		log.Printf("removing the worktree of %s", prefix)
+		if err := os.RemoveAll(filepath.Join(s.srcDir, prefix)); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for prefix := range s.statuses {
+		if _, found := listed[prefix]; !found {
+			delete(s.statuses, prefix)
+			delete(s.args, prefix)
+			delete(s.failed, prefix)
+		}
+	}
+	return nil
+}
+
+// readConfig returns the godoc-fetch arguments of the listed PRs keyed by
+// prefix. The kubelet keeps the files of ConfigMap volumes in dot
+// directories, which are skipped.
+func (s *Syncer) readConfig() (map[string][]string, error) {
+	entries, err := ioutil.ReadDir(s.configDir)
+	if err != nil {
+		return nil, err
+	}
+	listed := map[string][]string{}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.configDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		listed[e.Name()] = strings.Fields(string(data))
+	}
+	return listed, nil
+}
+
+// due returns true if the PR has to be checked out with args.
+func (s *Syncer) due(prefix string, args []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !reflect.DeepEqual(s.args[prefix], args) {
+		return true
+	}
+	failed, found := s.failed[prefix]
+	return found && time.Since(failed) >= s.retryInterval
+}
+
+// checkout checks out the commit of the PR in the staging directory, and
+// moves it to the prefix of the PR once fetched.
+func (s *Syncer) checkout(prefix string, args []string) {
+	status := &Status{CommitID: commitID(args)}
+	log.Printf("checking out commit %s at %s", status.CommitID, prefix)
+	paths, err := s.replace(prefix, args)
+	if err != nil {
+		log.Printf("error checking out commit %s at %s: %v", status.CommitID, prefix, err)
+		status.Failure = err.Error()
+	} else {
+		status.ImportPaths = paths
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[prefix] = status
+	s.args[prefix] = args
+	if err != nil {
+		s.failed[prefix] = time.Now()
+	} else {
+		delete(s.failed, prefix)
+	}
+}
+
+// replace fetches the commit in the staging directory and replaces the
+// worktree of the prefix with it.
+func (s *Syncer) replace(prefix string, args []string) ([]string, error) {
+	staging := filepath.Join(s.srcDir, stagingDir, prefix)
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	paths, err := s.fetch(args, staging)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	worktree := filepath.Join(s.srcDir, prefix)
+	if err := os.RemoveAll(worktree); err != nil {
+		return nil, err
+	}
+	return paths, os.Rename(staging, worktree)
+}
+
+// commitID returns the commit checked out by the godoc-fetch arguments.
+func commitID(args []string) string {
+	for i, arg := range args {
+		if arg == "-commit" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// Statuses returns a copy of the statuses of the worktrees keyed by prefix.
+func (s *Syncer) Statuses() map[string]Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := map[string]Status{}
+	for prefix, status := range s.statuses {
+		statuses[prefix] = *status
+	}
+	return statuses
+}
+
+// ServeHTTP serves the statuses of the worktrees as JSON.
+func (s *Syncer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Statuses()); err != nil {
+		log.Printf("error writing the statuses: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package godocsync
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	root, err := ioutil.TempDir("", "godocsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	configDir, srcDir := filepath.Join(root, "config"), filepath.Join(root, "src")
+	for _, dir := range []string{filepath.Join(configDir, "..data"), srcDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(prs map[string]string) {
+		entries, _ := ioutil.ReadDir(configDir)
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), ".") {
+				os.Remove(filepath.Join(configDir, e.Name()))
+			}
+		}
+		for prefix, commit := range prs {
+			args := "-clone-url\nhttps://github.com/org/repo\n-commit\n" + commit + "\n"
+			if err := ioutil.WriteFile(filepath.Join(configDir, prefix), []byte(args), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// fetch checks out a file named after the commit, commits starting
+	// with bad fail.
+	var fetched []string
+	fetch := func(args []string, src string) ([]string, error) {
+		commit := commitID(args)
+		fetched = append(fetched, commit)
+		if err := os.MkdirAll(src, 0755); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(commit, "bad") {
+			return nil, errors.New("commit not found")
+		}
+		return []string{"github.com/org/repo"}, ioutil.WriteFile(filepath.Join(src, commit), nil, 0644)
+	}
+	s := NewSyncer(configDir, srcDir, fetch, time.Hour)
+	sync := func() {
+		if err := s.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkedOut := func(prefix, commit string) bool {
+		_, err := os.Stat(filepath.Join(srcDir, prefix, commit))
+		return err == nil
+	}
+
+	list(map[string]string{"pr-1": "aaa", "pr-2": "bbb"})
+	sync()
+	if !checkedOut("pr-1", "aaa") || !checkedOut("pr-2", "bbb") {
+		t.Fatal("the listed PRs were not checked out")
+	}
+	want := map[string]Status{
+		"pr-1": {CommitID: "aaa", ImportPaths: []string{"github.com/org/repo"}},
+		"pr-2": {CommitID: "bbb", ImportPaths: []string{"github.com/org/repo"}},
+	}
+	if got := s.Statuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got statuses %+v, want %+v", got, want)
+	}
+
+	// unchanged PRs are not checked out again, new commits replace the
+	// previous ones and closed PRs are removed.
+	fetched = nil
+	list(map[string]string{"pr-1": "ccc", "pr-3": "ddd"})
+	sync()
+	if !reflect.DeepEqual(fetched, []string{"ccc", "ddd"}) && !reflect.DeepEqual(fetched, []string{"ddd", "ccc"}) {
+		t.Errorf("got commits %v checked out, want ccc and ddd", fetched)
+	}
+	if !checkedOut("pr-1", "ccc") || checkedOut("pr-1", "aaa") || !checkedOut("pr-3", "ddd") {
+		t.Error("the new commits did not replace the previous ones")
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "pr-2")); !os.IsNotExist(err) {
+		t.Error("the worktree of the closed PR was not removed")
+	}
+	if _, found := s.Statuses()["pr-2"]; found {
+		t.Error("the status of the closed PR was not removed")
+	}
+	fetched = nil
+	sync()
+	if len(fetched) != 0 {
+		t.Errorf("got commits %v checked out again", fetched)
+	}
+
+	// failures keep the previous commit and are retried after the retry
+	// interval.
+	list(map[string]string{"pr-1": "bad", "pr-3": "ddd"})
+	sync()
+	if got := s.Statuses()["pr-1"]; got.CommitID != "bad" || got.Failure != "commit not found" {
+		t.Errorf("got status %+v of a failed checkout", got)
+	}
+	if !checkedOut("pr-1", "ccc") {
+		t.Error("the previous commit was removed by a failed checkout")
+	}
+	fetched = nil
+	sync()
+	s.retryInterval = 0
+	sync()
+	if !reflect.DeepEqual(fetched, []string{"bad"}) {
+		t.Errorf("got commits %v checked out, want the failed one to be retried once", fetched)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	served := map[string]Status{}
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(served, s.Statuses()) {
+		t.Errorf("got statuses %+v served, want %+v", served, s.Statuses())
+	}
+}
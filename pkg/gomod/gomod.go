@@ -0,0 +1,48 @@
+// Package gomod reads the go.mod files of Go modules, and names the files
+// describing the modules checked out for godoc.
+package gomod
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ModuleFile is the file of the GOPATH src directory godoc-fetch writes the
+// documented import paths of its checkout to, one per line.
+const ModuleFile = ".godoc-module"
+
+// DirModulePath returns the module path of the go.mod file of dir, or an
+// empty string if it has none.
+func DirModulePath(dir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	return ModulePath(data)
+}
+
+// ModulePath returns the path declared by the module directive of the
+// content of a go.mod file, or an empty string if there is none.
+func ModulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		path := fields[1]
+		if strings.HasPrefix(path, `"`) || strings.HasPrefix(path, "`") {
+			unquoted, err := strconv.Unquote(path)
+			if err != nil {
+				return ""
+			}
+			path = unquoted
+		}
+		return path
+	}
+	return ""
+}
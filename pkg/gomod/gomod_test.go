@@ -0,0 +1,41 @@
+package gomod
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModulePath(t *testing.T) {
+	tests := []struct {
+		gomod string
+		want  string
+	}{
+		{"module example.com/api\n\nrequire example.com/dep v1.0.0\n", "example.com/api"},
+		{"// the API\nmodule \"example.com/api\" // quoted\n", "example.com/api"},
+		{"require example.com/dep v1.0.0\n", ""},
+	}
+	for _, test := range tests {
+		if got := ModulePath([]byte(test.gomod)); got != test.want {
+			t.Errorf("ModulePath(%q): got %q, want %q", test.gomod, got, test.want)
+		}
+	}
+}
+
+func TestDirModulePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if got := DirModulePath(dir); got != "" {
+		t.Errorf("got module path %q without a go.mod file", got)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/api\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := DirModulePath(dir); got != "example.com/api" {
+		t.Errorf("got module path %q, want example.com/api", got)
+	}
+}